@@ -1,5 +1,5 @@
 import * as api from './api';
-import { ActionType, GetErrorMsg } from './constants';
+import { ActionType, ErrorCode, GetErrorMsg } from './constants';
 
 
 export function getSettings(state) {
@@ -434,6 +434,17 @@ export function pull(remote, authName, authPsw) {
                 dispatch(getFiles(settings.user, settings.repo, settings.branch))
             },
             err => {
+                // branch which is up to date isn't a failure
+                if (err.code === ErrorCode.UpToDate) {
+                    dispatch(showMessage(err.message));
+                    return;
+                }
+
+                // message of failed merge is kept in details
+                if (err.details && err.details.message) {
+                    err = Object.assign({}, err, { message: `${err.message}\n${err.details.message}` });
+                }
+
                 dispatch(showError(err));
             }
         ).finally(
@@ -454,6 +465,11 @@ export function push(remote, authName, authPsw) {
                 dispatch(showMessage('Success'));
             },
             err => {
+                if (err.code === ErrorCode.UpToDate) {
+                    dispatch(showMessage(err.message));
+                    return;
+                }
+
                 dispatch(showError(err));
             }
         ).finally(
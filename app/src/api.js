@@ -27,9 +27,20 @@ function fetchApi(url, options = {}) {
                 return res;
             } else {
                 console.log(response);
-                return response.text().then(err => {
+                // errors come in {"code", "message", "details"} envelope, other responses keep plain text
+                return response.text().then(text => {
+                    let body;
+
+                    try {
+                        body = JSON.parse(text);
+                    } catch (e) {
+                        body = { message: text };
+                    }
+
                     return Promise.reject({
-                        message: err,
+                        message: body.message || text,
+                        code: body.code,
+                        details: body.details,
                         status: response.status
                     });
                 });
@@ -37,6 +37,10 @@ export const FileStatus = {
     UpdatedButUnmerged: 8
 }
 
+export const ErrorCode = {
+    UpToDate: 'up_to_date'
+}
+
 export const GetErrorMsg = err => {
     let msg = '';
 
@@ -12,6 +12,10 @@ type CredentialsPayload struct {
 type RepoRQ struct {
 	User string `json:"user"`
 	Repo string `json:"repo"`
+	//Backend, Description and DefaultBranch are used on creation only, see FsType
+	Backend       int    `json:"backend,omitempty"`
+	Description   string `json:"description,omitempty"`
+	DefaultBranch string `json:"defaultBranch,omitempty"`
 }
 
 //RepositoriesRQ - the request for repositories operation
@@ -19,9 +23,23 @@ type RepositoriesRQ struct {
 	User string `json:"user"`
 }
 
-//RepositoriesRS - the response to repositories request
+//RepositoriesRS - the response to repositories request, Repos keeps names of Items
 type RepositoriesRS struct {
-	Repos []string `json:"repos"`
+	Repos []string       `json:"repos"`
+	Items []RepositoryRS `json:"items"`
+	Total int            `json:"total"`
+}
+
+//RepositoryRS - repository from catalog
+type RepositoryRS struct {
+	Owner         string    `json:"owner"`
+	Name          string    `json:"name"`
+	Backend       int       `json:"backend"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Description   string    `json:"description"`
+	DefaultBranch string    `json:"defaultBranch"`
+	Size          int64     `json:"size"`
+	ForkOf        string    `json:"forkOf,omitempty"`
 }
 
 //RepoRS - base info about repository
@@ -136,6 +154,18 @@ type CloneRQ struct {
 	User string              `json:"user"`
 	Auth *CredentialsPayload `json:"auth,omitempty"`
 	URL  string              `json:"URL"`
+	CloneOptionsRQ
+}
+
+//CloneOptionsRQ - options of clone, empty fields get default values
+type CloneOptionsRQ struct {
+	Name         string `json:"name,omitempty"`
+	Remote       string `json:"remote,omitempty"`
+	Depth        int    `json:"depth,omitempty"`
+	SingleBranch bool   `json:"singleBranch,omitempty"`
+	Branch       string `json:"branch,omitempty"`
+	Tag          string `json:"tag,omitempty"`
+	NoSubmodules bool   `json:"noSubmodules,omitempty"`
 }
 
 // CommitRQ - request for commit operation
@@ -156,6 +186,7 @@ type PushRQ struct {
 	Base   *BaseRequestRQ      `json:"base"`
 	Auth   *CredentialsPayload `json:"auth,omitempty"`
 	Remote string              `json:"remote"`
+	Force  bool                `json:"force"`
 }
 
 // MergeRQ - request for merge operation
@@ -169,6 +200,21 @@ type MergeRS struct {
 	IsFastforward bool   `json:"isFF"`
 }
 
+//FileChangeRS - file changed by merge
+type FileChangeRS struct {
+	Path   string       `json:"path"`
+	Action ChangeAction `json:"action"`
+}
+
+//MergePreviewRS - the response to merge preview request
+type MergePreviewRS struct {
+	Base          string         `json:"base"`
+	IsFastforward bool           `json:"isFF"`
+	IsUpToDate    bool           `json:"isUpToDate"`
+	Changes       []FileChangeRS `json:"changes"`
+	Conflicts     []string       `json:"conflicts"`
+}
+
 type AbortMergeRQ struct {
 	Base *BaseRequestRQ `json:"base"`
 }
@@ -189,3 +235,347 @@ type BaseRequestRQ struct {
 	Repository string `json:"repo"`
 	Branch     string `json:"branch"`
 }
+
+//MergeRequestRQ - request for creating merge request
+type MergeRequestRQ struct {
+	User        string `json:"user"`
+	Repo        string `json:"repo"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+}
+
+//MergeRequestActionRQ - request for approve, merge, close or reopen merge request,
+//merge commit is authored by Author with Email
+type MergeRequestActionRQ struct {
+	User   string `json:"user"`
+	Repo   string `json:"repo"`
+	Author string `json:"author"`
+	Email  string `json:"email"`
+}
+
+//MergeRequestCommentRQ - request for commenting merge request
+type MergeRequestCommentRQ struct {
+	User   string `json:"user"`
+	Repo   string `json:"repo"`
+	Author string `json:"author"`
+	Text   string `json:"text"`
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+}
+
+//MergeRequestCommentRS - merge request comment
+type MergeRequestCommentRS struct {
+	ID        int64     `json:"id"`
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	Path      string    `json:"path"`
+	Line      int       `json:"line"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//MergeRequestRS - merge request information
+type MergeRequestRS struct {
+	ID          int64                   `json:"id"`
+	Title       string                  `json:"title"`
+	Description string                  `json:"description"`
+	Source      string                  `json:"source"`
+	Target      string                  `json:"target"`
+	Author      string                  `json:"author"`
+	Status      MergeRequestStatus      `json:"status"`
+	Approvals   []string                `json:"approvals"`
+	Comments    []MergeRequestCommentRS `json:"comments"`
+	MergeCommit string                  `json:"mergeCommit"`
+	MergedBy    string                  `json:"mergedBy,omitempty"`
+	CreatedAt   time.Time               `json:"createdAt"`
+	UpdatedAt   time.Time               `json:"updatedAt"`
+}
+
+//MergeRequestsRS - the response to merge requests list request
+type MergeRequestsRS struct {
+	MergeRequests []MergeRequestRS `json:"mergeRequests"`
+}
+
+//BranchProtectionRQ - request for setting protection rule
+type BranchProtectionRQ struct {
+	User                string   `json:"user"`
+	Repo                string   `json:"repo"`
+	Branch              string   `json:"branch"`
+	NoDeletion          bool     `json:"noDeletion"`
+	NoForcePush         bool     `json:"noForcePush"`
+	RequireMergeRequest bool     `json:"requireMergeRequest"`
+	Committers          []string `json:"committers"`
+	RequiredApprovals   int      `json:"requiredApprovals"`
+}
+
+//BranchProtectionRS - protection rule of branches
+type BranchProtectionRS struct {
+	Branch              string   `json:"branch"`
+	NoDeletion          bool     `json:"noDeletion"`
+	NoForcePush         bool     `json:"noForcePush"`
+	RequireMergeRequest bool     `json:"requireMergeRequest"`
+	Committers          []string `json:"committers"`
+	RequiredApprovals   int      `json:"requiredApprovals"`
+}
+
+//BranchProtectionsRS - the response to protection rules request
+type BranchProtectionsRS struct {
+	Protections []BranchProtectionRS `json:"protections"`
+}
+
+//HookRulesRQ - request for setting built-in hook rules of repository
+type HookRulesRQ struct {
+	User           string   `json:"user"`
+	Repo           string   `json:"repo"`
+	MessagePattern string   `json:"messagePattern"`
+	ForbiddenPaths []string `json:"forbiddenPaths"`
+	MaxFileSize    int64    `json:"maxFileSize"`
+	SecretPatterns []string `json:"secretPatterns"`
+}
+
+//HookRulesRS - built-in hook rules of repository
+type HookRulesRS struct {
+	MessagePattern string   `json:"messagePattern"`
+	ForbiddenPaths []string `json:"forbiddenPaths"`
+	MaxFileSize    int64    `json:"maxFileSize"`
+	SecretPatterns []string `json:"secretPatterns"`
+}
+
+//ViolationRS - rule violated by change
+type ViolationRS struct {
+	Hook    string `json:"hook"`
+	Rule    string `json:"rule"`
+	Path    string `json:"path"`
+	Message string `json:"msg"`
+}
+
+//ErrorRS - the response for every failed request.
+//Details keeps violations when hooks reject operation and error specific values otherwise
+type ErrorRS struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+//EventRS - repository event
+type EventRS struct {
+	Type   EventType         `json:"type"`
+	User   string            `json:"user"`
+	Repo   string            `json:"repo"`
+	Branch string            `json:"branch,omitempty"`
+	Commit string            `json:"commit,omitempty"`
+	Time   time.Time         `json:"time"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+//WebhookRQ - request for creating webhook
+type WebhookRQ struct {
+	User   string      `json:"user"`
+	Repo   string      `json:"repo"`
+	URL    string      `json:"url"`
+	Secret string      `json:"secret"`
+	Events []EventType `json:"events"`
+}
+
+//RemoveWebhookRQ - request for removing webhook
+type RemoveWebhookRQ struct {
+	User string `json:"user"`
+	Repo string `json:"repo"`
+	ID   int64  `json:"id"`
+}
+
+//WebhookRS - webhook information, secret is never returned
+type WebhookRS struct {
+	ID        int64       `json:"id"`
+	URL       string      `json:"url"`
+	HasSecret bool        `json:"hasSecret"`
+	Events    []EventType `json:"events"`
+	Active    bool        `json:"active"`
+}
+
+//WebhooksRS - the response to webhooks request
+type WebhooksRS struct {
+	Webhooks []WebhookRS `json:"webhooks"`
+}
+
+//WebhookDeliveryRS - result of event delivery to webhook
+type WebhookDeliveryRS struct {
+	ID          int64     `json:"id"`
+	WebhookID   int64     `json:"webhookId"`
+	Event       EventType `json:"event"`
+	URL         string    `json:"url"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"statusCode"`
+	Error       string    `json:"error"`
+	Success     bool      `json:"success"`
+	DeliveredAt time.Time `json:"deliveredAt"`
+}
+
+//WebhookDeliveriesRS - the response to webhook deliveries request
+type WebhookDeliveriesRS struct {
+	Deliveries []WebhookDeliveryRS `json:"deliveries"`
+}
+
+//JobRQ - request for submitting clone, fetch, pull or push job
+type JobRQ struct {
+	User   string              `json:"user"`
+	Type   JobType             `json:"type"`
+	Repo   string              `json:"repo"`
+	Branch string              `json:"branch"`
+	URL    string              `json:"URL"`
+	Remote string              `json:"remote"`
+	Force  bool                `json:"force"`
+	Auth   *CredentialsPayload `json:"auth,omitempty"`
+	//Clone - options of clone job
+	Clone *CloneOptionsRQ `json:"clone,omitempty"`
+}
+
+//CancelJobRQ - request for job cancellation
+type CancelJobRQ struct {
+	User string `json:"user"`
+}
+
+//JobProgressRS - the latest progress of job
+type JobProgressRS struct {
+	Stage        string `json:"stage"`
+	Percent      int    `json:"percent"`
+	Objects      int64  `json:"objects"`
+	TotalObjects int64  `json:"totalObjects"`
+	Bytes        int64  `json:"bytes"`
+	Message      string `json:"message"`
+}
+
+//JobRS - job state
+type JobRS struct {
+	ID         int64         `json:"id"`
+	Type       JobType       `json:"type"`
+	User       string        `json:"user"`
+	Repo       string        `json:"repo"`
+	Branch     string        `json:"branch"`
+	URL        string        `json:"URL"`
+	Remote     string        `json:"remote"`
+	Force      bool          `json:"force"`
+	Status     JobStatus     `json:"status"`
+	Progress   JobProgressRS `json:"progress"`
+	Result     string        `json:"result"`
+	Error      string        `json:"error"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+}
+
+//JobsRS - the response to jobs list request
+type JobsRS struct {
+	Jobs []JobRS `json:"jobs"`
+}
+
+//GCRS - the response to garbage collection request
+type GCRS struct {
+	SizeBefore int64 `json:"sizeBefore"`
+	SizeAfter  int64 `json:"sizeAfter"`
+	Reclaimed  int64 `json:"reclaimed"`
+	Pruned     int   `json:"pruned"`
+	Repacked   bool  `json:"repacked"`
+}
+
+//VerifyRS - the response to repository integrity check
+type VerifyRS struct {
+	OK        bool     `json:"ok"`
+	Objects   int      `json:"objects"`
+	Missing   []string `json:"missing"`
+	Corrupted []string `json:"corrupted"`
+	Dangling  []string `json:"dangling"`
+	Index     []string `json:"index"`
+}
+
+//RestoreRQ - request for restoring repository, it's taken from trash if Backup is empty
+type RestoreRQ struct {
+	User   string `json:"user"`
+	Repo   string `json:"repo"`
+	Backup string `json:"backup"`
+}
+
+//TrashRS - removed repositories which can be restored
+type TrashRS struct {
+	Items []TrashItemRS `json:"items"`
+}
+
+//TrashItemRS - removed repository, it's purged at PurgeAt
+type TrashItemRS struct {
+	Name      string    `json:"name"`
+	Backend   int       `json:"backend"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
+
+//BackupRS - bundle of repository in backup directory
+type BackupRS struct {
+	ID        string    `json:"id"`
+	Repo      string    `json:"repo"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+}
+
+//BackupsRS - backups of repository, the newest first
+type BackupsRS struct {
+	Backups []BackupRS `json:"backups"`
+}
+
+//QuotaRS - limits of user, zero means no limit
+type QuotaRS struct {
+	UserBytes int64 `json:"userBytes"`
+	RepoBytes int64 `json:"repoBytes"`
+	Repos     int   `json:"repos"`
+}
+
+//RepoUsageRS - storage taken by repository in bytes
+type RepoUsageRS struct {
+	Repo          string `json:"repo"`
+	ObjectBytes   int64  `json:"objectBytes"`
+	WorktreeBytes int64  `json:"worktreeBytes"`
+}
+
+//UsageRS - storage taken by user and its quota
+type UsageRS struct {
+	User          string        `json:"user"`
+	Repos         int           `json:"repos"`
+	ObjectBytes   int64         `json:"objectBytes"`
+	WorktreeBytes int64         `json:"worktreeBytes"`
+	Items         []RepoUsageRS `json:"items"`
+	Quota         QuotaRS       `json:"quota"`
+}
+
+//UsagesRS - usage of all users
+type UsagesRS struct {
+	Users []UsageRS `json:"users"`
+}
+
+//QuotaRQ - request for setting quota of user, Reset returns the default one
+type QuotaRQ struct {
+	User      string `json:"user"`
+	UserBytes int64  `json:"userBytes"`
+	RepoBytes int64  `json:"repoBytes"`
+	Repos     int    `json:"repos"`
+	Reset     bool   `json:"reset"`
+}
+
+//ForkRQ - request for fork of repository, Name of fork is the name of source by default
+type ForkRQ struct {
+	User   string `json:"user"`
+	Repo   string `json:"repo"`
+	ToUser string `json:"toUser"`
+	Name   string `json:"name"`
+}
+
+//ForkRS - repository forked from another one
+type ForkRS struct {
+	Owner     string    `json:"owner"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//ForksRS - forks of repository
+type ForksRS struct {
+	Forks []ForkRS `json:"forks"`
+}
@@ -2,19 +2,170 @@ package contract
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"time"
 )
 
+//ErrorCode - kind of error, server chooses HTTP status by it
+type ErrorCode string
+
+const (
+	ErrorCodeInternal    ErrorCode = "internal"
+	ErrorCodeValidation  ErrorCode = "validation"
+	ErrorCodeNotFound    ErrorCode = "not_found"
+	ErrorCodeConflict    ErrorCode = "conflict"
+	ErrorCodeAuth        ErrorCode = "auth"
+	ErrorCodeForbidden   ErrorCode = "forbidden"
+	ErrorCodeUpstream    ErrorCode = "upstream"
+	ErrorCodeUpToDate    ErrorCode = "up_to_date"
+	ErrorCodeCanceled    ErrorCode = "canceled"
+	ErrorCodeUnavailable ErrorCode = "unavailable"
+	ErrorCodeQuota       ErrorCode = "quota_exceeded"
+)
+
+//Error - typed error returned by service, Err keeps the original error if there is one
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Details map[string]string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+
+	return e.Message
+}
+
+//Unwrap - returns the original error, so errors.Is works with errors of go-git
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+//NewError - creates error with formatted message
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+//WrapError - creates error of specified code which keeps message of err
+func WrapError(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+//ErrorCodeOf - returns code of err, untyped errors are internal
+func ErrorCodeOf(err error) ErrorCode {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed.Code
+	}
+
+	var protection *BranchProtectionError
+	if errors.As(err, &protection) {
+		return ErrorCodeForbidden
+	}
+
+	var hook *HookError
+	if errors.As(err, &hook) {
+		return ErrorCodeValidation
+	}
+
+	return ErrorCodeInternal
+}
+
 //ErrGitRepositoryNotSet - occurs when repository wasn't chosen
-var ErrGitRepositoryNotSet = errors.New("Git repository isn't set")
+var ErrGitRepositoryNotSet = NewError(ErrorCodeValidation, "Git repository isn't set")
+
+//ErrMergeRequestNotFound - occurs when merge request with specified id doesn't exist
+var ErrMergeRequestNotFound = NewError(ErrorCodeNotFound, "Merge request not found")
+
+//ErrMergeRequestConflicts - occurs when merge request cannot be merged without resolving conflicts
+var ErrMergeRequestConflicts = NewError(ErrorCodeConflict, "Merge request has conflicts")
+
+//ErrJobNotFound - occurs when job with specified id doesn't exist
+var ErrJobNotFound = NewError(ErrorCodeNotFound, "Job not found")
+
+//ErrNothingToCommit - occurs when worktree has no changes for commit
+var ErrNothingToCommit = NewError(ErrorCodeConflict, "Nothing to commit, working tree clean")
+
+//BranchProtectionError - occurs when operation violates branch protection rule
+type BranchProtectionError struct {
+	Branch string
+	Reason string
+}
+
+func (e *BranchProtectionError) Error() string {
+	return fmt.Sprintf("Branch %s is protected: %s", e.Branch, e.Reason)
+}
+
+//HookError - occurs when hooks reject operation, contains every violation found
+type HookError struct {
+	Violations []Violation
+}
+
+func (e *HookError) Error() string {
+	msgs := []string{}
+
+	for _, v := range e.Violations {
+		if v.Path != "" {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", v.Path, v.Message))
+		} else {
+			msgs = append(msgs, v.Message)
+		}
+	}
+
+	return fmt.Sprintf("Rejected by hooks: %s", strings.Join(msgs, "; "))
+}
 
 //ServerSettings - common server settings
 type ServerSettings struct {
 	Port       string
 	GitConnStr string
+	PgConnStr  string
+	SQLitePath string
 	GitRoot    string
 	FsType     FsType
+	S3         S3Settings
+	//MySQLLayout - how repositories are kept in MySQL, two tables per repository by default
+	MySQLLayout MySQLLayout
+	//GCInterval - how often garbage collection of all repositories runs, zero disables it
+	GCInterval time.Duration
+	//GCGracePeriod - unreachable objects younger than it are kept, they can be written by running operation
+	GCGracePeriod time.Duration
+	//TrashRetention - how long removed repositories can be restored, zero removes them immediately
+	TrashRetention time.Duration
+	//BackupDir - local directory for bundles of repositories, backups are disabled if it's empty
+	BackupDir string
+	//BackupInterval - how often all repositories are backed up, zero disables scheduled backups
+	BackupInterval time.Duration
+	//BackupKeep - how many the newest backups of repository are kept
+	BackupKeep int
+	//Quota - limits of every user, they can be changed per user
+	Quota Quota
+	//AdminToken - bearer token of /api/admin routes, they're disabled if it's empty
+	AdminToken string
+}
+
+//MySQLLayout - tables of MySQL backend
+type MySQLLayout string
+
+const (
+	//MySQLLayoutTables - files_<user>_<repo> and git_<user>_<repo> tables for every repository
+	MySQLLayoutTables MySQLLayout = "tables"
+	//MySQLLayoutShared - fixed set of tables where files are keyed by id of repository
+	MySQLLayoutShared MySQLLayout = "shared"
+)
+
+//S3Settings - bucket of S3-compatible store, endpoint file:///path keeps objects in local directory instead
+type S3Settings struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Secure    bool
 }
 
 // BaseRequest - rq for most git operations
@@ -24,6 +175,114 @@ type BaseRequest struct {
 	Branch     string
 }
 
+//RepoOptions - options of new repository, empty fields get default values
+type RepoOptions struct {
+	//Backend - where repository lives, backend chosen in settings by default
+	Backend       FsType
+	Description   string
+	DefaultBranch string
+}
+
+//CloneOptions - options of clone, empty fields get default values
+type CloneOptions struct {
+	//Name - name of a new repository, it's taken from URL by default
+	Name string
+	//Remote - name of remote repository is cloned from, origin by default
+	Remote string
+	//Depth - number of the latest commits fetched, whole history is fetched if it's 0
+	Depth int
+	//SingleBranch - only branch which is checked out is fetched
+	SingleBranch bool
+	//Branch or Tag - what is checked out instead of HEAD of remote, only one of them can be set.
+	//Checked out tag leaves HEAD detached
+	Branch string
+	Tag    string
+	//NoSubmodules - submodules aren't cloned
+	NoSubmodules bool
+}
+
+//Repository - repository as it's kept in catalog, Size is size of its storage in bytes
+type Repository struct {
+	Owner         string
+	Name          string
+	Backend       FsType
+	CreatedAt     time.Time
+	Description   string
+	DefaultBranch string
+	Size          int64
+	//ObjectBytes, WorktreeBytes - usage of repository counted by quota, they're kept with Size
+	ObjectBytes   int64
+	WorktreeBytes int64
+	//DeletedAt - when repository was moved to trash, zero for repositories in use
+	DeletedAt time.Time
+	//ForkOf - owner/name of repository it was forked from, empty for others
+	ForkOf string
+}
+
+//Fork - repository forked from another one
+type Fork struct {
+	Owner     string
+	Name      string
+	CreatedAt time.Time
+}
+
+//Backup - git bundle of repository kept in backup directory, ID is the time it was made
+type Backup struct {
+	ID        string
+	Repo      string
+	CreatedAt time.Time
+	Size      int64
+}
+
+//Quota - limits of user storage, sizes are in bytes of objects and worktrees. Zero field means no limit
+type Quota struct {
+	//UserBytes - all repositories of user
+	UserBytes int64
+	//RepoBytes - every repository
+	RepoBytes int64
+	Repos     int
+}
+
+//RepoUsage - storage taken by repository in bytes
+type RepoUsage struct {
+	Repo          string
+	ObjectBytes   int64
+	WorktreeBytes int64
+}
+
+//Usage - storage taken by repositories of user, Quota is the one applied to user
+type Usage struct {
+	User          string
+	Repos         int
+	ObjectBytes   int64
+	WorktreeBytes int64
+	Items         []RepoUsage
+	Quota         Quota
+}
+
+//RepositorySort - field repositories are ordered by
+type RepositorySort string
+
+const (
+	RepositorySortName    RepositorySort = "name"
+	RepositorySortCreated RepositorySort = "created"
+	RepositorySortSize    RepositorySort = "size"
+)
+
+//RepositoryQuery - sorting and pagination of repositories, all of them are returned if Limit is 0
+type RepositoryQuery struct {
+	Sort   RepositorySort
+	Desc   bool
+	Offset int
+	Limit  int
+}
+
+//RepositoryPage - part of repositories, Total is quantity of all of them
+type RepositoryPage struct {
+	Repos []Repository
+	Total int
+}
+
 //User -  current user information
 type User struct {
 	Name  string
@@ -73,6 +332,268 @@ type MergeFile struct {
 	Reader io.Reader
 }
 
+//ChangeAction - kind of file change between two revisions
+type ChangeAction int
+
+const (
+	ChangeActionUnspecified ChangeAction = 0
+	ChangeActionAdded       ChangeAction = 1
+	ChangeActionModified    ChangeAction = 2
+	ChangeActionDeleted     ChangeAction = 3
+)
+
+//FileChange - file changed between two revisions
+type FileChange struct {
+	Path   string
+	Action ChangeAction
+}
+
+//MergePreview - result of merge computed without touching the worktree
+type MergePreview struct {
+	Base          string
+	IsFastForward bool
+	IsUpToDate    bool
+	Changes       []FileChange
+	Conflicts     []string
+}
+
+//MergeRequestStatus - state of merge request
+type MergeRequestStatus int
+
+const (
+	MergeRequestStatusInvalid MergeRequestStatus = 0
+	MergeRequestStatusOpen    MergeRequestStatus = 1
+	MergeRequestStatusMerged  MergeRequestStatus = 2
+	MergeRequestStatusClosed  MergeRequestStatus = 3
+)
+
+//MergeRequest - request to merge source branch into target branch
+type MergeRequest struct {
+	ID          int64
+	Title       string
+	Description string
+	Source      string
+	Target      string
+	Author      string
+	Status      MergeRequestStatus
+	Approvals   []string
+	Comments    []MergeRequestComment
+	MergeCommit string
+	MergedBy    string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+//MergeRequestComment - comment to merge request, Path and Line are set when comment is tied to file
+type MergeRequestComment struct {
+	ID        int64
+	Author    string
+	Text      string
+	Path      string
+	Line      int
+	CreatedAt time.Time
+}
+
+//MergeRequestFilter - filter for merge requests list, empty fields are ignored
+type MergeRequestFilter struct {
+	Status MergeRequestStatus
+	Source string
+	Target string
+	Author string
+}
+
+//BranchProtection - protection rule of branches which names match Branch pattern (path.Match syntax)
+type BranchProtection struct {
+	Branch              string
+	NoDeletion          bool
+	NoForcePush         bool
+	RequireMergeRequest bool
+	//Committers - users allowed to change branch, everybody if empty
+	Committers []string
+	//RequiredApprovals - approvals of users other than author merge request needs to be merged into branch
+	RequiredApprovals int
+}
+
+//Hook kinds
+const (
+	HookPreCommit = "pre-commit"
+	HookCommitMsg = "commit-msg"
+	HookPreMerge  = "pre-merge"
+)
+
+//Violation - rule violated by change, Path is empty when rule isn't related to file
+type Violation struct {
+	Hook    string
+	Rule    string
+	Path    string
+	Message string
+}
+
+//HookFile - file which is going to get into repository
+type HookFile struct {
+	Path string
+	Size int64
+	//Content - beginning of file, hooks get at most 1 MiB of it
+	Content []byte
+}
+
+//HookRules - built-in validation rules of repository, empty rules are skipped
+type HookRules struct {
+	//MessagePattern - regexp which commit messages must match
+	MessagePattern string
+	//ForbiddenPaths - path.Match patterns of files which cannot be added or changed.
+	//Pattern without slashes matches file name in any directory, "**" matches any number of directories
+	ForbiddenPaths []string
+	//MaxFileSize - max size of file in bytes
+	MaxFileSize int64
+	//SecretPatterns - regexps which mustn't be found in files content
+	SecretPatterns []string
+}
+
+//EventType - kind of repository event
+type EventType string
+
+const (
+	EventRepoCreated   EventType = "repo.created"
+	EventRepoCloned    EventType = "repo.cloned"
+	EventRepoForked    EventType = "repo.forked"
+	EventRepoRemoved   EventType = "repo.removed"
+	EventCommit        EventType = "commit"
+	EventBranchCreated EventType = "branch.created"
+	EventBranchRemoved EventType = "branch.removed"
+	EventMerge         EventType = "merge"
+	EventPush          EventType = "push"
+	EventPull          EventType = "pull"
+	EventStatus        EventType = "status"
+	EventCheckout      EventType = "checkout"
+	EventReset         EventType = "reset"
+	//EventProgress - progress of clone, fetch, pull or push, it's sent to webhooks only if they subscribe to it explicitly
+	EventProgress EventType = "progress"
+)
+
+//Event - something happened with repository, Data keeps event specific values (remote, theirs branch etc.)
+type Event struct {
+	Type   EventType
+	User   string
+	Repo   string
+	Branch string
+	Commit string
+	Time   time.Time
+	Data   map[string]string
+}
+
+//Webhook - subscription of URL to repository events, all events are sent if Events is empty
+type Webhook struct {
+	ID     int64
+	URL    string
+	Secret string
+	Events []EventType
+	Active bool
+}
+
+//WebhookDelivery - result of event delivery to webhook
+type WebhookDelivery struct {
+	ID          int64
+	WebhookID   int64
+	Event       EventType
+	URL         string
+	Attempts    int
+	StatusCode  int
+	Error       string
+	Success     bool
+	DeliveredAt time.Time
+}
+
+//ArchiveFormat - format of repository archive
+type ArchiveFormat string
+
+const (
+	ArchiveFormatZip   ArchiveFormat = "zip"
+	ArchiveFormatTarGz ArchiveFormat = "tar.gz"
+)
+
+//GCResult - outcome of garbage collection, sizes of repository storage are in bytes.
+//Repacked is false when staged files keep objects which aren't reachable from refs
+type GCResult struct {
+	SizeBefore int64
+	SizeAfter  int64
+	Reclaimed  int64
+	Pruned     int
+	Repacked   bool
+}
+
+//VerifyReport - result of repository integrity check. Objects is the number of objects reachable from refs,
+//Missing, Corrupted and Dangling keep hashes of objects, Index keeps problems of index
+type VerifyReport struct {
+	Objects   int
+	Missing   []string
+	Corrupted []string
+	Dangling  []string
+	Index     []string
+}
+
+//OK - repository has neither missing nor corrupted objects and its index is valid, dangling objects are fine
+func (r *VerifyReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Corrupted) == 0 && len(r.Index) == 0
+}
+
+//JobType - kind of long-running operation
+type JobType string
+
+const (
+	JobTypeClone  JobType = "clone"
+	JobTypeFetch  JobType = "fetch"
+	JobTypePull   JobType = "pull"
+	JobTypePush   JobType = "push"
+	JobTypeGC     JobType = "gc"
+	JobTypeBackup JobType = "backup"
+)
+
+//JobStatus - state of job
+type JobStatus int
+
+const (
+	JobStatusInvalid   JobStatus = 0
+	JobStatusQueued    JobStatus = 1
+	JobStatusRunning   JobStatus = 2
+	JobStatusSucceeded JobStatus = 3
+	JobStatusFailed    JobStatus = 4
+	JobStatusCanceled  JobStatus = 5
+)
+
+//JobProgress - the latest progress reported by remote server
+type JobProgress struct {
+	//Stage - e.g. "Receiving objects"
+	Stage        string
+	Percent      int
+	Objects      int64
+	TotalObjects int64
+	Bytes        int64
+	Message      string
+}
+
+//Job - clone, fetch, pull or push which runs in background.
+//Repo is filled for clone after it's finished, Result keeps pull message
+type Job struct {
+	ID         int64
+	Type       JobType
+	User       string
+	Repo       string
+	Branch     string
+	URL        string
+	Remote     string
+	Force      bool
+	Status     JobStatus
+	Progress   JobProgress
+	Result     string
+	Error      string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	//Clone - options of clone job, can be nil
+	Clone *CloneOptions
+}
+
 //FileInfo - common information about files in repository
 type FileInfo struct {
 	Path       string
@@ -83,9 +604,13 @@ type FileInfo struct {
 type FsType int
 
 const (
-	FsTypeInvalid FsType = 0
-	FsTypeMySQL   FsType = 1
-	FsTypeLocal   FsType = 2
+	FsTypeInvalid  FsType = 0
+	FsTypeMySQL    FsType = 1
+	FsTypeLocal    FsType = 2
+	FsTypePostgres FsType = 3
+	FsTypeSQLite   FsType = 4
+	FsTypeMemory   FsType = 5
+	FsTypeS3       FsType = 6
 )
 
 func ToFsType(t int) FsType {
@@ -95,6 +620,14 @@ func ToFsType(t int) FsType {
 		return FsTypeMySQL
 	case 2:
 		return FsTypeLocal
+	case 3:
+		return FsTypePostgres
+	case 4:
+		return FsTypeSQLite
+	case 5:
+		return FsTypeMemory
+	case 6:
+		return FsTypeS3
 	default:
 		return FsTypeInvalid
 	}
@@ -0,0 +1,368 @@
+package gitsvc
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/cache"
+	"bitbucket.org/vishjosh/bipp-go-git/storage/filesystem"
+	"github.com/jmoiron/sqlx"
+)
+
+//backfillRetryInterval - how often catalog backfill is retried when backend isn't reachable
+const backfillRetryInterval = time.Minute
+
+//startBackfill - adds repositories created before catalog appeared to it in background, it's retried until
+//it succeeds, so server starts even if database isn't reachable yet
+func (svc *service) startBackfill() {
+	svc.workers.Add(1)
+
+	go func() {
+		defer svc.workers.Done()
+
+		for {
+			err := svc.backfillCatalog(context.Background())
+			if err == nil {
+				return
+			}
+
+			log.Printf("Cannot add repositories to catalog: %v\n", err)
+
+			select {
+			case <-svc.done:
+				return
+			case <-time.After(backfillRetryInterval):
+			}
+		}
+	}()
+}
+
+//backfillCatalog - adds repositories found in storage of backend chosen in settings to catalog, repositories
+//which are there already are skipped, so it can run on every start
+func (svc *service) backfillCatalog(ctx context.Context) error {
+	repos, err := svc.legacyRepos(ctx)
+	if err != nil {
+		return err
+	}
+
+	for user, names := range repos {
+		for _, repo := range names {
+			select {
+			case <-svc.done:
+				return nil
+			default:
+			}
+
+			err = svc.backfillRepo(ctx, user, repo)
+			if err != nil {
+				log.Printf("Cannot add repo: %s, user: %s to catalog, error: %v\n", repo, user, err)
+			}
+		}
+	}
+
+	svc.catalog.Lock()
+	defer svc.catalog.Unlock()
+
+	svc.scanned = true
+
+	return nil
+}
+
+//backfillRepo - repository is opened like by any other operation, it's registered in catalog when it's opened
+func (svc *service) backfillRepo(ctx context.Context, user, repo string) error {
+	svc.ops.Lock()
+	defer svc.ops.Unlock()
+
+	svc.waitIdle(user, repo)
+
+	r, err := svc.repos.get(ctx, user, repo)
+	if err != nil || r != nil {
+		return err
+	}
+
+	//tables of removed repositories can stay, they are empty
+	_, err = svc.openRepo(user, repo)
+	if contract.ErrorCodeOf(err) == contract.ErrorCodeNotFound {
+		return nil
+	}
+
+	return err
+}
+
+//catalogBackfilled - until backfill is done, repositories which aren't in catalog are looked for in storage
+func (svc *service) catalogBackfilled() bool {
+	svc.catalog.Lock()
+	defer svc.catalog.Unlock()
+
+	return svc.scanned
+}
+
+//legacyRepos - returns repositories in storage of backend chosen in settings by users. Directories of GitRoot,
+//objects of bucket and tables of current database schema are scanned, memory backend keeps nothing between starts
+func (svc *service) legacyRepos(ctx context.Context) (map[string][]string, error) {
+	switch svc.settings.FsType {
+	case contract.FsTypeMySQL:
+		{
+			if svc.db == nil {
+				return nil, contract.NewError(contract.ErrorCodeUnavailable, "MySQL isn't configured")
+			}
+
+			if svc.sharedLayout() {
+				return svc.sharedRepos(ctx)
+			}
+
+			return svc.tableRepos(ctx, svc.db, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()")
+		}
+	case contract.FsTypePostgres:
+		{
+			if svc.pg == nil {
+				return nil, contract.NewError(contract.ErrorCodeUnavailable, "Postgres isn't configured")
+			}
+
+			return svc.tableRepos(ctx, svc.pg, "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()")
+		}
+	case contract.FsTypeSQLite:
+		{
+			if svc.sqlite == nil {
+				return nil, contract.NewError(contract.ErrorCodeUnavailable, "SQLite isn't configured")
+			}
+
+			return svc.tableRepos(ctx, svc.sqlite, "SELECT name FROM sqlite_master WHERE type = 'table'")
+		}
+	case contract.FsTypeS3:
+		{
+			if svc.objects == nil {
+				return nil, contract.NewError(contract.ErrorCodeUnavailable, "S3 isn't configured")
+			}
+
+			users, err := svc.objects.List(ctx, "", false)
+			if err != nil {
+				return nil, err
+			}
+
+			res := map[string][]string{}
+
+			for _, u := range users {
+				if !u.Dir || u.Key == metaRoot {
+					continue
+				}
+
+				repos, err := svc.objects.List(ctx, u.Key+"/", false)
+				if err != nil {
+					return nil, err
+				}
+
+				for _, r := range repos {
+					if r.Dir {
+						res[u.Key] = append(res[u.Key], path.Base(r.Key))
+					}
+				}
+			}
+
+			return res, nil
+		}
+	case contract.FsTypeLocal:
+		{
+			users, err := subdirs(svc.settings.GitRoot)
+			if err != nil {
+				return nil, err
+			}
+
+			res := map[string][]string{}
+
+			for _, user := range users {
+				if user == metaRoot {
+					continue
+				}
+
+				repos, err := subdirs(filepath.Join(svc.settings.GitRoot, user))
+				if err != nil {
+					return nil, err
+				}
+
+				res[user] = repos
+			}
+
+			return res, nil
+		}
+	default:
+		return map[string][]string{}, nil
+	}
+}
+
+//legacyRepo - checks that repository which isn't in catalog is in storage
+func (svc *service) legacyRepo(ctx context.Context, user, repo string) (bool, error) {
+	found, err := svc.legacyStorage(ctx, user, repo)
+	if err != nil || !found {
+		return false, err
+	}
+
+	fs, gitFs, err := svc.backendFs(svc.settings.FsType, user, repo)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = git.Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), gitFs)
+	if err == git.ErrRepositoryNotExists {
+		return false, nil
+	}
+
+	return err == nil, toError(err)
+}
+
+//legacyStorage - checks that storage of repository exists, nothing is created on the way
+func (svc *service) legacyStorage(ctx context.Context, user, repo string) (bool, error) {
+	err := validateStorageNames(user, repo)
+	if err != nil {
+		return false, err
+	}
+
+	switch svc.settings.FsType {
+	case contract.FsTypeMySQL, contract.FsTypePostgres, contract.FsTypeSQLite:
+		{
+			if svc.settings.FsType == contract.FsTypeMySQL && svc.sharedLayout() {
+				_, err = svc.repoID(user, repo, false)
+				if contract.ErrorCodeOf(err) == contract.ErrorCodeNotFound {
+					return false, nil
+				}
+
+				return err == nil, err
+			}
+
+			repos, err := svc.legacyRepos(ctx)
+			if err != nil {
+				return false, err
+			}
+
+			//owner of table can be ambiguous, so table name is compared too
+			for u, names := range repos {
+				for _, name := range names {
+					if gitPrefix+u+"_"+name == gitPrefix+user+"_"+repo {
+						return true, nil
+					}
+				}
+			}
+
+			return false, nil
+		}
+	case contract.FsTypeS3:
+		{
+			if svc.objects == nil {
+				return false, contract.NewError(contract.ErrorCodeUnavailable, "S3 isn't configured")
+			}
+
+			objects, err := svc.objects.List(ctx, user+"/"+repo+"/", false)
+			if err != nil {
+				return false, err
+			}
+
+			return len(objects) > 0, nil
+		}
+	case contract.FsTypeLocal:
+		{
+			fi, err := os.Stat(filepath.Join(svc.settings.GitRoot, user, repo))
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+
+			if err != nil {
+				return false, err
+			}
+
+			return fi.IsDir(), nil
+		}
+	default:
+		return false, nil
+	}
+}
+
+//sharedRepos - repositories of shared MySQL layout are in its registry
+func (svc *service) sharedRepos(ctx context.Context) (map[string][]string, error) {
+	rows := []struct {
+		Owner string `db:"owner"`
+		Name  string `db:"name"`
+	}{}
+
+	err := svc.db.SelectContext(ctx, &rows, "SELECT owner, name FROM "+sharedReposTable)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string][]string{}
+
+	for _, r := range rows {
+		res[r.Owner] = append(res[r.Owner], r.Name)
+	}
+
+	return res, nil
+}
+
+//tableRepos - finds repositories by names of their git_ tables, files_ tables belong to the same repositories.
+//Table git_a_b_c is given to known user with the longest name, if no user is known, name of user
+//is taken up to the first underscore, tables with other underscores are skipped
+func (svc *service) tableRepos(ctx context.Context, db *sqlx.DB, query string) (map[string][]string, error) {
+	tables := []string{}
+
+	err := db.SelectContext(ctx, &tables, query)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := svc.layoutUsers(ctx, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string][]string{}
+
+	for _, t := range tables {
+		if !strings.HasPrefix(t, gitPrefix) {
+			continue
+		}
+
+		owner := ownerOfTable(t, users)
+
+		if owner == "" {
+			parts := strings.Split(strings.TrimPrefix(t, gitPrefix), "_")
+			if len(parts) != 2 {
+				log.Printf("Cannot find owner of table: %s, open its repository to add it to catalog\n", t)
+				continue
+			}
+
+			owner = parts[0]
+		}
+
+		res[owner] = append(res[owner], strings.TrimPrefix(t, gitPrefix+owner+"_"))
+	}
+
+	return res, nil
+}
+
+func subdirs(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	res := []string{}
+
+	for _, fi := range infos {
+		if fi.IsDir() {
+			res = append(res, fi.Name())
+		}
+	}
+
+	return res, nil
+}
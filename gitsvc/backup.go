@@ -0,0 +1,244 @@
+package gitsvc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+)
+
+//backupIDFormat - ID of backup is UTC time it was made, so names of files are ordered by time
+const backupIDFormat = "20060102T150405.000000Z"
+
+const backupExt = ".bundle"
+
+//backupMetaExt - metadata of repository (protections, webhooks, merge requests) isn't part of bundle,
+//it's kept next to it, backups made before it appeared have bundle only
+const backupMetaExt = ".meta.json"
+
+//backupDir - returns directory with backups of repository, names become parts of path, so they can't leave it
+func (svc *service) backupDir(user, repo string) (string, error) {
+	if svc.settings.BackupDir == "" {
+		return "", contract.NewError(contract.ErrorCodeUnavailable, "Backup directory isn't configured")
+	}
+
+	for _, name := range []string{user, repo} {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return "", contract.NewError(contract.ErrorCodeValidation, "Wrong name %s", name)
+		}
+	}
+
+	return filepath.Join(svc.settings.BackupDir, user, repo), nil
+}
+
+//Backup - writes bundle of repository to backup directory, only BackupKeep the newest backups are kept
+func (svc *service) Backup(ctx context.Context, user, repo string) (*contract.Backup, error) {
+	dir, err := svc.backupDir(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	//bundle is written to temporary file first, so half-written backup is never listed
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(f.Name())
+
+	err = svc.Export(ctx, user, repo, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := svc.readMetaDocs(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	id := now.Format(backupIDFormat)
+
+	//metadata is in place before bundle is, so listed backup is complete
+	err = writeFileAtomic(dir, id+backupMetaExt, data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(f.Name(), filepath.Join(dir, id+backupExt))
+	if err != nil {
+		os.Remove(filepath.Join(dir, id+backupMetaExt))
+		return nil, err
+	}
+
+	backups, err := svc.Backups(ctx, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := svc.settings.BackupKeep
+	if keep > 0 && len(backups) > keep {
+		for _, b := range backups[keep:] {
+			err = os.Remove(filepath.Join(dir, b.ID+backupExt))
+			if err != nil {
+				return nil, err
+			}
+
+			err = os.Remove(filepath.Join(dir, b.ID+backupMetaExt))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	for _, b := range backups {
+		if b.ID == id {
+			return &b, nil
+		}
+	}
+
+	return &contract.Backup{ID: id, Repo: repo, CreatedAt: now}, nil
+}
+
+//Backups - returns backups of repository, the newest first
+func (svc *service) Backups(ctx context.Context, user, repo string) ([]contract.Backup, error) {
+	dir, err := svc.backupDir(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []contract.Backup{}, nil
+		}
+
+		return nil, err
+	}
+
+	res := []contract.Backup{}
+
+	for _, fi := range infos {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), backupExt) {
+			continue
+		}
+
+		id := strings.TrimSuffix(fi.Name(), backupExt)
+
+		created, err := time.Parse(backupIDFormat, id)
+		if err != nil {
+			continue
+		}
+
+		res = append(res, contract.Backup{ID: id, Repo: repo, CreatedAt: created, Size: fi.Size()})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.After(res[j].CreatedAt) })
+
+	return res, nil
+}
+
+//RestoreRepository - returns repository from trash if backup is empty, otherwise recreates it from backup
+//together with its metadata
+func (svc *service) RestoreRepository(ctx context.Context, user, repo, backup string) error {
+	if user == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	if backup == "" {
+		err := svc.restoreTrashed(ctx, user, repo)
+		if err != nil {
+			return err
+		}
+
+		svc.publish(contract.EventRepoCreated, user, repo, "", "", map[string]string{"source": "trash"})
+
+		return nil
+	}
+
+	dir, err := svc.backupDir(user, repo)
+	if err != nil {
+		return err
+	}
+
+	_, err = time.Parse(backupIDFormat, backup)
+	if err != nil {
+		return contract.NewError(contract.ErrorCodeValidation, "Wrong backup %s", backup)
+	}
+
+	f, err := os.Open(filepath.Join(dir, backup+backupExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return contract.NewError(contract.ErrorCodeNotFound, "Backup %s of repository %s not found", backup, repo)
+		}
+
+		return err
+	}
+
+	defer f.Close()
+
+	docs := map[string]json.RawMessage{}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, backup+backupMetaExt))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &docs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return svc.importRepo(ctx, user, repo, f, docs)
+}
+
+//writeFileAtomic - file is written to temporary one first, so it's never seen half-written
+func writeFileAtomic(dir, name string, data []byte) error {
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(dir, name))
+}
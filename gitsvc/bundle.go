@@ -0,0 +1,531 @@
+package gitsvc
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/cache"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/filemode"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/format/packfile"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/object"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/revlist"
+	"bitbucket.org/vishjosh/bipp-go-git/storage/filesystem"
+)
+
+//bundleSignature - the first line of git bundle, v2 is understood by every git version
+const bundleSignature = "# v2 git bundle"
+
+//packWindow - how many objects are compared while looking for deltas
+const packWindow = 10
+
+//Export - writes git bundle with all refs of repository, it can be read by Import or `git clone`
+func (svc *service) Export(ctx context.Context, user, repo string, w io.Writer) error {
+	if user == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
+	if err != nil {
+		return err
+	}
+
+	r, err := svc.openRepo(user, repo)
+	if err != nil {
+		return err
+	}
+
+	//bundle is streamed to client, so other operations don't wait for it
+	return svc.transfer(user, repo, func() error {
+		return writeBundle(ctx, repo, r.repo, w)
+	})
+}
+
+//writeBundle - refs go to header of bundle, objects reachable from them go to its pack
+func writeBundle(ctx context.Context, name string, r *git.Repository, w io.Writer) error {
+	iter, err := r.References()
+	if err != nil {
+		return err
+	}
+
+	refs := []*plumbing.Reference{}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			refs = append(refs, ref)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(refs) == 0 {
+		return contract.NewError(contract.ErrorCodeConflict, "Repository %s is empty", name)
+	}
+
+	//bundle must be complete, history of shallow clone isn't
+	shallows, err := r.Storer.Shallow()
+	if err != nil {
+		return err
+	}
+
+	if len(shallows) > 0 {
+		return contract.NewError(contract.ErrorCodeConflict, "Repository %s is a shallow clone, it can't be exported", name)
+	}
+
+	//HEAD goes first, so git knows which branch to check out after clone
+	head, err := r.Head()
+	if err == nil {
+		refs = append([]*plumbing.Reference{plumbing.NewHashReference(plumbing.HEAD, head.Hash())}, refs...)
+	}
+
+	tips := []plumbing.Hash{}
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, bundleSignature)
+
+	for _, ref := range refs {
+		fmt.Fprintf(bw, "%s %s\n", ref.Hash(), ref.Name())
+		tips = append(tips, ref.Hash())
+	}
+
+	fmt.Fprintln(bw)
+
+	hashes, err := revlist.Objects(r.Storer, tips, nil)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	_, err = packfile.NewEncoder(bw, r.Storer, false).Encode(hashes, packWindow)
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+//Import - creates a new repository from git bundle
+func (svc *service) Import(ctx context.Context, user, repo string, bundle io.Reader) error {
+	return svc.importRepo(ctx, user, repo, bundle, nil)
+}
+
+//importRepo - docs are documents of repository metadata by names, they're written before repository is announced
+func (svc *service) importRepo(ctx context.Context, user, repo string, bundle io.Reader, docs map[string]json.RawMessage) error {
+	if user == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	err := validateRepoName(repo)
+	if err != nil {
+		return err
+	}
+
+	exists, err := svc.repoExists(ctx, user, repo)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return contract.NewError(contract.ErrorCodeConflict, "Repository %s already exists", repo)
+	}
+
+	err = svc.checkTrash(user, repo)
+	if err != nil {
+		return err
+	}
+
+	err = svc.checkQuota(ctx, user, repo, 0, true)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(bundle)
+
+	refs, err := readBundleHeader(r)
+	if err != nil {
+		return err
+	}
+
+	err = svc.SwitchUser(ctx, &contract.User{Name: user})
+	if err != nil {
+		return err
+	}
+
+	fs, gitFs, err := svc.newBackendFs(svc.settings.FsType, user, repo)
+	if err != nil {
+		return err
+	}
+
+	//storage which isn't in catalog can still keep files, failed import mustn't remove them
+	empty, err := storageEmpty(fs, gitFs)
+	if err != nil {
+		return err
+	}
+
+	if !empty {
+		return contract.NewError(contract.ErrorCodeConflict, "Storage of repository %s isn't empty", repo)
+	}
+
+	st := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	gitRepo, err := svc.importBundle(st, gitFs, refs, r)
+	if err != nil {
+		//repository isn't in catalog yet
+		delErr := svc.deleteBackendRepo(svc.settings.FsType, user, repo)
+		if delErr != nil {
+			log.Printf("Cannot remove repo: %s, user: %s, error: %v\n", repo, user, delErr)
+		}
+
+		return err
+	}
+
+	svc.git = &repository{name: repo, fs: gitFs, repo: gitRepo, backend: svc.settings.FsType}
+
+	err = svc.addRepo(&contract.Repository{Owner: user, Name: repo, Backend: svc.settings.FsType, CreatedAt: time.Now(), DefaultBranch: headBranch(gitRepo)})
+	if err != nil {
+		return err
+	}
+
+	err = svc.writeMetaDocs(user, repo, docs)
+
+	//size of repository is known only when it's imported, repository which doesn't fit is removed
+	if err == nil {
+		err = svc.checkQuota(ctx, user, repo, 0, false)
+	}
+
+	if err != nil {
+		svc.git = nil
+
+		delErr := svc.deleteRepo(user, repo)
+		if delErr != nil {
+			log.Printf("Cannot remove repo: %s, user: %s, error: %v\n", repo, user, delErr)
+		}
+
+		return err
+	}
+
+	svc.publishHead(ctx, contract.EventRepoCreated, user, repo, map[string]string{"source": "bundle"})
+
+	return nil
+}
+
+func (svc *service) importBundle(st *filesystem.Storage, gitFs billy.Filesystem, refs []*plumbing.Reference, pack io.Reader) (*git.Repository, error) {
+	r, err := git.Init(st, gitFs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = packfile.UpdateObjectStorage(st, pack)
+	if err != nil {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Wrong bundle packfile: %v", err)
+	}
+
+	//crafted bundle can have refs which point to objects missing in its pack
+	for _, ref := range refs {
+		if st.HasEncodedObject(ref.Hash()) != nil {
+			return nil, contract.NewError(contract.ErrorCodeValidation, "Ref %s of bundle points to missing object %s", ref.Name(), ref.Hash())
+		}
+	}
+
+	var head plumbing.Hash
+	branches := []plumbing.ReferenceName{}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref.Hash()
+			continue
+		}
+
+		err = st.SetReference(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref.Name().IsBranch() {
+			branches = append(branches, ref.Name())
+		}
+	}
+
+	if len(branches) == 0 {
+		return r, nil
+	}
+
+	//HEAD points to the branch it pointed in exported repository, master is preferred if there are several
+	branch := branches[0]
+
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && ref.Hash() == head && (branch != plumbing.Master || ref.Name() == plumbing.Master) {
+			branch = ref.Name()
+		}
+	}
+
+	err = st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+	if err != nil {
+		return nil, err
+	}
+
+	wt, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	err = wt.Checkout(&git.CheckoutOptions{Branch: branch, Force: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//readBundleHeader - reads refs of bundle and leaves reader at the beginning of packfile
+func readBundleHeader(r *bufio.Reader) ([]*plumbing.Reference, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != bundleSignature {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Wrong bundle signature, only v2 bundles are supported")
+	}
+
+	refs := []*plumbing.Reference{}
+
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, contract.NewError(contract.ErrorCodeValidation, "Wrong bundle header: %v", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "" {
+			break
+		}
+
+		//prerequisites mean the bundle is incremental and needs objects we don't have
+		if strings.HasPrefix(line, "-") {
+			return nil, contract.NewError(contract.ErrorCodeValidation, "Incremental bundles aren't supported")
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 || len(parts[0]) != 40 || !validRefName(parts[1]) {
+			return nil, contract.NewError(contract.ErrorCodeValidation, "Wrong bundle ref %s", line)
+		}
+
+		refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(parts[1]), plumbing.NewHash(parts[0])))
+	}
+
+	if len(refs) == 0 {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Bundle has no refs")
+	}
+
+	return refs, nil
+}
+
+//validRefName - checks name of ref as `git check-ref-format` does, only HEAD and names under refs/ are accepted,
+//so bundle can't write files outside of refs directory
+func validRefName(name string) bool {
+	if name == plumbing.HEAD.String() {
+		return true
+	}
+
+	if !strings.HasPrefix(name, "refs/") || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") || strings.ContainsAny(name, " ~^:?*[\\") {
+		return false
+	}
+
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Archive - writes files of revision as zip or tar.gz archive, files are put into directory named as repository
+func (svc *service) Archive(ctx context.Context, user, repo, rev string, format contract.ArchiveFormat, w io.Writer) error {
+	if user == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	if format != contract.ArchiveFormatZip && format != contract.ArchiveFormatTarGz {
+		return contract.NewError(contract.ErrorCodeValidation, "Wrong archive format %s", format)
+	}
+
+	if rev == "" {
+		rev = string(plumbing.HEAD)
+	}
+
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
+	if err != nil {
+		return err
+	}
+
+	r, err := svc.openRepo(user, repo)
+	if err != nil {
+		return err
+	}
+
+	//archive is streamed to client, so other operations don't wait for it
+	return svc.transfer(user, repo, func() error {
+		return writeArchive(ctx, repo, r.repo, rev, format, w)
+	})
+}
+
+func writeArchive(ctx context.Context, name string, r *git.Repository, rev string, format contract.ArchiveFormat, w io.Writer) error {
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return toError(err)
+	}
+
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return toError(err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	if format == contract.ArchiveFormatZip {
+		return writeZip(ctx, name+"/", commit, tree, w)
+	}
+
+	return writeTarGz(ctx, name+"/", commit, tree, w)
+}
+
+func writeZip(ctx context.Context, prefix string, commit *object.Commit, tree *object.Tree, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := tree.Files().ForEach(func(f *object.File) error {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		mode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+
+		h := &zip.FileHeader{Name: prefix + f.Name, Method: zip.Deflate, Modified: commit.Committer.When}
+		h.SetMode(mode)
+
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+
+		return copyBlob(fw, f)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeTarGz(ctx context.Context, prefix string, commit *object.Commit, tree *object.Tree, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := tree.Files().ForEach(func(f *object.File) error {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		mode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+
+		h := &tar.Header{
+			Name:     prefix + f.Name,
+			Mode:     int64(mode.Perm()),
+			Size:     f.Size,
+			ModTime:  commit.Committer.When,
+			Typeflag: tar.TypeReg,
+		}
+
+		//symlink keeps its target as content
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+
+			h.Typeflag = tar.TypeSymlink
+			h.Linkname = target
+			h.Size = 0
+		}
+
+		err = tw.WriteHeader(h)
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag == tar.TypeSymlink {
+			return nil
+		}
+
+		return copyBlob(tw, f)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+func copyBlob(w io.Writer, f *object.File) error {
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+
+	return err
+}
@@ -0,0 +1,274 @@
+package gitsvc
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+)
+
+const catalogMeta = "repos"
+
+//repoBackend - returns backend of repository, repositories which aren't in catalog
+//(created before it appeared or not created yet) live in backend chosen in settings
+func (svc *service) repoBackend(user, repo string) (contract.FsType, error) {
+	r, err := svc.repos.get(context.Background(), user, repo)
+	if err != nil {
+		return contract.FsTypeInvalid, err
+	}
+
+	if r == nil {
+		return svc.settings.FsType, nil
+	}
+
+	if !r.DeletedAt.IsZero() {
+		return contract.FsTypeInvalid, contract.NewError(contract.ErrorCodeNotFound, "Repository %s is in trash", repo)
+	}
+
+	return r.Backend, nil
+}
+
+//addRepo - adds repository to catalog or replaces it, size and usage are calculated here
+func (svc *service) addRepo(r *contract.Repository) error {
+	size, err := svc.repoSize(r.Backend, r.Owner, r.Name)
+	if err != nil {
+		return err
+	}
+
+	u, err := svc.repoUsage(r.Backend, r.Owner, r.Name)
+	if err != nil {
+		return err
+	}
+
+	r.Size = size
+	r.ObjectBytes = u.ObjectBytes
+	r.WorktreeBytes = u.WorktreeBytes
+
+	return svc.repos.put(context.Background(), r)
+}
+
+//registerRepo - repositories created before catalog appeared are added to it when they're opened
+func (svc *service) registerRepo(user, repo string, backend contract.FsType, r *git.Repository) error {
+	e, err := svc.repos.get(context.Background(), user, repo)
+	if err != nil || e != nil {
+		return err
+	}
+
+	return svc.addRepo(&contract.Repository{Owner: user, Name: repo, Backend: backend, DefaultBranch: headBranch(r)})
+}
+
+//setRepoBackend - records new backend of repository
+func (svc *service) setRepoBackend(user, repo string, backend contract.FsType) error {
+	ok, err := svc.repos.set(context.Background(), user, repo, false, catalogBackend, backend)
+	if err != nil || ok {
+		return err
+	}
+
+	return svc.repos.put(context.Background(), &contract.Repository{Owner: user, Name: repo, Backend: backend, CreatedAt: time.Now()})
+}
+
+//removeRepo - forgets repository
+func (svc *service) removeRepo(user, repo string) error {
+	_, err := svc.repos.remove(context.Background(), user, repo, false)
+	if err != nil {
+		return err
+	}
+
+	svc.catalog.Lock()
+	defer svc.catalog.Unlock()
+
+	delete(svc.stale, user+"/"+repo)
+
+	return nil
+}
+
+//catalogRepos - returns repositories of user, sizes and usage changed since the last call are recalculated
+func (svc *service) catalogRepos(ctx context.Context, user string) ([]*contract.Repository, error) {
+	err := svc.refreshSizes(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.repos.list(ctx, user, false)
+}
+
+//refreshSizes - recalculates sizes and usage of repositories of user which changed since the last call,
+//the others keep the ones from catalog, so storage isn't walked on every write
+func (svc *service) refreshSizes(ctx context.Context, user string) error {
+	svc.catalog.Lock()
+	defer svc.catalog.Unlock()
+
+	for key := range svc.stale {
+		if !strings.HasPrefix(key, user+"/") {
+			continue
+		}
+
+		repo := strings.TrimPrefix(key, user+"/")
+
+		r, err := svc.repos.get(ctx, user, repo)
+		if err != nil {
+			return err
+		}
+
+		if r == nil || !r.DeletedAt.IsZero() {
+			delete(svc.stale, key)
+			continue
+		}
+
+		size, err := svc.repoSize(r.Backend, user, repo)
+		if err != nil {
+			log.Printf("Cannot calculate size of repo: %s, user: %s, error: %v\n", repo, user, err)
+			continue
+		}
+
+		u, err := svc.repoUsage(r.Backend, user, repo)
+		if err != nil {
+			log.Printf("Cannot calculate usage of repo: %s, user: %s, error: %v\n", repo, user, err)
+			continue
+		}
+
+		err = svc.repos.setSizes(ctx, user, repo, size, u)
+		if err != nil {
+			return err
+		}
+
+		delete(svc.stale, key)
+	}
+
+	return nil
+}
+
+//handleCatalogEvent - marks size of repository as changed when new objects or files could appear in it
+func (svc *service) handleCatalogEvent(e contract.Event) {
+	switch e.Type {
+	case contract.EventCommit, contract.EventMerge, contract.EventPull, contract.EventStatus, contract.EventCheckout, contract.EventReset:
+		svc.touchRepo(e.User, e.Repo)
+	}
+}
+
+//touchRepo - size and usage of repository are recalculated on the next listing or quota check
+func (svc *service) touchRepo(user, repo string) {
+	svc.catalog.Lock()
+	defer svc.catalog.Unlock()
+
+	svc.stale[user+"/"+repo] = true
+}
+
+//repoSize - returns size of repository storage (objects, refs, index and metadata) in bytes
+func (svc *service) repoSize(backend contract.FsType, user, repo string) (int64, error) {
+	fs, _, err := svc.backendFs(backend, user, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	return dirSize(fs, "")
+}
+
+func dirSize(fs billy.Filesystem, dir string) (int64, error) {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	var size int64
+
+	for _, fi := range infos {
+		if !fi.IsDir() {
+			size += fi.Size()
+			continue
+		}
+
+		s, err := dirSize(fs, fs.Join(dir, fi.Name()))
+		if err != nil {
+			return 0, err
+		}
+
+		size += s
+	}
+
+	return size, nil
+}
+
+//headBranch - returns branch HEAD points to, it works for repositories without commits too
+func headBranch(r *git.Repository) string {
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return ""
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short()
+	}
+
+	return ""
+}
+
+//repoLess - orders repositories by field of query, name is the second key
+func repoLess(sortBy contract.RepositorySort, a, b *contract.Repository) bool {
+	switch sortBy {
+	case contract.RepositorySortCreated:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+	case contract.RepositorySortSize:
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	}
+
+	return a.Name < b.Name
+}
+
+// Repositories - returns page of repositories of user, q can be nil to get all of them ordered by name
+func (svc *service) Repositories(ctx context.Context, user string, q *contract.RepositoryQuery) (*contract.RepositoryPage, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty")
+	}
+
+	if q == nil {
+		q = &contract.RepositoryQuery{}
+	}
+
+	switch q.Sort {
+	case "", contract.RepositorySortName, contract.RepositorySortCreated, contract.RepositorySortSize:
+	default:
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Wrong sort field %s", q.Sort)
+	}
+
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Offset and limit cannot be negative")
+	}
+
+	//sizes of changed repositories are recalculated before they're sorted
+	err := svc.refreshSizes(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.repos.page(ctx, user, q)
+}
+
+//repoExists - checks that repository is in catalog, repository in trash doesn't exist. Until catalog
+//is backfilled, repository which isn't there is looked for in storage
+func (svc *service) repoExists(ctx context.Context, user, repo string) (bool, error) {
+	r, err := svc.repos.get(ctx, user, repo)
+	if err != nil {
+		return false, err
+	}
+
+	if r == nil && !svc.catalogBackfilled() {
+		return svc.legacyRepo(ctx, user, repo)
+	}
+
+	return r != nil && r.DeletedAt.IsZero(), nil
+}
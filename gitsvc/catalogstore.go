@@ -0,0 +1,647 @@
+package gitsvc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"github.com/jmoiron/sqlx"
+)
+
+//catalogTable - catalog of repositories in SQL backends, it's shared by all users
+const catalogTable = "gitapp_catalog"
+
+//catalogField - field of catalog entry which can be changed, values are names of columns of catalogTable
+type catalogField string
+
+const (
+	catalogBackend   catalogField = "backend"
+	catalogForkOf    catalogField = "fork_of"
+	catalogDeletedAt catalogField = "deleted_at"
+)
+
+//catalogStore - keeps repositories of users. Repository in trash keeps its entry with DeletedAt set,
+//so its name stays taken until it's purged
+type catalogStore interface {
+	//users - returns users who have repositories in catalog or in trash ordered by name
+	users(ctx context.Context) ([]string, error)
+
+	//get - returns repository which is in catalog or in trash, nil if there is no such repository
+	get(ctx context.Context, user, repo string) (*contract.Repository, error)
+
+	//list - returns repositories of user ordered by name, removed ones if trash is set
+	list(ctx context.Context, user string, trash bool) ([]*contract.Repository, error)
+
+	//page - returns page of repositories of user which aren't in trash, query is validated by caller
+	page(ctx context.Context, user string, q *contract.RepositoryQuery) (*contract.RepositoryPage, error)
+
+	//put - adds repository or replaces one with the same name
+	put(ctx context.Context, r *contract.Repository) error
+
+	//set - changes field of repository which is in catalog or in trash if trash is set,
+	//false is returned if there is no such repository
+	set(ctx context.Context, user, repo string, trash bool, f catalogField, v interface{}) (bool, error)
+
+	//setSizes - changes size and usage of repository which is in catalog together
+	setSizes(ctx context.Context, user, repo string, size int64, u *contract.RepoUsage) error
+
+	//remove - forgets repository which is in catalog or in trash if trash is set,
+	//false is returned if there is no such repository
+	remove(ctx context.Context, user, repo string, trash bool) (bool, error)
+}
+
+//newCatalog - catalog lives in backend chosen in settings, SQL backends keep it in table,
+//others have no queries, so there it's json document of user metadata
+func (svc *service) newCatalog() (catalogStore, error) {
+	switch svc.settings.FsType {
+	case contract.FsTypeMySQL:
+		{
+			if svc.db == nil {
+				return nil, contract.NewError(contract.ErrorCodeUnavailable, "MySQL isn't configured")
+			}
+
+			return &sqlCatalog{db: svc.db}, nil
+		}
+	case contract.FsTypePostgres:
+		{
+			if svc.pg == nil {
+				return nil, contract.NewError(contract.ErrorCodeUnavailable, "Postgres isn't configured")
+			}
+
+			return &sqlCatalog{db: svc.pg}, nil
+		}
+	case contract.FsTypeSQLite:
+		{
+			if svc.sqlite == nil {
+				return nil, contract.NewError(contract.ErrorCodeUnavailable, "SQLite isn't configured")
+			}
+
+			return &sqlCatalog{db: svc.sqlite}, nil
+		}
+	case contract.FsTypeMemory, contract.FsTypeS3, contract.FsTypeLocal:
+		return &docCatalog{svc: svc}, nil
+	default:
+		return nil, fmt.Errorf("Wrong fsType = %d", svc.settings.FsType)
+	}
+}
+
+//sqlCatalog - catalog in table, every change is one statement, so processes sharing database don't lose
+//changes of each other. Table is created on the first use, so server starts even if database isn't reachable yet
+type sqlCatalog struct {
+	db      *sqlx.DB
+	mu      sync.Mutex
+	created bool
+}
+
+type catalogRow struct {
+	Owner         string `db:"owner"`
+	Name          string `db:"name"`
+	Backend       int    `db:"backend"`
+	CreatedAt     int64  `db:"created_at"`
+	Description   string `db:"description"`
+	DefaultBranch string `db:"default_branch"`
+	Size          int64  `db:"size"`
+	ObjectBytes   int64  `db:"object_bytes"`
+	WorktreeBytes int64  `db:"worktree_bytes"`
+	DeletedAt     int64  `db:"deleted_at"`
+	ForkOf        string `db:"fork_of"`
+}
+
+const catalogColumns = "owner, name, backend, created_at, description, default_branch, size, object_bytes, worktree_bytes, deleted_at, fork_of"
+
+//catalogOrder - columns of sort fields, name is the second key
+var catalogOrder = map[contract.RepositorySort]string{
+	"":                             "name",
+	contract.RepositorySortName:    "name",
+	contract.RepositorySortCreated: "created_at",
+	contract.RepositorySortSize:    "size",
+}
+
+func (c *sqlCatalog) create(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.created {
+		return nil
+	}
+
+	_, err := c.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+catalogTable+` (
+		owner VARCHAR(255) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		backend INTEGER NOT NULL,
+		created_at BIGINT NOT NULL,
+		description TEXT NOT NULL,
+		default_branch VARCHAR(255) NOT NULL,
+		size BIGINT NOT NULL,
+		object_bytes BIGINT NOT NULL,
+		worktree_bytes BIGINT NOT NULL,
+		deleted_at BIGINT NOT NULL,
+		fork_of TEXT NOT NULL,
+		PRIMARY KEY (owner, name)
+	)`)
+
+	if err != nil {
+		return err
+	}
+
+	c.created = true
+
+	return nil
+}
+
+func (c *sqlCatalog) users(ctx context.Context) ([]string, error) {
+	err := c.create(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []string{}
+
+	err = c.db.SelectContext(ctx, &users, "SELECT DISTINCT owner FROM "+catalogTable+" ORDER BY owner ASC")
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (c *sqlCatalog) get(ctx context.Context, user, repo string) (*contract.Repository, error) {
+	err := c.create(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	row := catalogRow{}
+
+	err = c.db.GetContext(ctx, &row, c.db.Rebind("SELECT "+catalogColumns+" FROM "+catalogTable+" WHERE owner = ? AND name = ?"), user, repo)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return row.repository(), nil
+}
+
+func (c *sqlCatalog) list(ctx context.Context, user string, trash bool) ([]*contract.Repository, error) {
+	err := c.create(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []catalogRow{}
+
+	err = c.db.SelectContext(ctx, &rows, c.db.Rebind("SELECT "+catalogColumns+" FROM "+catalogTable+" WHERE owner = ? AND "+trashCond(trash)+" ORDER BY name ASC"), user)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*contract.Repository{}
+
+	for i := range rows {
+		res = append(res, rows[i].repository())
+	}
+
+	return res, nil
+}
+
+func (c *sqlCatalog) page(ctx context.Context, user string, q *contract.RepositoryQuery) (*contract.RepositoryPage, error) {
+	err := c.create(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &contract.RepositoryPage{Repos: []contract.Repository{}}
+
+	err = c.db.GetContext(ctx, &page.Total, c.db.Rebind("SELECT COUNT(*) FROM "+catalogTable+" WHERE owner = ? AND deleted_at = 0"), user)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := "ASC"
+	if q.Desc {
+		dir = "DESC"
+	}
+
+	order := catalogOrder[q.Sort] + " " + dir
+	if q.Sort != "" && q.Sort != contract.RepositorySortName {
+		order += ", name " + dir
+	}
+
+	//OFFSET can't go without LIMIT in MySQL and SQLite
+	limit := int64(math.MaxInt64)
+	if q.Limit > 0 {
+		limit = int64(q.Limit)
+	}
+
+	rows := []catalogRow{}
+
+	err = c.db.SelectContext(ctx, &rows, c.db.Rebind("SELECT "+catalogColumns+" FROM "+catalogTable+" WHERE owner = ? AND deleted_at = 0 ORDER BY "+order+" LIMIT ? OFFSET ?"), user, limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		page.Repos = append(page.Repos, *rows[i].repository())
+	}
+
+	return page, nil
+}
+
+func (c *sqlCatalog) put(ctx context.Context, r *contract.Repository) error {
+	err := c.create(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM "+catalogTable+" WHERE owner = ? AND name = ?"), r.Owner, r.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO "+catalogTable+" ("+catalogColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		r.Owner, r.Name, int(r.Backend), unixNano(r.CreatedAt), r.Description, r.DefaultBranch, r.Size, r.ObjectBytes, r.WorktreeBytes, unixNano(r.DeletedAt), r.ForkOf)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *sqlCatalog) set(ctx context.Context, user, repo string, trash bool, f catalogField, v interface{}) (bool, error) {
+	err := c.create(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	switch value := v.(type) {
+	case time.Time:
+		v = unixNano(value)
+	case contract.FsType:
+		v = int(value)
+	}
+
+	res, err := c.db.ExecContext(ctx, c.db.Rebind("UPDATE "+catalogTable+" SET "+string(f)+" = ? WHERE owner = ? AND name = ? AND "+trashCond(trash)), v, user, repo)
+	if err != nil {
+		return false, err
+	}
+
+	return affected(res)
+}
+
+func (c *sqlCatalog) setSizes(ctx context.Context, user, repo string, size int64, u *contract.RepoUsage) error {
+	err := c.create(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.ExecContext(ctx, c.db.Rebind("UPDATE "+catalogTable+" SET size = ?, object_bytes = ?, worktree_bytes = ? WHERE owner = ? AND name = ? AND deleted_at = 0"),
+		size, u.ObjectBytes, u.WorktreeBytes, user, repo)
+
+	return err
+}
+
+func (c *sqlCatalog) remove(ctx context.Context, user, repo string, trash bool) (bool, error) {
+	err := c.create(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := c.db.ExecContext(ctx, c.db.Rebind("DELETE FROM "+catalogTable+" WHERE owner = ? AND name = ? AND "+trashCond(trash)), user, repo)
+	if err != nil {
+		return false, err
+	}
+
+	return affected(res)
+}
+
+//affected - MySQL counts rows which are really changed, so callers which need the result never set the same value
+func affected(res sql.Result) (bool, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func trashCond(trash bool) string {
+	if trash {
+		return "deleted_at <> 0"
+	}
+
+	return "deleted_at = 0"
+}
+
+func (r *catalogRow) repository() *contract.Repository {
+	return &contract.Repository{
+		Owner:         r.Owner,
+		Name:          r.Name,
+		Backend:       contract.FsType(r.Backend),
+		CreatedAt:     fromUnixNano(r.CreatedAt),
+		Description:   r.Description,
+		DefaultBranch: r.DefaultBranch,
+		Size:          r.Size,
+		ObjectBytes:   r.ObjectBytes,
+		WorktreeBytes: r.WorktreeBytes,
+		DeletedAt:     fromUnixNano(r.DeletedAt),
+		ForkOf:        r.ForkOf,
+	}
+}
+
+//unixNano - zero time is kept as 0, it means repository isn't in trash
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n)
+}
+
+//docCatalog - catalog as json document of user metadata, it's read and written as a whole under lock,
+//so it's sorted and paged in memory and it's safe for one process only
+type docCatalog struct {
+	svc *service
+	mu  sync.Mutex
+}
+
+//catalog - repositories of user as they are kept in metadata
+type catalog struct {
+	Repos []*contract.Repository
+	//Trash - repositories removed by older versions, they are moved to Repos with DeletedAt set when document is read
+	Trash []*contract.Repository `json:",omitempty"`
+}
+
+//read - must be called under lock
+func (c *docCatalog) read(user string) (*catalog, error) {
+	doc := &catalog{}
+
+	err := c.svc.readUserMeta(user, catalogMeta, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Repos = append(doc.Repos, doc.Trash...)
+	doc.Trash = nil
+
+	return doc, nil
+}
+
+//find - returns index of repository which is in catalog or in trash if trash is set, -1 if there is no such repository
+func (doc *catalog) find(repo string, trash bool) int {
+	for i, r := range doc.Repos {
+		if r.Name == repo && r.DeletedAt.IsZero() != trash {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *docCatalog) users(ctx context.Context) ([]string, error) {
+	svc := c.svc
+
+	switch svc.settings.FsType {
+	case contract.FsTypeMemory:
+		return svc.memory.userNames(), nil
+	case contract.FsTypeS3:
+		{
+			objects, err := svc.objects.List(ctx, metaRoot+"/", false)
+			if err != nil {
+				return nil, err
+			}
+
+			users := []string{}
+
+			for _, o := range objects {
+				if o.Dir {
+					users = append(users, path.Base(o.Key))
+				}
+			}
+
+			return users, nil
+		}
+	case contract.FsTypeLocal:
+		{
+			infos, err := ioutil.ReadDir(filepath.Join(svc.settings.GitRoot, metaRoot))
+			if err != nil {
+				if os.IsNotExist(err) {
+					return []string{}, nil
+				}
+
+				return nil, err
+			}
+
+			users := []string{}
+
+			for _, fi := range infos {
+				if fi.IsDir() {
+					users = append(users, fi.Name())
+				}
+			}
+
+			return users, nil
+		}
+	default:
+		return nil, fmt.Errorf("Wrong fsType = %d", svc.settings.FsType)
+	}
+}
+
+func (c *docCatalog) get(ctx context.Context, user, repo string) (*contract.Repository, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, err := c.read(user)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range doc.Repos {
+		if r.Name == repo {
+			return r, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *docCatalog) list(ctx context.Context, user string, trash bool) ([]*contract.Repository, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, err := c.read(user)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*contract.Repository{}
+
+	for _, r := range doc.Repos {
+		if r.DeletedAt.IsZero() != trash {
+			res = append(res, r)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res, nil
+}
+
+func (c *docCatalog) page(ctx context.Context, user string, q *contract.RepositoryQuery) (*contract.RepositoryPage, error) {
+	repos, err := c.list(ctx, user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []contract.Repository{}
+
+	for _, r := range repos {
+		items = append(items, *r)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if q.Desc {
+			return repoLess(q.Sort, &items[j], &items[i])
+		}
+
+		return repoLess(q.Sort, &items[i], &items[j])
+	})
+
+	page := &contract.RepositoryPage{Total: len(items)}
+
+	if q.Offset >= len(items) {
+		page.Repos = []contract.Repository{}
+		return page, nil
+	}
+
+	items = items[q.Offset:]
+
+	if q.Limit > 0 && q.Limit < len(items) {
+		items = items[:q.Limit]
+	}
+
+	page.Repos = items
+
+	return page, nil
+}
+
+func (c *docCatalog) put(ctx context.Context, r *contract.Repository) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, err := c.read(r.Owner)
+	if err != nil {
+		return err
+	}
+
+	repos := []*contract.Repository{r}
+
+	for _, e := range doc.Repos {
+		if e.Name != r.Name {
+			repos = append(repos, e)
+		}
+	}
+
+	doc.Repos = repos
+
+	return c.svc.writeUserMeta(r.Owner, catalogMeta, doc)
+}
+
+func (c *docCatalog) set(ctx context.Context, user, repo string, trash bool, f catalogField, v interface{}) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, err := c.read(user)
+	if err != nil {
+		return false, err
+	}
+
+	i := doc.find(repo, trash)
+	if i < 0 {
+		return false, nil
+	}
+
+	r := doc.Repos[i]
+
+	switch f {
+	case catalogBackend:
+		r.Backend = v.(contract.FsType)
+	case catalogForkOf:
+		r.ForkOf = v.(string)
+	case catalogDeletedAt:
+		r.DeletedAt = v.(time.Time)
+	default:
+		return false, fmt.Errorf("Wrong catalog field %s", f)
+	}
+
+	return true, c.svc.writeUserMeta(user, catalogMeta, doc)
+}
+
+func (c *docCatalog) setSizes(ctx context.Context, user, repo string, size int64, u *contract.RepoUsage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, err := c.read(user)
+	if err != nil {
+		return err
+	}
+
+	i := doc.find(repo, false)
+	if i < 0 {
+		return nil
+	}
+
+	r := doc.Repos[i]
+	r.Size = size
+	r.ObjectBytes = u.ObjectBytes
+	r.WorktreeBytes = u.WorktreeBytes
+
+	return c.svc.writeUserMeta(user, catalogMeta, doc)
+}
+
+func (c *docCatalog) remove(ctx context.Context, user, repo string, trash bool) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, err := c.read(user)
+	if err != nil {
+		return false, err
+	}
+
+	i := doc.find(repo, trash)
+	if i < 0 {
+		return false, nil
+	}
+
+	doc.Repos = append(doc.Repos[:i], doc.Repos[i+1:]...)
+
+	return true, c.svc.writeUserMeta(user, catalogMeta, doc)
+}
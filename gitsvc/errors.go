@@ -0,0 +1,64 @@
+package gitsvc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/transport"
+)
+
+//libErrorCodes - codes of go-git errors which service passes through
+var libErrorCodes = map[error]contract.ErrorCode{
+	git.NoErrAlreadyUpToDate:            contract.ErrorCodeUpToDate,
+	git.ErrRepositoryNotExists:          contract.ErrorCodeNotFound,
+	git.ErrRemoteNotFound:               contract.ErrorCodeNotFound,
+	git.ErrBranchNotFound:               contract.ErrorCodeNotFound,
+	plumbing.ErrReferenceNotFound:       contract.ErrorCodeNotFound,
+	plumbing.ErrObjectNotFound:          contract.ErrorCodeNotFound,
+	git.ErrRepositoryAlreadyExists:      contract.ErrorCodeConflict,
+	git.ErrRemoteExists:                 contract.ErrorCodeConflict,
+	git.ErrBranchExists:                 contract.ErrorCodeConflict,
+	git.ErrNonFastForwardUpdate:         contract.ErrorCodeConflict,
+	git.ErrWorktreeNotClean:             contract.ErrorCodeConflict,
+	git.ErrUnstagedChanges:              contract.ErrorCodeConflict,
+	git.ErrForceNeeded:                  contract.ErrorCodeConflict,
+	transport.ErrAuthenticationRequired: contract.ErrorCodeAuth,
+	transport.ErrAuthorizationFailed:    contract.ErrorCodeAuth,
+	transport.ErrInvalidAuthMethod:      contract.ErrorCodeAuth,
+	transport.ErrRepositoryNotFound:     contract.ErrorCodeUpstream,
+	transport.ErrEmptyRemoteRepository:  contract.ErrorCodeUpstream,
+	context.Canceled:                    contract.ErrorCodeCanceled,
+	context.DeadlineExceeded:            contract.ErrorCodeCanceled,
+}
+
+//toError - turns errors of go-git, filesystem and network into *contract.Error, errors of service stay untouched
+func toError(err error) error {
+	if err == nil || contract.ErrorCodeOf(err) != contract.ErrorCodeInternal {
+		return err
+	}
+
+	for libErr, code := range libErrorCodes {
+		if errors.Is(err, libErr) {
+			return contract.WrapError(code, err)
+		}
+	}
+
+	if os.IsNotExist(err) {
+		return contract.WrapError(contract.ErrorCodeNotFound, err)
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return contract.WrapError(contract.ErrorCodeUpstream, err)
+	}
+
+	return err
+}
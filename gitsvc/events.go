@@ -0,0 +1,158 @@
+package gitsvc
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+)
+
+//EventHandler - receives events published by service, it's called synchronously under running operation, so it mustn't block or call Service
+type EventHandler func(e contract.Event)
+
+//eventBus - delivers service events to subscribers
+type eventBus struct {
+	sync.RWMutex
+	lastID   int
+	handlers map[int]EventHandler
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{handlers: make(map[int]EventHandler)}
+}
+
+func (b *eventBus) subscribe(h EventHandler) (cancel func()) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.lastID++
+	id := b.lastID
+	b.handlers[id] = h
+
+	return func() {
+		b.Lock()
+		defer b.Unlock()
+
+		delete(b.handlers, id)
+	}
+}
+
+func (b *eventBus) publish(e contract.Event) {
+	b.RLock()
+	defer b.RUnlock()
+
+	for _, h := range b.handlers {
+		h(e)
+	}
+}
+
+//Subscribe - registers handler of repository events, call cancel to unsubscribe
+func (svc *service) Subscribe(h EventHandler) (cancel func()) {
+	return svc.events.subscribe(h)
+}
+
+//publish - sends event to all subscribers
+func (svc *service) publish(t contract.EventType, user, repo, branch, commit string, data map[string]string) {
+	svc.events.publish(contract.Event{
+		Type:   t,
+		User:   user,
+		Repo:   repo,
+		Branch: branch,
+		Commit: commit,
+		Time:   time.Now(),
+		Data:   data,
+	})
+}
+
+//publishHead - sends event about current branch and commit of opened repository
+func (svc *service) publishHead(ctx context.Context, t contract.EventType, user, repo string, data map[string]string) {
+	br, err := svc.CurrentBranch(ctx)
+	if err != nil || br == nil {
+		svc.publish(t, user, repo, "", "", data)
+		return
+	}
+
+	svc.publish(t, user, repo, br.Name, br.Hash, data)
+}
+
+//publishStatus - sends event about changed file of worktree
+func (svc *service) publishStatus(rq *contract.BaseRequest, path string, action contract.ChangeAction) {
+	svc.publish(contract.EventStatus, rq.User.Name, rq.Repository, rq.Branch, "", map[string]string{"path": path, "action": strconv.Itoa(int(action))})
+}
+
+//progressLine - matches lines like "Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s" or "Counting objects: 1000, done."
+var progressLine = regexp.MustCompile(`^([A-Za-z ]+):\s+(?:(\d+)% \((\d+)/(\d+)\)|(\d+))(?:, ([\d.]+) ([KMG]i)?B)?`)
+
+var progressUnits = map[string]float64{"": 1, "Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30}
+
+//progressWriter - publishes human readable progress sent by remote server line by line
+type progressWriter struct {
+	svc       *service
+	user      string
+	repo      string
+	operation string
+	report    func(contract.JobProgress)
+	buf       []byte
+}
+
+func (svc *service) newProgress(user, repo, operation string, report func(contract.JobProgress)) *progressWriter {
+	return &progressWriter{svc: svc, user: user, repo: repo, operation: operation, report: report}
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+
+	for {
+		//servers rewrite the same line with \r while counting objects
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+
+		line := string(bytes.TrimSpace(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+
+		if line == "" {
+			continue
+		}
+
+		p.svc.publish(contract.EventProgress, p.user, p.repo, "", "", map[string]string{"operation": p.operation, "message": line})
+
+		if p.report != nil {
+			p.report(parseProgress(line))
+		}
+	}
+
+	return len(data), nil
+}
+
+//parseProgress - extracts counters from progress line, only Message is set if line has unknown format
+func parseProgress(line string) contract.JobProgress {
+	res := contract.JobProgress{Message: line}
+
+	m := progressLine.FindStringSubmatch(line)
+	if m == nil {
+		return res
+	}
+
+	res.Stage = m[1]
+
+	if m[2] != "" {
+		res.Percent, _ = strconv.Atoi(m[2])
+		res.Objects, _ = strconv.ParseInt(m[3], 10, 64)
+		res.TotalObjects, _ = strconv.ParseInt(m[4], 10, 64)
+	} else {
+		res.Objects, _ = strconv.ParseInt(m[5], 10, 64)
+	}
+
+	if m[6] != "" {
+		size, _ := strconv.ParseFloat(m[6], 64)
+		res.Bytes = int64(size * progressUnits[m[7]])
+	}
+
+	return res
+}
@@ -0,0 +1,308 @@
+package gitsvc
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/cache"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/storer"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/transport"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/transport/http"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/transport/server"
+	"bitbucket.org/vishjosh/bipp-go-git/storage/filesystem"
+)
+
+//internalScheme - gitapp:///<user>/<repo> is repository of the server, objects are sent between storers
+//of both repositories in process, so it works across backends
+const internalScheme = "gitapp"
+
+//internalOnce - protocols of go-git are global, so transport is installed by the first service,
+//service which runs transfer comes to it with auth, see remoteAuth
+var internalOnce sync.Once
+
+//services - services of process, remote fetched without auth of service is found while process runs one service
+var services = &serviceRegistry{services: make(map[*service]bool)}
+
+type serviceRegistry struct {
+	sync.Mutex
+	services map[*service]bool
+}
+
+func (r *serviceRegistry) add(svc *service) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.services[svc] = true
+}
+
+func (r *serviceRegistry) remove(svc *service) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.services, svc)
+}
+
+//only - returns service if it's the only one, nil otherwise
+func (r *serviceRegistry) only() *service {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.services) != 1 {
+		return nil
+	}
+
+	for svc := range r.services {
+		return svc
+	}
+
+	return nil
+}
+
+//upstreamRemote - remote of fork which points to its source
+const upstreamRemote = "upstream"
+
+const forksMeta = "forks"
+
+//forks - forks of repository as they are kept in its metadata
+type forks struct {
+	Items []contract.Fork
+}
+
+//internalURL - returns URL of repository of the server, it's kept in config of fork, so it has nothing
+//which lives only while process runs
+func (svc *service) internalURL(user, repo string) string {
+	return internalScheme + ":///" + user + "/" + repo
+}
+
+//internalAuth - brings service which runs transfer to internal transport
+type internalAuth struct {
+	svc *service
+}
+
+func (a *internalAuth) Name() string {
+	return internalScheme
+}
+
+func (a *internalAuth) String() string {
+	return internalScheme
+}
+
+//remoteAuth - repositories of the server are found by service which runs transfer, others get credentials of request
+func (svc *service) remoteAuth(url string, auth *contract.Credentials) transport.AuthMethod {
+	if strings.HasPrefix(url, internalScheme+"://") {
+		return &internalAuth{svc: svc}
+	}
+
+	if auth == nil {
+		return nil
+	}
+
+	return &http.BasicAuth{Username: auth.Name, Password: auth.Password}
+}
+
+//remoteURL - returns the first URL of remote, it's empty if remote doesn't exist
+func remoteURL(r *git.Repository, remote string) string {
+	rm, err := r.Remote(remote)
+	if err != nil || len(rm.Config().URLs) == 0 {
+		return ""
+	}
+
+	return rm.Config().URLs[0]
+}
+
+//repoLoader - opens storage of repository of the server by internal URL
+type repoLoader struct {
+	svc *service
+}
+
+//Load - repository in trash or which doesn't exist isn't found. Host of URLs written before they became stable
+//is ignored
+func (l *repoLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	parts := strings.Split(strings.Trim(ep.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || validateRepoName(parts[1]) != nil {
+		return nil, transport.ErrRepositoryNotFound
+	}
+
+	user, repo := parts[0], parts[1]
+	svc := l.svc
+
+	exists, err := svc.repoExists(context.Background(), user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, transport.ErrRepositoryNotFound
+	}
+
+	fs, _, err := svc.createFs(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil
+}
+
+//internalTransport - repositories of the server can be fetched only, push would pass by hooks and branch protection
+type internalTransport struct{}
+
+//NewUploadPackSession - repository is opened by service which came with auth
+func (t *internalTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	var svc *service
+
+	if a, ok := auth.(*internalAuth); ok {
+		svc = a.svc
+	} else {
+		svc = services.only()
+	}
+
+	if svc == nil {
+		return nil, transport.ErrRepositoryNotFound
+	}
+
+	return server.NewClient(&repoLoader{svc: svc}).NewUploadPackSession(ep, nil)
+}
+
+func (t *internalTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	return nil, contract.NewError(contract.ErrorCodeForbidden, "Push to %s isn't supported, use merge requests", ep.String())
+}
+
+//Fork - clones repository to repository of toUser (name of source by default) without leaving the server,
+//source becomes `upstream` remote of fork, so changes can be pulled from it
+func (svc *service) Fork(ctx context.Context, user, repo, toUser, toRepo string) (string, error) {
+	if user == "" || toUser == "" {
+		return "", contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return "", contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	if toRepo == "" {
+		toRepo = repo
+	}
+
+	if user == toUser && repo == toRepo {
+		return "", contract.NewError(contract.ErrorCodeValidation, "Repository cannot be forked to itself")
+	}
+
+	exists, err := svc.repoExists(ctx, user, repo)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	//submodules of source live outside of the server, clone checks quota of toUser before and after fork is made
+	name, err := svc.clone(ctx, toUser, svc.internalURL(user, repo), nil, &contract.CloneOptions{Name: toRepo, Remote: upstreamRemote, NoSubmodules: true}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	source := user + "/" + repo
+
+	err = svc.setForkOf(toUser, name, source)
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.addFork(user, repo, contract.Fork{Owner: toUser, Name: name, CreatedAt: time.Now()})
+	if err != nil {
+		return "", err
+	}
+
+	svc.publish(contract.EventRepoForked, user, repo, "", "", map[string]string{"fork": toUser + "/" + name})
+
+	return name, nil
+}
+
+//Forks - returns forks of repository, removed ones are skipped
+func (svc *service) Forks(ctx context.Context, user, repo string) ([]contract.Fork, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	exists, err := svc.repoExists(ctx, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	svc.meta.Lock()
+	f := &forks{}
+	err = svc.readMeta(user, repo, forksMeta, f)
+	svc.meta.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.Fork{}
+
+	for _, fork := range f.Items {
+		exists, err := svc.repoExists(ctx, fork.Owner, fork.Name)
+		if err != nil {
+			log.Printf("Cannot check fork: %s, user: %s, error: %v\n", fork.Name, fork.Owner, err)
+			continue
+		}
+
+		if exists {
+			res = append(res, fork)
+		}
+	}
+
+	return res, nil
+}
+
+//addFork - records fork in metadata of source, fork with the same name replaces removed one
+func (svc *service) addFork(user, repo string, fork contract.Fork) error {
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	f := &forks{}
+
+	err := svc.readMeta(user, repo, forksMeta, f)
+	if err != nil {
+		return err
+	}
+
+	items := []contract.Fork{}
+
+	for _, e := range f.Items {
+		if e.Owner != fork.Owner || e.Name != fork.Name {
+			items = append(items, e)
+		}
+	}
+
+	f.Items = append(items, fork)
+
+	return svc.writeMeta(user, repo, forksMeta, f)
+}
+
+//setForkOf - records source of fork in catalog
+func (svc *service) setForkOf(user, repo, source string) error {
+	ok, err := svc.repos.set(context.Background(), user, repo, false, catalogForkOf, source)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package gitsvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/revlist"
+)
+
+//GC - prunes unreachable loose objects older than grace period and repacks reachable ones into a single pack.
+//Objects of staged files aren't reachable from refs, they are kept
+func (svc *service) GC(ctx context.Context, user, repo string) (*contract.GCResult, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
+	if err != nil {
+		return nil, err
+	}
+
+	//prune and repack follow parents of commits, they are missing in shallow clone
+	shallows, err := svc.git.repo.Storer.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shallows) > 0 {
+		return nil, contract.NewError(contract.ErrorCodeConflict, "Repository %s is a shallow clone, it can't be collected", repo)
+	}
+
+	backend, err := svc.repoBackend(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &contract.GCResult{}
+
+	res.SizeBefore, err = svc.repoSize(backend, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	r := svc.git.repo
+
+	//storage caches list of packs, repository is opened again by the next operation
+	defer func() { svc.git = nil }()
+
+	reachable, err := reachableObjects(r)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := stagedObjects(r)
+
+	var before time.Time
+	if svc.settings.GCGracePeriod > 0 {
+		before = time.Now().Add(-svc.settings.GCGracePeriod)
+	}
+
+	err = r.Prune(git.PruneOptions{
+		OnlyObjectsOlderThan: before,
+		Handler: func(h plumbing.Hash) error {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			if staged[h] {
+				return nil
+			}
+
+			res.Pruned++
+
+			return r.DeleteObject(h)
+		},
+	})
+
+	if err != nil && err != git.ErrLooseObjectsNotSupported {
+		return nil, err
+	}
+
+	//new pack contains objects reachable from refs only, so old packs can't be removed while staged files need others
+	keep := false
+	for h := range staged {
+		if !reachable[h] {
+			keep = true
+			break
+		}
+	}
+
+	if len(reachable) > 0 && !keep {
+		err = ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.RepackObjects(&git.RepackConfig{OnlyDeletePacksOlderThan: before})
+		if err != nil {
+			return nil, err
+		}
+
+		res.Repacked = true
+	}
+
+	res.SizeAfter, err = svc.repoSize(backend, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Reclaimed = res.SizeBefore - res.SizeAfter
+
+	svc.touchRepo(user, repo)
+
+	return res, nil
+}
+
+//reachableObjects - returns objects reachable from refs
+func reachableObjects(r *git.Repository) (map[plumbing.Hash]bool, error) {
+	refs, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	tips := []plumbing.Hash{}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := revlist.Objects(r.Storer, tips, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[plumbing.Hash]bool, len(hashes))
+	for _, h := range hashes {
+		res[h] = true
+	}
+
+	return res, nil
+}
+
+//stagedObjects - returns blobs of index, repository without index has none
+func stagedObjects(r *git.Repository) map[plumbing.Hash]bool {
+	res := make(map[plumbing.Hash]bool)
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return res
+	}
+
+	for _, e := range idx.Entries {
+		res[e.Hash] = true
+	}
+
+	return res
+}
+
+func gcMessage(res *contract.GCResult) string {
+	return fmt.Sprintf("Reclaimed %d bytes, pruned %d objects", res.Reclaimed, res.Pruned)
+}
@@ -0,0 +1,457 @@
+package gitsvc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/object"
+)
+
+const hookRulesMeta = "hooks"
+
+//maxHookContent - hooks get only beginning of bigger files, so large blobs aren't read into memory
+const maxHookContent = 1 << 20
+
+//Hook - validates changes before they get into repository.
+//Every method returns found violations, error is returned only if validation itself failed.
+//Hooks run under operation of Service, so they mustn't call it
+type Hook interface {
+	//PreCommit - validates files staged for commit or added to repository
+	PreCommit(ctx context.Context, rq *contract.BaseRequest, files []contract.HookFile) ([]contract.Violation, error)
+
+	//CommitMsg - validates commit message
+	CommitMsg(ctx context.Context, rq *contract.BaseRequest, msg string) ([]contract.Violation, error)
+
+	//PreMerge - validates files which theirs branch brings into current one
+	PreMerge(ctx context.Context, rq *contract.BaseRequest, theirs string, files []contract.HookFile) ([]contract.Violation, error)
+}
+
+//NopHook - accepts everything, embed it to implement only needed methods of Hook
+type NopHook struct{}
+
+//PreCommit - accepts all files
+func (NopHook) PreCommit(ctx context.Context, rq *contract.BaseRequest, files []contract.HookFile) ([]contract.Violation, error) {
+	return nil, nil
+}
+
+//CommitMsg - accepts any message
+func (NopHook) CommitMsg(ctx context.Context, rq *contract.BaseRequest, msg string) ([]contract.Violation, error) {
+	return nil, nil
+}
+
+//PreMerge - accepts all files
+func (NopHook) PreMerge(ctx context.Context, rq *contract.BaseRequest, theirs string, files []contract.HookFile) ([]contract.Violation, error) {
+	return nil, nil
+}
+
+//RegisterHook - adds hook which is called by Commit, Merge and AddFile after built-in rules
+func (svc *service) RegisterHook(h Hook) {
+	svc.hooksMu.Lock()
+	defer svc.hooksMu.Unlock()
+
+	svc.hooks = append(svc.hooks, h)
+}
+
+//registeredHooks - returns copy of hooks, so they can be run while new ones get registered
+func (svc *service) registeredHooks() []Hook {
+	svc.hooksMu.RLock()
+	defer svc.hooksMu.RUnlock()
+
+	return append([]Hook{}, svc.hooks...)
+}
+
+//HookRules - returns built-in hook rules of repository
+func (svc *service) HookRules(ctx context.Context, user, repo string) (*contract.HookRules, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	rules := &contract.HookRules{ForbiddenPaths: []string{}, SecretPatterns: []string{}}
+
+	err := svc.readMeta(user, repo, hookRulesMeta, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+//SetHookRules - replaces built-in hook rules of repository
+func (svc *service) SetHookRules(ctx context.Context, user, repo string, rules *contract.HookRules) error {
+	if rules == nil {
+		return contract.NewError(contract.ErrorCodeValidation, "Rules cannot be nil")
+	}
+
+	if user == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	if rules.MaxFileSize < 0 {
+		return contract.NewError(contract.ErrorCodeValidation, "Max file size cannot be negative")
+	}
+
+	patterns := append([]string{rules.MessagePattern}, rules.SecretPatterns...)
+
+	for _, p := range patterns {
+		_, err := regexp.Compile(p)
+		if err != nil {
+			return contract.NewError(contract.ErrorCodeValidation, "Wrong pattern %s: %v", p, err)
+		}
+	}
+
+	for _, p := range rules.ForbiddenPaths {
+		_, err := path.Match(p, "")
+		if err != nil {
+			return contract.NewError(contract.ErrorCodeValidation, "Wrong path pattern %s: %v", p, err)
+		}
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	return svc.writeMeta(user, repo, hookRulesMeta, rules)
+}
+
+//runPreCommitHooks - runs pre-commit hooks and returns *contract.HookError if any of them rejects files
+func (svc *service) runPreCommitHooks(ctx context.Context, rq *contract.BaseRequest, files []contract.HookFile) error {
+	violations := []contract.Violation{}
+
+	for _, h := range svc.registeredHooks() {
+		v, err := h.PreCommit(ctx, rq, files)
+		if err != nil {
+			return err
+		}
+
+		violations = append(violations, v...)
+	}
+
+	return hookError(violations)
+}
+
+//runCommitHooks - runs commit-msg and pre-commit hooks on staged files of current repository
+func (svc *service) runCommitHooks(ctx context.Context, rq *contract.BaseRequest, msg string) error {
+	violations := []contract.Violation{}
+
+	for _, h := range svc.registeredHooks() {
+		v, err := h.CommitMsg(ctx, rq, msg)
+		if err != nil {
+			return err
+		}
+
+		violations = append(violations, v...)
+	}
+
+	files, err := svc.stagedFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range svc.registeredHooks() {
+		v, err := h.PreCommit(ctx, rq, files)
+		if err != nil {
+			return err
+		}
+
+		violations = append(violations, v...)
+	}
+
+	return hookError(violations)
+}
+
+//runPreMergeHooks - runs pre-merge hooks on files which theirs branch brings into current one
+func (svc *service) runPreMergeHooks(ctx context.Context, rq *contract.BaseRequest, theirs string) error {
+	files, err := svc.mergedFiles(theirs)
+	if err != nil {
+		return err
+	}
+
+	violations := []contract.Violation{}
+
+	for _, h := range svc.registeredHooks() {
+		v, err := h.PreMerge(ctx, rq, theirs, files)
+		if err != nil {
+			return err
+		}
+
+		violations = append(violations, v...)
+	}
+
+	return hookError(violations)
+}
+
+func hookError(violations []contract.Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return &contract.HookError{Violations: violations}
+}
+
+//stagedFiles - returns added or modified files of current repository with their content from index
+func (svc *service) stagedFiles() ([]contract.HookFile, error) {
+	wt, err := svc.git.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := svc.git.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.HookFile{}
+
+	for _, e := range idx.Entries {
+		st, ok := status[e.Name]
+		if !ok {
+			continue
+		}
+
+		switch st.Staging {
+		case git.Added, git.Modified, git.Renamed, git.Copied:
+		default:
+			continue
+		}
+
+		f, err := svc.blobFile(e.Name, e.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, *f)
+	}
+
+	return res, nil
+}
+
+//mergedFiles - returns files which are added or modified in theirs branch since merge base
+func (svc *service) mergedFiles(theirs string) ([]contract.HookFile, error) {
+	headRef, err := svc.git.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	theirsRef, err := svc.git.repo.Reference(plumbing.NewBranchReferenceName(theirs), true)
+	if err != nil {
+		return nil, err
+	}
+
+	ours, err := svc.git.repo.CommitObject(headRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	their, err := svc.git.repo.CommitObject(theirsRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := ours.MergeBase(their)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.HookFile{}
+
+	//unrelated histories are rejected by merge itself
+	if len(bases) == 0 {
+		return res, nil
+	}
+
+	changes, err := diffCommits(bases[0], their)
+	if err != nil {
+		return nil, err
+	}
+
+	for p, ch := range changes {
+		if ch.action == contract.ChangeActionDeleted {
+			continue
+		}
+
+		f, err := svc.blobFile(p, ch.hash)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, *f)
+	}
+
+	return res, nil
+}
+
+func (svc *service) blobFile(path string, hash plumbing.Hash) (*contract.HookFile, error) {
+	blob, err := svc.git.repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return readBlob(path, blob)
+}
+
+func readBlob(path string, blob *object.Blob) (*contract.HookFile, error) {
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxHookContent))
+	if err != nil {
+		return nil, err
+	}
+
+	return &contract.HookFile{Path: path, Size: blob.Size, Content: content}, nil
+}
+
+//hookContent - returns part of content which hooks get
+func hookContent(content string) []byte {
+	if len(content) > maxHookContent {
+		content = content[:maxHookContent]
+	}
+
+	return []byte(content)
+}
+
+//matchPath - matches slash separated path against pattern segment by segment, "**" matches any number of segments.
+//Pattern without slashes matches file name in any directory
+func matchPath(pattern, name string) (bool, error) {
+	if !strings.Contains(pattern, "/") {
+		return path.Match(pattern, path.Base(name))
+	}
+
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(name) == 0, nil
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			ok, err := matchSegments(pattern[1:], name[i:])
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+
+		return false, nil
+	}
+
+	if len(name) == 0 {
+		return false, nil
+	}
+
+	ok, err := path.Match(pattern[0], name[0])
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return matchSegments(pattern[1:], name[1:])
+}
+
+//rulesHook - built-in hook which checks HookRules of repository
+type rulesHook struct {
+	svc *service
+}
+
+func (h *rulesHook) PreCommit(ctx context.Context, rq *contract.BaseRequest, files []contract.HookFile) ([]contract.Violation, error) {
+	return h.checkFiles(ctx, contract.HookPreCommit, rq, files)
+}
+
+func (h *rulesHook) PreMerge(ctx context.Context, rq *contract.BaseRequest, theirs string, files []contract.HookFile) ([]contract.Violation, error) {
+	return h.checkFiles(ctx, contract.HookPreMerge, rq, files)
+}
+
+func (h *rulesHook) CommitMsg(ctx context.Context, rq *contract.BaseRequest, msg string) ([]contract.Violation, error) {
+	rules, err := h.svc.HookRules(ctx, rq.User.Name, rq.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	if rules.MessagePattern == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(rules.MessagePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if re.MatchString(msg) {
+		return nil, nil
+	}
+
+	return []contract.Violation{{
+		Hook:    contract.HookCommitMsg,
+		Rule:    "message-pattern",
+		Message: fmt.Sprintf("commit message doesn't match %s", rules.MessagePattern),
+	}}, nil
+}
+
+func (h *rulesHook) checkFiles(ctx context.Context, hook string, rq *contract.BaseRequest, files []contract.HookFile) ([]contract.Violation, error) {
+	rules, err := h.svc.HookRules(ctx, rq.User.Name, rq.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []*regexp.Regexp{}
+
+	for _, p := range rules.SecretPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		secrets = append(secrets, re)
+	}
+
+	res := []contract.Violation{}
+
+	for _, f := range files {
+		for _, p := range rules.ForbiddenPaths {
+			ok, err := matchPath(p, f.Path)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				res = append(res, contract.Violation{Hook: hook, Rule: "forbidden-path", Path: f.Path, Message: fmt.Sprintf("path matches forbidden pattern %s", p)})
+			}
+		}
+
+		if rules.MaxFileSize > 0 && f.Size > rules.MaxFileSize {
+			res = append(res, contract.Violation{Hook: hook, Rule: "max-file-size", Path: f.Path, Message: fmt.Sprintf("file size %d exceeds %d bytes", f.Size, rules.MaxFileSize)})
+		}
+
+		for _, re := range secrets {
+			if re.Match(f.Content) {
+				res = append(res, contract.Violation{Hook: hook, Rule: "secret", Path: f.Path, Message: fmt.Sprintf("content matches secret pattern %s", re.String())})
+			}
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,450 @@
+package gitsvc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+)
+
+const jobsMeta = "jobs"
+
+//maxJobs - how many jobs are kept in history per user
+const maxJobs = 100
+
+//jobsQueueSize - how many jobs can wait for execution
+const jobsQueueSize = 100
+
+//jobHistory - jobs of user as they are kept in metadata
+type jobHistory struct {
+	LastID int64
+	Items  []*contract.Job
+}
+
+//jobRun - queued or running job, credentials are kept in memory only
+type jobRun struct {
+	job    contract.Job
+	auth   *contract.Credentials
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+//jobRunner - runs jobs one by one, because service keeps state of the single opened repository
+type jobRunner struct {
+	sync.Mutex
+	svc   *service
+	queue chan *jobRun
+	runs  map[string]*jobRun
+}
+
+func newJobRunner(svc *service) *jobRunner {
+	r := &jobRunner{
+		svc:   svc,
+		queue: make(chan *jobRun, jobsQueueSize),
+		runs:  make(map[string]*jobRun),
+	}
+
+	svc.workers.Add(1)
+	go r.work()
+
+	return r
+}
+
+//SubmitJob - queues clone, fetch, pull, push, gc or backup, jobs run one by one in background
+func (svc *service) SubmitJob(ctx context.Context, job *contract.Job, auth *contract.Credentials) (*contract.Job, error) {
+	if job == nil {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Job cannot be nil")
+	}
+
+	if job.User == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	switch job.Type {
+	case contract.JobTypeClone:
+		if job.URL == "" {
+			return nil, contract.NewError(contract.ErrorCodeValidation, "URL cannot be empty")
+		}
+	case contract.JobTypeFetch, contract.JobTypePull, contract.JobTypePush, contract.JobTypeGC, contract.JobTypeBackup:
+		if job.Repo == "" {
+			return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+		}
+	default:
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Wrong job type %s", job.Type)
+	}
+
+	select {
+	case <-svc.done:
+		return nil, contract.NewError(contract.ErrorCodeUnavailable, "Service is stopped")
+	default:
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+
+	run := &jobRun{
+		job: contract.Job{
+			Type:      job.Type,
+			User:      job.User,
+			Repo:      job.Repo,
+			Branch:    job.Branch,
+			URL:       job.URL,
+			Remote:    job.Remote,
+			Force:     job.Force,
+			Clone:     job.Clone,
+			Status:    contract.JobStatusQueued,
+			CreatedAt: time.Now(),
+		},
+		auth:   auth,
+		ctx:    runCtx,
+		cancel: cancel,
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	history := &jobHistory{}
+	err := svc.readUserMeta(job.User, jobsMeta, history)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	history.LastID++
+	run.job.ID = history.LastID
+
+	history.Items = append(history.Items, &run.job)
+
+	if len(history.Items) > maxJobs {
+		history.Items = history.Items[len(history.Items)-maxJobs:]
+	}
+
+	err = svc.writeUserMeta(job.User, jobsMeta, history)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res := run.job
+
+	svc.jobs.Lock()
+	svc.jobs.runs[jobKey(res.User, res.ID)] = run
+	svc.jobs.Unlock()
+
+	select {
+	case svc.jobs.queue <- run:
+	default:
+		cancel()
+		svc.jobs.finish(run, contract.JobStatusFailed, contract.NewError(contract.ErrorCodeUnavailable, "Jobs queue is full"))
+		svc.jobs.saveLocked(run)
+
+		return nil, contract.NewError(contract.ErrorCodeUnavailable, "Jobs queue is full")
+	}
+
+	return &res, nil
+}
+
+//Job - returns job by id or contract.ErrJobNotFound
+func (svc *service) Job(ctx context.Context, user string, id int64) (*contract.Job, error) {
+	jobs, err := svc.Jobs(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, j := range jobs {
+		if j.ID == id {
+			return &j, nil
+		}
+	}
+
+	return nil, contract.ErrJobNotFound
+}
+
+//Jobs - returns job history of user, the newest first
+func (svc *service) Jobs(ctx context.Context, user string) ([]contract.Job, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	svc.meta.Lock()
+	history := &jobHistory{}
+	err := svc.readUserMeta(user, jobsMeta, history)
+	svc.meta.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.Job{}
+
+	for i := len(history.Items) - 1; i >= 0; i-- {
+		j := *history.Items[i]
+
+		//progress of unfinished jobs is kept in memory only
+		if run, ok := svc.jobs.get(user, j.ID); ok {
+			j = run
+		} else if j.Status == contract.JobStatusQueued || j.Status == contract.JobStatusRunning {
+			j.Status = contract.JobStatusFailed
+			j.Error = "Job was interrupted by server restart"
+		}
+
+		res = append(res, j)
+	}
+
+	return res, nil
+}
+
+//CancelJob - cancels queued or running job
+func (svc *service) CancelJob(ctx context.Context, user string, id int64) error {
+	svc.jobs.Lock()
+	run, ok := svc.jobs.runs[jobKey(user, id)]
+	svc.jobs.Unlock()
+
+	if ok {
+		run.cancel()
+		return nil
+	}
+
+	_, err := svc.Job(ctx, user, id)
+	if err != nil {
+		return err
+	}
+
+	return contract.NewError(contract.ErrorCodeConflict, "Job %d is already finished", id)
+}
+
+func jobKey(user string, id int64) string {
+	return user + "/" + strconv.FormatInt(id, 10)
+}
+
+//get - returns copy of unfinished job
+func (r *jobRunner) get(user string, id int64) (contract.Job, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	run, ok := r.runs[jobKey(user, id)]
+	if !ok {
+		return contract.Job{}, false
+	}
+
+	return run.job, true
+}
+
+//work - runs queued jobs until service is closed, jobs left in queue are canceled then
+func (r *jobRunner) work() {
+	defer r.svc.workers.Done()
+
+	for {
+		select {
+		case <-r.svc.done:
+			r.cancelQueued()
+			return
+		case run := <-r.queue:
+			r.run(run)
+		}
+	}
+}
+
+func (r *jobRunner) cancelQueued() {
+	for {
+		select {
+		case run := <-r.queue:
+			run.cancel()
+			r.finish(run, contract.JobStatusCanceled, contract.NewError(contract.ErrorCodeUnavailable, "Service is stopped"))
+			r.save(run)
+		default:
+			return
+		}
+	}
+}
+
+//cancelAll - cancels queued and running jobs
+func (r *jobRunner) cancelAll() {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, run := range r.runs {
+		run.cancel()
+	}
+}
+
+func (r *jobRunner) run(run *jobRun) {
+	defer run.cancel()
+
+	//job was canceled while it was waiting in queue
+	if run.ctx.Err() != nil {
+		r.finish(run, contract.JobStatusCanceled, run.ctx.Err())
+		r.save(run)
+
+		return
+	}
+
+	r.Lock()
+	run.job.Status = contract.JobStatusRunning
+	run.job.StartedAt = time.Now()
+	r.Unlock()
+
+	r.save(run)
+
+	err := r.exec(run)
+
+	switch {
+	case err == nil, errors.Is(err, git.NoErrAlreadyUpToDate):
+		r.finish(run, contract.JobStatusSucceeded, nil)
+	case run.ctx.Err() != nil:
+		r.finish(run, contract.JobStatusCanceled, run.ctx.Err())
+	default:
+		r.finish(run, contract.JobStatusFailed, err)
+	}
+
+	r.save(run)
+}
+
+//exec - runs job as any other operation of service, data goes over network without ops (see transfer),
+//user and repository opened before are reopened after it
+func (r *jobRunner) exec(run *jobRun) error {
+	r.svc.ops.Lock()
+	defer r.svc.ops.Unlock()
+
+	defer r.svc.restoreState(r.svc.user, r.svc.git)
+
+	r.Lock()
+	job := run.job
+	r.Unlock()
+
+	report := func(p contract.JobProgress) {
+		r.Lock()
+		run.job.Progress = p
+		r.Unlock()
+	}
+
+	rq := &contract.BaseRequest{User: &contract.User{Name: job.User}, Repository: job.Repo, Branch: job.Branch}
+
+	switch job.Type {
+	case contract.JobTypeClone:
+		{
+			repo, err := r.svc.clone(run.ctx, job.User, job.URL, run.auth, job.Clone, report)
+			if err != nil {
+				return err
+			}
+
+			r.Lock()
+			run.job.Repo = repo
+			r.Unlock()
+
+			return nil
+		}
+	case contract.JobTypeFetch:
+		return r.svc.fetch(run.ctx, job.User, job.Repo, job.Remote, run.auth, report)
+	case contract.JobTypePull:
+		{
+			msg, err := r.svc.pull(run.ctx, rq, job.Remote, run.auth, report)
+
+			r.Lock()
+			run.job.Result = msg
+			r.Unlock()
+
+			return err
+		}
+	case contract.JobTypePush:
+		return r.svc.push(run.ctx, rq, job.Remote, run.auth, job.Force, report)
+	case contract.JobTypeGC:
+		{
+			res, err := r.svc.GC(run.ctx, job.User, job.Repo)
+
+			//shallow clone is skipped
+			if contract.ErrorCodeOf(err) == contract.ErrorCodeConflict {
+				r.Lock()
+				run.job.Result = err.Error()
+				r.Unlock()
+
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			r.Lock()
+			run.job.Result = gcMessage(res)
+			r.Unlock()
+
+			return nil
+		}
+	case contract.JobTypeBackup:
+		{
+			b, err := r.svc.Backup(run.ctx, job.User, job.Repo)
+
+			//repository without commits and shallow clone can't be backed up
+			if contract.ErrorCodeOf(err) == contract.ErrorCodeConflict {
+				r.Lock()
+				run.job.Result = err.Error()
+				r.Unlock()
+
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			r.Lock()
+			run.job.Result = b.ID
+			r.Unlock()
+
+			return nil
+		}
+	default:
+		return contract.NewError(contract.ErrorCodeValidation, "Wrong job type %s", job.Type)
+	}
+}
+
+//finish - sets final status of job and forgets it, so its state is read from history since now
+func (r *jobRunner) finish(run *jobRun, status contract.JobStatus, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	run.job.Status = status
+	run.job.FinishedAt = time.Now()
+
+	if err != nil {
+		run.job.Error = err.Error()
+	}
+
+	delete(r.runs, jobKey(run.job.User, run.job.ID))
+}
+
+func (r *jobRunner) save(run *jobRun) {
+	r.svc.meta.Lock()
+	defer r.svc.meta.Unlock()
+
+	r.saveLocked(run)
+}
+
+//saveLocked - replaces job in history, must be called under svc.meta lock
+func (r *jobRunner) saveLocked(run *jobRun) {
+	r.Lock()
+	job := run.job
+	r.Unlock()
+
+	history := &jobHistory{}
+
+	err := r.svc.readUserMeta(job.User, jobsMeta, history)
+	if err == nil {
+		for i, j := range history.Items {
+			if j.ID == job.ID {
+				history.Items[i] = &job
+			}
+		}
+
+		err = r.svc.writeUserMeta(job.User, jobsMeta, history)
+	}
+
+	if err != nil {
+		log.Printf("Cannot save job %d, user: %s, error: %v\n", job.ID, job.User, err)
+	}
+}
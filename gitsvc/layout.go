@@ -0,0 +1,350 @@
+package gitsvc
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"regexp"
+	"strings"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/sqlfs"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/storage/mysqlfs"
+	"github.com/jmoiron/sqlx"
+)
+
+//tables of shared MySQL layout, names of users and repositories are never part of queries there,
+//they are kept in registries and files are keyed by ids
+const (
+	sharedReposTable = "gitapp_repos"
+	sharedUsersTable = "gitapp_users"
+	sharedGitTable   = "gitapp_git"
+	sharedFilesTable = "gitapp_files"
+	sharedMetaTable  = "gitapp_meta"
+)
+
+//tableIdentifier - names of per-repository tables are made of names of user and repository,
+//so names which can't be used in identifier as is are rejected
+var tableIdentifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func (svc *service) sharedLayout() bool {
+	return svc.settings.MySQLLayout == contract.MySQLLayoutShared
+}
+
+//createRegistries - creates tables which give ids to repositories and users
+func createRegistries(db *sqlx.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + sharedReposTable + ` (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		owner VARCHAR(255) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		UNIQUE KEY owner_name (owner, name)
+	)`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + sharedUsersTable + ` (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		UNIQUE KEY name (name)
+	)`)
+
+	return err
+}
+
+//repoID - returns id of repository, it's registered if create is set, so lookups of repositories
+//which don't exist leave no rows
+func (svc *service) repoID(user, repo string, create bool) (int64, error) {
+	if create {
+		_, err := svc.db.Exec("INSERT IGNORE INTO "+sharedReposTable+" (owner, name) VALUES (?, ?)", user, repo)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var id int64
+
+	err := svc.db.Get(&id, "SELECT id FROM "+sharedReposTable+" WHERE owner = ? AND name = ?", user, repo)
+	if err == sql.ErrNoRows {
+		return 0, contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	return id, err
+}
+
+func (svc *service) userID(user string) (int64, error) {
+	_, err := svc.db.Exec("INSERT IGNORE INTO "+sharedUsersTable+" (name) VALUES (?)", user)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+
+	err = svc.db.Get(&id, "SELECT id FROM "+sharedUsersTable+" WHERE name = ?", user)
+
+	return id, err
+}
+
+//sharedFs - returns filesystems of repository in shared tables, repository which isn't registered
+//isn't found unless create is set
+func (svc *service) sharedFs(user, repo string, create bool) (fs billy.Filesystem, gitFs billy.Filesystem, err error) {
+	id, err := svc.repoID(user, repo, create)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fs, err = sqlfs.NewShared(svc.db, sharedGitTable, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gitFs, err = sqlfs.NewShared(svc.db, sharedFilesTable, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fs, gitFs, nil
+}
+
+func (svc *service) sharedUserFs(user string) (billy.Filesystem, error) {
+	id, err := svc.userID(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlfs.NewShared(svc.db, sharedMetaTable, id)
+}
+
+//deleteSharedRepo - removes files and registration of repository in one transaction
+func (svc *service) deleteSharedRepo(user, repo string) error {
+	id, err := svc.repoID(user, repo, false)
+	if contract.ErrorCodeOf(err) == contract.ErrorCodeNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := svc.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = sqlfs.DeleteShared(tx, sharedGitTable, id)
+	if err == nil {
+		err = sqlfs.DeleteShared(tx, sharedFilesTable, id)
+	}
+
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM "+sharedReposTable+" WHERE id = ?", id)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//MigrateLayout - moves repositories and metadata of all users from per-repository MySQL tables to shared ones.
+//Every repository is checked like on migration between backends before its tables are dropped
+func (svc *service) MigrateLayout(ctx context.Context) error {
+	if svc.db == nil {
+		return contract.NewError(contract.ErrorCodeUnavailable, "MySQL isn't configured")
+	}
+
+	err := createRegistries(svc.db)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{}
+
+	err = svc.db.SelectContext(ctx, &tables, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() ORDER BY table_name ASC")
+	if err != nil {
+		return err
+	}
+
+	users, err := svc.layoutUsers(ctx, tables)
+	if err != nil {
+		return err
+	}
+
+	repos, err := svc.layoutRepos(ctx, tables, users)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err = svc.migrateUserLayout(ctx, user, repos[user], contains(tables, appPrefix+user))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//layoutUsers - users are known by tables of their metadata and by catalog, the latter has owners
+//of repositories created before metadata appeared
+func (svc *service) layoutUsers(ctx context.Context, tables []string) ([]string, error) {
+	users, err := svc.repos.users(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		if strings.HasPrefix(t, appPrefix) && !contains(users, strings.TrimPrefix(t, appPrefix)) {
+			users = append(users, strings.TrimPrefix(t, appPrefix))
+		}
+	}
+
+	return users, nil
+}
+
+//layoutRepos - returns repositories kept in per-repository tables by users. Table git_a_b_c of repository which is
+//in catalog belongs to its owner, others are given to known user with the longest name. Nothing is migrated
+//if owner of any table is unknown
+func (svc *service) layoutRepos(ctx context.Context, tables, users []string) (map[string][]string, error) {
+	owners := map[string]string{}
+
+	for _, user := range users {
+		for _, trash := range []bool{false, true} {
+			repos, err := svc.repos.list(ctx, user, trash)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range repos {
+				if r.Backend == contract.FsTypeMySQL {
+					owners[gitPrefix+user+"_"+r.Name] = user
+				}
+			}
+		}
+	}
+
+	res := map[string][]string{}
+	unknown := []string{}
+
+	for _, t := range tables {
+		if !strings.HasPrefix(t, gitPrefix) {
+			continue
+		}
+
+		owner, ok := owners[t]
+		if !ok {
+			owner = ownerOfTable(t, users)
+		}
+
+		if owner == "" {
+			unknown = append(unknown, t)
+			continue
+		}
+
+		res[owner] = append(res[owner], strings.TrimPrefix(t, gitPrefix+owner+"_"))
+	}
+
+	if len(unknown) > 0 {
+		return nil, contract.NewError(contract.ErrorCodeConflict, "Owners of tables %s are unknown, open their repositories to add them to catalog", strings.Join(unknown, ", "))
+	}
+
+	return res, nil
+}
+
+func (svc *service) migrateUserLayout(ctx context.Context, user string, repos []string, meta bool) error {
+	for _, repo := range repos {
+		err := svc.migrateRepoLayout(ctx, user, repo)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !meta {
+		return nil
+	}
+
+	src, err := mysqlfs.New(svc.db.DB, appPrefix+user)
+	if err != nil {
+		return err
+	}
+
+	dst, err := svc.sharedUserFs(user)
+	if err != nil {
+		return err
+	}
+
+	err = copyDir(ctx, src, dst, "", "")
+	if err == nil {
+		err = compareDir(ctx, src, dst, "", "")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.db.Exec("DROP TABLE " + quoteTable(appPrefix+user))
+
+	return err
+}
+
+func ownerOfTable(table string, users []string) string {
+	owner := ""
+
+	for _, u := range users {
+		if strings.HasPrefix(table, gitPrefix+u+"_") && len(u) > len(owner) {
+			owner = u
+		}
+	}
+
+	return owner
+}
+
+func (svc *service) migrateRepoLayout(ctx context.Context, user, repo string) error {
+	filesTable, gitTable := filesPrefix+user+"_"+repo, gitPrefix+user+"_"+repo
+
+	srcFs, err := mysqlfs.New(svc.db.DB, gitTable)
+	if err != nil {
+		return err
+	}
+
+	srcWt, err := mysqlfs.New(svc.db.DB, filesTable)
+	if err != nil {
+		return err
+	}
+
+	dstFs, dstWt, err := svc.sharedFs(user, repo, true)
+	if err != nil {
+		return err
+	}
+
+	err = copyRepo(ctx, srcFs, srcWt, dstFs, dstWt)
+	if err == nil {
+		err = verifyCopy(ctx, srcFs, dstFs)
+	}
+
+	if err == nil {
+		err = compareRepo(ctx, srcFs, srcWt, dstFs, dstWt)
+	}
+
+	if err != nil {
+		delErr := svc.deleteSharedRepo(user, repo)
+		if delErr != nil {
+			log.Printf("Cannot remove repo: %s, user: %s, error: %v\n", repo, user, delErr)
+		}
+
+		return err
+	}
+
+	_, err = svc.db.Exec("DROP TABLE " + quoteTable(filesTable) + ", " + quoteTable(gitTable))
+
+	return err
+}
+
+//quoteTable - names of tables come from names of users and repositories
+func quoteTable(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
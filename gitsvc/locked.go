@@ -0,0 +1,590 @@
+package gitsvc
+
+import (
+	"context"
+	"io"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+)
+
+//transfer - runs f without ops, so clone, fetch, pull, push and export don't stall other operations while data goes
+//over network. Caller holds ops and passes repository opened for f only. Operations of the same repository wait
+//until transfer is finished, repository opened by service is reopened, because f could change its storage
+func (svc *service) transfer(user, repo string, f func() error) error {
+	svc.waitIdle(user, repo)
+
+	key := user + "/" + repo
+	svc.busy[key] = true
+
+	svc.ops.Unlock()
+
+	err := f()
+
+	svc.ops.Lock()
+
+	delete(svc.busy, key)
+	svc.idle.Broadcast()
+
+	if svc.git != nil && svc.git.name == repo {
+		svc.git = nil
+	}
+
+	return err
+}
+
+//waitIdle - waits until transfer of repository is finished, caller holds ops, it's released while waiting
+func (svc *service) waitIdle(user, repo string) {
+	for svc.busy[user+"/"+repo] {
+		svc.idle.Wait()
+	}
+}
+
+//lockedService - runs operations one by one. Service keeps user and repository opened by the last operation,
+//so HTTP handlers and background jobs mustn't change them at the same time
+type lockedService struct {
+	svc *service
+}
+
+func (l *lockedService) CurrentUser(ctx context.Context) *contract.User {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CurrentUser(ctx)
+}
+
+func (l *lockedService) SwitchUser(ctx context.Context, user *contract.User) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.SwitchUser(ctx, user)
+}
+
+func (l *lockedService) Filesystem(ctx context.Context, user, repo string) (billy.Filesystem, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Filesystem(ctx, user, repo)
+}
+
+func (l *lockedService) FilesList(ctx context.Context, rq *contract.BaseRequest) ([]contract.FileInfo, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.FilesList(ctx, rq)
+}
+
+func (l *lockedService) Repositories(ctx context.Context, user string, q *contract.RepositoryQuery) (*contract.RepositoryPage, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Repositories(ctx, user, q)
+}
+
+func (l *lockedService) CreateRepository(ctx context.Context, user, repo string, opts *contract.RepoOptions) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CreateRepository(ctx, user, repo, opts)
+}
+
+func (l *lockedService) OpenRepository(ctx context.Context, user, repo string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.OpenRepository(ctx, user, repo)
+}
+
+func (l *lockedService) RemoveRepository(ctx context.Context, user, repo string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.RemoveRepository(ctx, user, repo)
+}
+
+func (l *lockedService) CurrentRepository(ctx context.Context) (name string) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CurrentRepository(ctx)
+}
+
+func (l *lockedService) Clone(ctx context.Context, user, url string, auth *contract.Credentials, opts *contract.CloneOptions) (string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Clone(ctx, user, url, auth, opts)
+}
+
+func (l *lockedService) Fetch(ctx context.Context, user, repo, remote string, auth *contract.Credentials) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Fetch(ctx, user, repo, remote, auth)
+}
+
+func (l *lockedService) Pull(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials) (string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Pull(ctx, rq, remote, auth)
+}
+
+func (l *lockedService) Push(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials, force bool) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Push(ctx, rq, remote, auth, force)
+}
+
+func (l *lockedService) Commit(ctx context.Context, rq *contract.BaseRequest, msg string) (string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Commit(ctx, rq, msg)
+}
+
+func (l *lockedService) Merge(ctx context.Context, rq *contract.BaseRequest, branch string) (string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Merge(ctx, rq, branch)
+}
+
+func (l *lockedService) Reset(ctx context.Context, rq *contract.BaseRequest, commit string, hard bool) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Reset(ctx, rq, commit, hard)
+}
+
+func (l *lockedService) MergeMsgShort(ctx context.Context, rq *contract.BaseRequest) (string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.MergeMsgShort(ctx, rq)
+}
+
+func (l *lockedService) MergeMsgFull(ctx context.Context, rq *contract.BaseRequest) (string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.MergeMsgFull(ctx, rq)
+}
+
+func (l *lockedService) AbortMerge(ctx context.Context, rq *contract.BaseRequest) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.AbortMerge(ctx, rq)
+}
+
+func (l *lockedService) MergePreview(ctx context.Context, rq *contract.BaseRequest, theirs string) (*contract.MergePreview, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.MergePreview(ctx, rq, theirs)
+}
+
+func (l *lockedService) ConflictFileList(ctx context.Context, rq *contract.BaseRequest) ([]string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.ConflictFileList(ctx, rq)
+}
+
+func (l *lockedService) ConflictFiles(ctx context.Context, rq *contract.BaseRequest, path string) ([]contract.MergeFile, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.ConflictFiles(ctx, rq, path)
+}
+
+func (l *lockedService) Checkout(ctx context.Context, user, repo string, commit string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Checkout(ctx, user, repo, commit)
+}
+
+func (l *lockedService) CheckoutBranch(ctx context.Context, user, repo, branch string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CheckoutBranch(ctx, user, repo, branch)
+}
+
+func (l *lockedService) CreateBranch(ctx context.Context, user, repo, branch, commit string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CreateBranch(ctx, user, repo, branch, commit)
+}
+
+func (l *lockedService) RemoveBranch(ctx context.Context, user, repo, branch string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.RemoveBranch(ctx, user, repo, branch)
+}
+
+func (l *lockedService) CurrentBranch(ctx context.Context) (*contract.Branch, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CurrentBranch(ctx)
+}
+
+func (l *lockedService) Branches(ctx context.Context, user, repo string) ([]string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Branches(ctx, user, repo)
+}
+
+func (l *lockedService) Add(ctx context.Context, rq *contract.BaseRequest, path string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Add(ctx, rq, path)
+}
+
+func (l *lockedService) Log(ctx context.Context, rq *contract.BaseRequest) ([]contract.Commit, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Log(ctx, rq)
+}
+
+func (l *lockedService) CreateRemote(ctx context.Context, user, repo, url, name string) (*git.Remote, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CreateRemote(ctx, user, repo, url, name)
+}
+
+func (l *lockedService) RemoveRemote(ctx context.Context, user, repo, name string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.RemoveRemote(ctx, user, repo, name)
+}
+
+func (l *lockedService) Remotes(ctx context.Context, user, repo string) ([]*git.Remote, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Remotes(ctx, user, repo)
+}
+
+func (l *lockedService) Remote(ctx context.Context, user, repo, name string) (*git.Remote, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Remote(ctx, user, repo, name)
+}
+
+func (l *lockedService) File(ctx context.Context, rq *contract.BaseRequest, path string) (billy.File, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.File(ctx, rq, path)
+}
+
+func (l *lockedService) AddFile(ctx context.Context, rq *contract.BaseRequest, path, content string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.AddFile(ctx, rq, path, content)
+}
+
+func (l *lockedService) EditFile(ctx context.Context, rq *contract.BaseRequest, path, content string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.EditFile(ctx, rq, path, content)
+}
+
+func (l *lockedService) RemoveFile(ctx context.Context, rq *contract.BaseRequest, path string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.RemoveFile(ctx, rq, path)
+}
+
+func (l *lockedService) Status(ctx context.Context, rq *contract.BaseRequest) (git.Status, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Status(ctx, rq)
+}
+
+func (l *lockedService) CreateMergeRequest(ctx context.Context, user, repo string, mr *contract.MergeRequest) (*contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CreateMergeRequest(ctx, user, repo, mr)
+}
+
+func (l *lockedService) MergeRequests(ctx context.Context, user, repo string, filter *contract.MergeRequestFilter) ([]contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.MergeRequests(ctx, user, repo, filter)
+}
+
+func (l *lockedService) MergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.MergeRequest(ctx, user, repo, id)
+}
+
+func (l *lockedService) CommentMergeRequest(ctx context.Context, user, repo string, id int64, comment *contract.MergeRequestComment) (*contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CommentMergeRequest(ctx, user, repo, id, comment)
+}
+
+func (l *lockedService) ApproveMergeRequest(ctx context.Context, user, repo string, id int64, author string) (*contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.ApproveMergeRequest(ctx, user, repo, id, author)
+}
+
+func (l *lockedService) AcceptMergeRequest(ctx context.Context, user, repo string, id int64, by *contract.User) (*contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.AcceptMergeRequest(ctx, user, repo, id, by)
+}
+
+func (l *lockedService) CloseMergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CloseMergeRequest(ctx, user, repo, id)
+}
+
+func (l *lockedService) ReopenMergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.ReopenMergeRequest(ctx, user, repo, id)
+}
+
+func (l *lockedService) BranchProtections(ctx context.Context, user, repo string) ([]contract.BranchProtection, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.BranchProtections(ctx, user, repo)
+}
+
+func (l *lockedService) SetBranchProtection(ctx context.Context, user, repo string, rule *contract.BranchProtection) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.SetBranchProtection(ctx, user, repo, rule)
+}
+
+func (l *lockedService) RemoveBranchProtection(ctx context.Context, user, repo, branch string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.RemoveBranchProtection(ctx, user, repo, branch)
+}
+
+func (l *lockedService) HookRules(ctx context.Context, user, repo string) (*contract.HookRules, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.HookRules(ctx, user, repo)
+}
+
+func (l *lockedService) SetHookRules(ctx context.Context, user, repo string, rules *contract.HookRules) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.SetHookRules(ctx, user, repo, rules)
+}
+
+func (l *lockedService) CreateWebhook(ctx context.Context, user, repo string, hook *contract.Webhook) (*contract.Webhook, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.CreateWebhook(ctx, user, repo, hook)
+}
+
+func (l *lockedService) Webhooks(ctx context.Context, user, repo string) ([]contract.Webhook, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Webhooks(ctx, user, repo)
+}
+
+func (l *lockedService) RemoveWebhook(ctx context.Context, user, repo string, id int64) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.RemoveWebhook(ctx, user, repo, id)
+}
+
+func (l *lockedService) WebhookDeliveries(ctx context.Context, user, repo string, id int64) ([]contract.WebhookDelivery, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.WebhookDeliveries(ctx, user, repo, id)
+}
+
+func (l *lockedService) Export(ctx context.Context, user, repo string, w io.Writer) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Export(ctx, user, repo, w)
+}
+
+func (l *lockedService) Import(ctx context.Context, user, repo string, bundle io.Reader) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Import(ctx, user, repo, bundle)
+}
+
+func (l *lockedService) Archive(ctx context.Context, user, repo, rev string, format contract.ArchiveFormat, w io.Writer) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Archive(ctx, user, repo, rev, format, w)
+}
+
+func (l *lockedService) Migrate(ctx context.Context, user, repo string, to contract.FsType) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Migrate(ctx, user, repo, to)
+}
+
+func (l *lockedService) MigrateLayout(ctx context.Context) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.MigrateLayout(ctx)
+}
+
+func (l *lockedService) GC(ctx context.Context, user, repo string) (*contract.GCResult, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.GC(ctx, user, repo)
+}
+
+func (l *lockedService) Verify(ctx context.Context, user, repo string) (*contract.VerifyReport, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Verify(ctx, user, repo)
+}
+
+func (l *lockedService) Trash(ctx context.Context, user string) ([]contract.Repository, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Trash(ctx, user)
+}
+
+func (l *lockedService) Backup(ctx context.Context, user, repo string) (*contract.Backup, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Backup(ctx, user, repo)
+}
+
+func (l *lockedService) Backups(ctx context.Context, user, repo string) ([]contract.Backup, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Backups(ctx, user, repo)
+}
+
+func (l *lockedService) RestoreRepository(ctx context.Context, user, repo, backup string) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.RestoreRepository(ctx, user, repo, backup)
+}
+
+func (l *lockedService) Fork(ctx context.Context, user, repo, toUser, toRepo string) (string, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Fork(ctx, user, repo, toUser, toRepo)
+}
+
+func (l *lockedService) Forks(ctx context.Context, user, repo string) ([]contract.Fork, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Forks(ctx, user, repo)
+}
+
+func (l *lockedService) Usage(ctx context.Context, user string) (*contract.Usage, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Usage(ctx, user)
+}
+
+func (l *lockedService) Usages(ctx context.Context) ([]contract.Usage, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Usages(ctx)
+}
+
+func (l *lockedService) Quota(ctx context.Context, user string) (*contract.Quota, error) {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.Quota(ctx, user)
+}
+
+func (l *lockedService) SetQuota(ctx context.Context, user string, quota *contract.Quota) error {
+	l.svc.ops.Lock()
+	defer l.svc.ops.Unlock()
+
+	return l.svc.SetQuota(ctx, user, quota)
+}
+
+//hooks, subscriptions and jobs don't use opened repository, so they don't wait for running operation
+
+func (l *lockedService) RegisterHook(h Hook) {
+	l.svc.RegisterHook(h)
+}
+
+func (l *lockedService) Subscribe(h EventHandler) (cancel func()) {
+	return l.svc.Subscribe(h)
+}
+
+func (l *lockedService) SubmitJob(ctx context.Context, job *contract.Job, auth *contract.Credentials) (*contract.Job, error) {
+	return l.svc.SubmitJob(ctx, job, auth)
+}
+
+func (l *lockedService) Job(ctx context.Context, user string, id int64) (*contract.Job, error) {
+	return l.svc.Job(ctx, user, id)
+}
+
+func (l *lockedService) Jobs(ctx context.Context, user string) ([]contract.Job, error) {
+	return l.svc.Jobs(ctx, user)
+}
+
+func (l *lockedService) CancelJob(ctx context.Context, user string, id int64) error {
+	return l.svc.CancelJob(ctx, user, id)
+}
+
+//Close - stops jobs and schedulers, waits for running operation
+func (l *lockedService) Close() error {
+	return l.svc.Close()
+}
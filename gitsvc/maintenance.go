@@ -0,0 +1,126 @@
+package gitsvc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/storer"
+)
+
+//schedule - runs task once per interval in background until service is closed
+func (svc *service) schedule(name string, interval time.Duration, task func(ctx context.Context) error) {
+	svc.workers.Add(1)
+
+	go func() {
+		defer svc.workers.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-svc.done:
+				return
+			case <-ticker.C:
+				err := task(context.Background())
+				if err != nil {
+					log.Printf("Cannot run %s: %v\n", name, err)
+				}
+			}
+		}
+	}()
+}
+
+//maintainAll - runs task for every repository of every user. It doesn't go through job queue, so it doesn't fill it
+//and jobs history of users, ops is taken for every repository, so other operations run between them.
+//Failure of one repository is logged and the others are still maintained
+func (svc *service) maintainAll(name string, task func(ctx context.Context, user, repo string) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		users, err := svc.metaUsers(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			repos, err := svc.repos.list(ctx, user, false)
+			if err != nil {
+				log.Printf("Cannot read repositories of user: %s, error: %v\n", user, err)
+				continue
+			}
+
+			for _, r := range repos {
+				select {
+				case <-svc.done:
+					return nil
+				default:
+				}
+
+				err = svc.maintain(ctx, user, r.Name, task)
+				if err != nil {
+					log.Printf("Cannot run %s, repo: %s, user: %s, error: %v\n", name, r.Name, user, err)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+func (svc *service) maintain(ctx context.Context, user, repo string, task func(ctx context.Context, user, repo string) error) error {
+	svc.ops.Lock()
+	defer svc.ops.Unlock()
+
+	return task(ctx, user, repo)
+}
+
+//gcRepo - shallow clone can't be collected, it's skipped
+func (svc *service) gcRepo(ctx context.Context, user, repo string) error {
+	_, err := svc.GC(ctx, user, repo)
+	if contract.ErrorCodeOf(err) == contract.ErrorCodeConflict {
+		return nil
+	}
+
+	return err
+}
+
+//backupRepo - repository without refs has nothing to back up, it's skipped
+func (svc *service) backupRepo(ctx context.Context, user, repo string) error {
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
+	if err != nil {
+		return err
+	}
+
+	ok, err := hasRefs(svc.git.repo)
+	if err != nil || !ok {
+		return err
+	}
+
+	_, err = svc.Backup(ctx, user, repo)
+
+	return err
+}
+
+//hasRefs - only refs which point to objects go to bundle
+func hasRefs(r *git.Repository) (bool, error) {
+	iter, err := r.References()
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			found = true
+			return storer.ErrStop
+		}
+
+		return nil
+	})
+
+	return found, err
+}
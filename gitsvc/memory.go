@@ -0,0 +1,75 @@
+package gitsvc
+
+import (
+	"sort"
+	"sync"
+
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy/memfs"
+)
+
+//memoryBackend - repositories and user metadata which live until the server is stopped
+type memoryBackend struct {
+	sync.Mutex
+	repos map[string]*memoryRepo
+	users map[string]billy.Filesystem
+}
+
+type memoryRepo struct {
+	fs billy.Filesystem
+	wt billy.Filesystem
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{repos: make(map[string]*memoryRepo), users: make(map[string]billy.Filesystem)}
+}
+
+//fs - returns filesystems of repository, they are created on the first call
+func (m *memoryBackend) fs(user, repo string) (billy.Filesystem, billy.Filesystem) {
+	m.Lock()
+	defer m.Unlock()
+
+	r, ok := m.repos[user+"/"+repo]
+	if !ok {
+		r = &memoryRepo{fs: memfs.New(), wt: memfs.New()}
+		m.repos[user+"/"+repo] = r
+	}
+
+	return r.fs, r.wt
+}
+
+func (m *memoryBackend) userFs(user string) billy.Filesystem {
+	m.Lock()
+	defer m.Unlock()
+
+	fs, ok := m.users[user]
+	if !ok {
+		fs = memfs.New()
+		m.users[user] = fs
+	}
+
+	return fs
+}
+
+//userNames - returns users which have metadata ordered by name
+func (m *memoryBackend) userNames() []string {
+	m.Lock()
+	defer m.Unlock()
+
+	res := []string{}
+
+	for user := range m.users {
+		res = append(res, user)
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+func (m *memoryBackend) delete(user, repo string) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.repos, user+"/"+repo)
+}
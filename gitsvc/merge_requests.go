@@ -0,0 +1,449 @@
+package gitsvc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+)
+
+const mergeRequestsMeta = "merge-requests"
+
+//mergeRequests - merge requests of repository as they are kept in metadata
+type mergeRequests struct {
+	LastID int64
+	Items  []*contract.MergeRequest
+}
+
+//CreateMergeRequest - opens a new merge request from source to target branch
+func (svc *service) CreateMergeRequest(ctx context.Context, user, repo string, mr *contract.MergeRequest) (*contract.MergeRequest, error) {
+	if mr == nil {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Merge request cannot be nil")
+	}
+
+	if mr.Title == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Title cannot be empty")
+	}
+
+	if mr.Source == "" || mr.Target == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Source and target branches cannot be empty")
+	}
+
+	if mr.Source == mr.Target {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Source and target branches must differ")
+	}
+
+	branches, err := svc.Branches(ctx, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, br := range []string{mr.Source, mr.Target} {
+		if !contains(branches, br) {
+			return nil, contract.NewError(contract.ErrorCodeNotFound, "Branch %s not found", br)
+		}
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	mrs := &mergeRequests{}
+	err = svc.readMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	mrs.LastID++
+
+	res := &contract.MergeRequest{
+		ID:          mrs.LastID,
+		Title:       mr.Title,
+		Description: mr.Description,
+		Source:      mr.Source,
+		Target:      mr.Target,
+		Author:      mr.Author,
+		Status:      contract.MergeRequestStatusOpen,
+		Approvals:   []string{},
+		Comments:    []contract.MergeRequestComment{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if res.Author == "" {
+		res.Author = user
+	}
+
+	mrs.Items = append(mrs.Items, res)
+
+	err = svc.writeMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+//MergeRequests - returns merge requests of repository, filter can be nil
+func (svc *service) MergeRequests(ctx context.Context, user, repo string, filter *contract.MergeRequestFilter) ([]contract.MergeRequest, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	mrs := &mergeRequests{}
+	err := svc.readMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.MergeRequest{}
+
+	for _, mr := range mrs.Items {
+		if filter != nil {
+			if filter.Status != contract.MergeRequestStatusInvalid && filter.Status != mr.Status {
+				continue
+			}
+
+			if filter.Source != "" && filter.Source != mr.Source {
+				continue
+			}
+
+			if filter.Target != "" && filter.Target != mr.Target {
+				continue
+			}
+
+			if filter.Author != "" && filter.Author != mr.Author {
+				continue
+			}
+		}
+
+		res = append(res, *mr)
+	}
+
+	return res, nil
+}
+
+//MergeRequest - returns merge request by id
+func (svc *service) MergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error) {
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	_, mr, err := svc.mergeRequest(user, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return mr, nil
+}
+
+//CommentMergeRequest - adds comment to merge request
+func (svc *service) CommentMergeRequest(ctx context.Context, user, repo string, id int64, comment *contract.MergeRequestComment) (*contract.MergeRequest, error) {
+	if comment == nil || comment.Text == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Comment cannot be empty")
+	}
+
+	if comment.Line < 0 || (comment.Line > 0 && comment.Path == "") {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Comment line must be tied to file")
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	mrs, mr, err := svc.mergeRequest(user, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastID int64
+	for _, c := range mr.Comments {
+		if c.ID > lastID {
+			lastID = c.ID
+		}
+	}
+
+	c := *comment
+	c.ID = lastID + 1
+	c.CreatedAt = time.Now()
+
+	if c.Author == "" {
+		c.Author = user
+	}
+
+	mr.Comments = append(mr.Comments, c)
+	mr.UpdatedAt = c.CreatedAt
+
+	err = svc.writeMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return mr, nil
+}
+
+//ApproveMergeRequest - adds approval to open merge request
+func (svc *service) ApproveMergeRequest(ctx context.Context, user, repo string, id int64, author string) (*contract.MergeRequest, error) {
+	if author == "" {
+		author = user
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	mrs, mr, err := svc.mergeRequest(user, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if mr.Status != contract.MergeRequestStatusOpen {
+		return nil, contract.NewError(contract.ErrorCodeConflict, "Merge request %d isn't open", id)
+	}
+
+	if !contains(mr.Approvals, author) {
+		mr.Approvals = append(mr.Approvals, author)
+		mr.UpdatedAt = time.Now()
+	}
+
+	err = svc.writeMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return mr, nil
+}
+
+//AcceptMergeRequest - merges source branch into target one on behalf of by and marks merge request as merged.
+//Metadata isn't locked while branches are merged, so merge request is checked again before it's marked
+func (svc *service) AcceptMergeRequest(ctx context.Context, user, repo string, id int64, by *contract.User) (*contract.MergeRequest, error) {
+	if by == nil || by.Name == "" || by.Email == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Name and email of user who merges cannot be empty")
+	}
+
+	svc.meta.Lock()
+	_, mr, err := svc.mergeRequest(user, repo, id)
+	svc.meta.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMergeRequestTransition(mr, contract.MergeRequestStatusMerged)
+	if err != nil {
+		return nil, err
+	}
+
+	rq := &contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: mr.Target}
+
+	err = svc.setSettings(ctx, rq.User, repo, mr.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	//merge request is the way to change branches which require it, so only committers and approvals are checked
+	err = svc.checkBranchProtectionBy(ctx, user, repo, mr.Target, branchOperationMergeRequest, by.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.checkApprovals(ctx, user, repo, mr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.runPreMergeHooks(ctx, rq, mr.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = svc.merge(mr.Source)
+
+	switch err {
+	case nil, git.NoErrAlreadyUpToDate:
+	case git.ErrMergeCommitNeeded:
+		{
+			msg, err := svc.MergeMsgShort(ctx, rq)
+			if err != nil {
+				return nil, err
+			}
+
+			if msg == "" {
+				msg = fmt.Sprintf("Merge branch '%s' into %s", mr.Source, mr.Target)
+			}
+
+			_, err = svc.commitAs(msg, by)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case git.ErrMergeWithConflicts:
+		{
+			abortErr := svc.AbortMerge(ctx, rq)
+			if abortErr != nil {
+				log.Printf("Cannot abort merge of merge request %d, repo: %s, user: %s, error: %v\n", id, repo, user, abortErr)
+			}
+
+			return nil, contract.ErrMergeRequestConflicts
+		}
+	default:
+		return nil, err
+	}
+
+	head, err := svc.CurrentBranch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	mrs, mr, err := svc.mergeRequest(user, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	//request was closed or merged by somebody else while branches were merged
+	err = checkMergeRequestTransition(mr, contract.MergeRequestStatusMerged)
+	if err != nil {
+		return nil, err
+	}
+
+	if head != nil {
+		mr.MergeCommit = head.Hash
+	}
+
+	mr.Status = contract.MergeRequestStatusMerged
+	mr.MergedBy = by.Name
+	mr.UpdatedAt = time.Now()
+
+	err = svc.writeMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.publish(contract.EventMerge, user, repo, mr.Target, mr.MergeCommit, map[string]string{"theirs": mr.Source, "mergeRequest": strconv.FormatInt(mr.ID, 10), "by": by.Name})
+
+	return mr, nil
+}
+
+//checkApprovals - merge request needs as many approvals of users other than its author as protection of target requires
+func (svc *service) checkApprovals(ctx context.Context, user, repo string, mr *contract.MergeRequest) error {
+	rules, err := svc.BranchProtections(ctx, user, repo)
+	if err != nil {
+		return err
+	}
+
+	approvals := 0
+	for _, a := range mr.Approvals {
+		if a != mr.Author {
+			approvals++
+		}
+	}
+
+	for _, r := range rules {
+		ok, err := path.Match(r.Branch, mr.Target)
+		if err != nil {
+			return err
+		}
+
+		if ok && approvals < r.RequiredApprovals {
+			return &contract.BranchProtectionError{Branch: mr.Target, Reason: fmt.Sprintf("%d approvals are required, merge request has %d", r.RequiredApprovals, approvals)}
+		}
+	}
+
+	return nil
+}
+
+//CloseMergeRequest - closes open merge request without merging
+func (svc *service) CloseMergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error) {
+	return svc.setMergeRequestStatus(user, repo, id, contract.MergeRequestStatusClosed)
+}
+
+//ReopenMergeRequest - reopens closed merge request
+func (svc *service) ReopenMergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error) {
+	return svc.setMergeRequestStatus(user, repo, id, contract.MergeRequestStatusOpen)
+}
+
+func (svc *service) setMergeRequestStatus(user, repo string, id int64, status contract.MergeRequestStatus) (*contract.MergeRequest, error) {
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	mrs, mr, err := svc.mergeRequest(user, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMergeRequestTransition(mr, status)
+	if err != nil {
+		return nil, err
+	}
+
+	mr.Status = status
+	mr.UpdatedAt = time.Now()
+
+	err = svc.writeMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return mr, nil
+}
+
+//mergeRequest - loads merge requests of repository and finds one by id, must be called under svc.meta lock
+func (svc *service) mergeRequest(user, repo string, id int64) (*mergeRequests, *contract.MergeRequest, error) {
+	if user == "" {
+		return nil, nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	mrs := &mergeRequests{}
+	err := svc.readMeta(user, repo, mergeRequestsMeta, mrs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, mr := range mrs.Items {
+		if mr.ID == id {
+			return mrs, mr, nil
+		}
+	}
+
+	return nil, nil, contract.ErrMergeRequestNotFound
+}
+
+//checkMergeRequestTransition - open request can be merged or closed, closed one can be reopened, merged one is final
+func checkMergeRequestTransition(mr *contract.MergeRequest, to contract.MergeRequestStatus) error {
+	switch {
+	case mr.Status == contract.MergeRequestStatusOpen && to == contract.MergeRequestStatusMerged:
+		return nil
+	case mr.Status == contract.MergeRequestStatusOpen && to == contract.MergeRequestStatusClosed:
+		return nil
+	case mr.Status == contract.MergeRequestStatusClosed && to == contract.MergeRequestStatusOpen:
+		return nil
+	default:
+		return contract.NewError(contract.ErrorCodeConflict, "Merge request %d cannot change status from %d to %d", mr.ID, mr.Status, to)
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
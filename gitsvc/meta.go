@@ -0,0 +1,205 @@
+package gitsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/objfs"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/sqlfs"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy/osfs"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy/util"
+	"bitbucket.org/vishjosh/bipp-go-git/storage/mysqlfs"
+)
+
+//metaDir - directory inside of .git where the app keeps repository metadata
+const metaDir = "app"
+
+//appPrefix - prefix of table where the app keeps user metadata
+const appPrefix = "app_"
+
+//metaRoot - directory of S3 and local backends where the app keeps user metadata, it's next to directories of users,
+//so it can't be name of user
+const metaRoot = ".app"
+
+//readMeta - reads json document with repository metadata, v stays untouched if document doesn't exist
+func (svc *service) readMeta(user, repo, name string, v interface{}) error {
+	fs, _, err := svc.createFs(user, repo)
+	if err != nil {
+		return err
+	}
+
+	return readDoc(fs, name, v)
+}
+
+//writeMeta - replaces json document with repository metadata
+func (svc *service) writeMeta(user, repo, name string, v interface{}) error {
+	fs, _, err := svc.createFs(user, repo)
+	if err != nil {
+		return err
+	}
+
+	return writeDoc(fs, name, v)
+}
+
+//readMetaDocs - returns all documents of repository metadata by names
+func (svc *service) readMetaDocs(user, repo string) (map[string]json.RawMessage, error) {
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	fs, _, err := svc.createFs(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := fs.ReadDir(metaDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	docs := map[string]json.RawMessage{}
+
+	for _, fi := range infos {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+
+		data, err := readFile(fs, fs.Join(metaDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		docs[strings.TrimSuffix(fi.Name(), ".json")] = data
+	}
+
+	return docs, nil
+}
+
+//writeMetaDocs - writes documents of repository metadata, names become names of files, so they can't leave directory
+func (svc *service) writeMetaDocs(user, repo string, docs map[string]json.RawMessage) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	fs, _, err := svc.createFs(user, repo)
+	if err != nil {
+		return err
+	}
+
+	for name, doc := range docs {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || !json.Valid(doc) {
+			return contract.NewError(contract.ErrorCodeValidation, "Wrong metadata document %s", name)
+		}
+
+		err = writeDoc(fs, name, doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//readUserMeta - reads json document with metadata of user which isn't tied to any repository
+func (svc *service) readUserMeta(user, name string, v interface{}) error {
+	fs, err := svc.userFs(user)
+	if err != nil {
+		return err
+	}
+
+	return readDoc(fs, name, v)
+}
+
+//writeUserMeta - replaces json document with metadata of user
+func (svc *service) writeUserMeta(user, name string, v interface{}) error {
+	fs, err := svc.userFs(user)
+	if err != nil {
+		return err
+	}
+
+	return writeDoc(fs, name, v)
+}
+
+//userFs - returns filesystem for user metadata, it's kept apart from repositories, so it isn't listed as one of them
+func (svc *service) userFs(user string) (billy.Filesystem, error) {
+	err := validateUserName(user)
+	if err != nil {
+		return nil, err
+	}
+
+	switch svc.settings.FsType {
+	case contract.FsTypeMySQL:
+		if svc.sharedLayout() {
+			return svc.sharedUserFs(user)
+		}
+
+		if !tableIdentifier.MatchString(appPrefix + user) {
+			return nil, contract.NewError(contract.ErrorCodeValidation, "Names of users can contain latin letters, digits and underscores only, use shared MySQL layout for other names")
+		}
+
+		return mysqlfs.New(svc.db.DB, appPrefix+user)
+	case contract.FsTypePostgres:
+		return sqlfs.New(svc.pg, appPrefix+user)
+	case contract.FsTypeSQLite:
+		return sqlfs.New(svc.sqlite, appPrefix+user)
+	case contract.FsTypeMemory:
+		return svc.memory.userFs(user), nil
+	case contract.FsTypeS3:
+		return objfs.New(svc.objects, path.Join(metaRoot, user)), nil
+	case contract.FsTypeLocal:
+		return osfs.New(filepath.Join(svc.settings.GitRoot, metaRoot, user)), nil
+	default:
+		return nil, fmt.Errorf("Wrong fsType = %d", svc.settings.FsType)
+	}
+}
+
+//metaUsers - returns users who have repositories in catalog or in trash
+func (svc *service) metaUsers(ctx context.Context) ([]string, error) {
+	return svc.repos.users(ctx)
+}
+
+func readDoc(fs billy.Filesystem, name string, v interface{}) error {
+	f, err := fs.Open(fs.Join(metaDir, name+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
+
+//writeDoc - document is written to temporary file first, so readers never see it half-written
+func writeDoc(fs billy.Filesystem, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	err = fs.MkdirAll(metaDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	path := fs.Join(metaDir, name+".json")
+
+	err = util.WriteFile(fs, path+".tmp", data, 0666)
+	if err != nil {
+		return err
+	}
+
+	return fs.Rename(path+".tmp", path)
+}
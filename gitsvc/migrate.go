@@ -0,0 +1,385 @@
+package gitsvc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/cache"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/revlist"
+	"bitbucket.org/vishjosh/bipp-go-git/storage/filesystem"
+)
+
+//Migrate - copies repository (objects, refs, config, index, metadata and worktree) from one backend to another,
+//checks objects of the copy and compares all its files with source. Catalog is switched to the copy only when check passes,
+//source is kept until then and removed after that. Copy has to fit into quota of user as source does
+func (svc *service) Migrate(ctx context.Context, user, repo string, to contract.FsType) error {
+	if user == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	from, err := svc.repoBackend(user, repo)
+	if err != nil {
+		return err
+	}
+
+	if from == to {
+		return contract.NewError(contract.ErrorCodeValidation, "Repository %s already lives in backend %d", repo, to)
+	}
+
+	srcFs, srcWt, err := svc.backendFs(from, user, repo)
+	if err != nil {
+		return err
+	}
+
+	empty, err := storageEmpty(srcFs, srcWt)
+	if err != nil {
+		return err
+	}
+
+	if empty {
+		return contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	dstFs, dstWt, err := svc.newBackendFs(to, user, repo)
+	if err != nil {
+		return err
+	}
+
+	empty, err = storageEmpty(dstFs, dstWt)
+	if err != nil {
+		return err
+	}
+
+	if !empty {
+		return contract.NewError(contract.ErrorCodeConflict, "Repository %s already exists in target backend", repo)
+	}
+
+	err = copyRepo(ctx, srcFs, srcWt, dstFs, dstWt)
+	if err == nil {
+		err = verifyCopy(ctx, srcFs, dstFs)
+	}
+
+	if err == nil {
+		err = compareRepo(ctx, srcFs, srcWt, dstFs, dstWt)
+	}
+
+	if err == nil {
+		err = svc.checkMigrateQuota(ctx, user, repo, from, to)
+	}
+
+	if err != nil {
+		delErr := svc.deleteBackendRepo(to, user, repo)
+		if delErr != nil {
+			log.Printf("Cannot remove repo: %s, user: %s, error: %v\n", repo, user, delErr)
+		}
+
+		return err
+	}
+
+	err = svc.setRepoBackend(user, repo, to)
+	if err != nil {
+		return err
+	}
+
+	if svc.git != nil && svc.git.name == repo {
+		svc.git = nil
+	}
+
+	svc.touchRepo(user, repo)
+
+	//copy is already in use, so failed removal leaves garbage only
+	err = svc.deleteBackendRepo(from, user, repo)
+	if err != nil {
+		log.Printf("Cannot remove migrated repo: %s, user: %s, error: %v\n", repo, user, err)
+	}
+
+	return nil
+}
+
+//checkMigrateQuota - backends keep the same repository in different number of bytes, only the difference is checked
+func (svc *service) checkMigrateQuota(ctx context.Context, user, repo string, from, to contract.FsType) error {
+	src, err := svc.repoUsage(from, user, repo)
+	if err != nil {
+		return err
+	}
+
+	dst, err := svc.repoUsage(to, user, repo)
+	if err != nil {
+		return err
+	}
+
+	return svc.checkQuota(ctx, user, repo, dst.ObjectBytes+dst.WorktreeBytes-src.ObjectBytes-src.WorktreeBytes, false)
+}
+
+func copyRepo(ctx context.Context, srcFs, srcWt, dstFs, dstWt billy.Filesystem) error {
+	err := copyDir(ctx, srcFs, dstFs, "", "")
+	if err != nil {
+		return err
+	}
+
+	//local worktree contains .git, it's already copied
+	return copyDir(ctx, srcWt, dstWt, "", ".git")
+}
+
+//copyDir - copies directory recursively, skip is name of top-level entry which isn't copied
+func copyDir(ctx context.Context, src, dst billy.Filesystem, dir, skip string) error {
+	infos, err := src.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, fi := range infos {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		if dir == "" && fi.Name() == skip {
+			continue
+		}
+
+		p := src.Join(dir, fi.Name())
+
+		switch {
+		case fi.IsDir():
+			{
+				err = dst.MkdirAll(p, fi.Mode().Perm())
+				if err != nil {
+					return err
+				}
+
+				err = copyDir(ctx, src, dst, p, skip)
+			}
+		case fi.Mode()&os.ModeSymlink != 0:
+			{
+				var target string
+
+				target, err = src.Readlink(p)
+				if err != nil {
+					return err
+				}
+
+				err = dst.Symlink(target, p)
+			}
+		default:
+			err = copyFile(src, dst, p, fi.Mode().Perm())
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst billy.Filesystem, path string, mode os.FileMode) error {
+	r, err := src.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := dst.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func compareRepo(ctx context.Context, srcFs, srcWt, dstFs, dstWt billy.Filesystem) error {
+	err := compareDir(ctx, srcFs, dstFs, "", "")
+	if err != nil {
+		return err
+	}
+
+	return compareDir(ctx, srcWt, dstWt, "", ".git")
+}
+
+//compareDir - checks that every file of src has the same content in dst, so index, config, metadata and worktree are checked too
+func compareDir(ctx context.Context, src, dst billy.Filesystem, dir, skip string) error {
+	infos, err := src.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, fi := range infos {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		if dir == "" && fi.Name() == skip {
+			continue
+		}
+
+		p := src.Join(dir, fi.Name())
+
+		switch {
+		case fi.IsDir():
+			err = compareDir(ctx, src, dst, p, skip)
+		case fi.Mode()&os.ModeSymlink != 0:
+			{
+				var target, copied string
+
+				target, err = src.Readlink(p)
+				if err == nil {
+					copied, err = dst.Readlink(p)
+				}
+
+				if err == nil && copied != target {
+					err = contract.NewError(contract.ErrorCodeInternal, "Symlink %s differs in copy", p)
+				}
+			}
+		default:
+			err = compareFile(src, dst, p)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func compareFile(src, dst billy.Filesystem, path string) error {
+	original, err := readFile(src, path)
+	if err != nil {
+		return err
+	}
+
+	copied, err := readFile(dst, path)
+	if err != nil {
+		return contract.NewError(contract.ErrorCodeInternal, "File %s is missing in copy: %v", path, err)
+	}
+
+	if !bytes.Equal(original, copied) {
+		return contract.NewError(contract.ErrorCodeInternal, "File %s differs in copy", path)
+	}
+
+	return nil
+}
+
+func readFile(fs billy.Filesystem, path string) ([]byte, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+//verifyCopy - fsck-style check: every object of source must be found in target with the right hash,
+//refs must be the same and history of every ref must be complete
+func verifyCopy(ctx context.Context, srcFs, dstFs billy.Filesystem) error {
+	src := filesystem.NewStorage(srcFs, cache.NewObjectLRUDefault())
+	dst := filesystem.NewStorage(dstFs, cache.NewObjectLRUDefault())
+
+	objects, err := src.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+
+	err = objects.ForEach(func(o plumbing.EncodedObject) error {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		copied, err := dst.EncodedObject(plumbing.AnyObject, o.Hash())
+		if err != nil {
+			return contract.NewError(contract.ErrorCodeInternal, "Object %s is missing in copy: %v", o.Hash(), err)
+		}
+
+		return checkObject(copied)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	refs, err := src.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	tips := []plumbing.Hash{}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		copied, err := dst.Reference(ref.Name())
+		if err != nil || copied.String() != ref.String() {
+			return contract.NewError(contract.ErrorCodeInternal, "Ref %s differs in copy", ref.Name())
+		}
+
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	//revlist fails if any object reachable from refs is missing
+	_, err = revlist.Objects(dst, tips, nil)
+	if err != nil {
+		return contract.NewError(contract.ErrorCodeInternal, "History of copy is incomplete: %v", err)
+	}
+
+	return nil
+}
+
+//checkObject - reads object and checks that its content matches its hash
+func checkObject(o plumbing.EncodedObject) error {
+	r, err := o.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	h := plumbing.NewHasher(o.Type(), o.Size())
+
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+
+	if h.Sum() != o.Hash() {
+		return contract.NewError(contract.ErrorCodeInternal, "Object %s is corrupted", o.Hash())
+	}
+
+	return nil
+}
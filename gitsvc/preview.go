@@ -0,0 +1,114 @@
+package gitsvc
+
+import (
+	"strings"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/object"
+	"bitbucket.org/vishjosh/bipp-go-git/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+//lineRange - lines [from, to) of base replaced by one side of merge, insertion has from == to
+type lineRange struct {
+	from int
+	to   int
+}
+
+//fileConflicts - file changed on both sides merges cleanly only if both modified it as text
+//and their changes don't overlap or touch each other, like in git
+func fileConflicts(base, ours, theirs *object.Commit, path string, ourAction, theirAction contract.ChangeAction) (bool, error) {
+	if ourAction != contract.ChangeActionModified || theirAction != contract.ChangeActionModified {
+		return true, nil
+	}
+
+	texts := []string{}
+
+	for _, c := range []*object.Commit{base, ours, theirs} {
+		f, err := c.File(path)
+		if err != nil {
+			return false, err
+		}
+
+		binary, err := f.IsBinary()
+		if err != nil {
+			return false, err
+		}
+
+		if binary {
+			return true, nil
+		}
+
+		text, err := f.Contents()
+		if err != nil {
+			return false, err
+		}
+
+		texts = append(texts, text)
+	}
+
+	return linesConflict(texts[0], texts[1], texts[2]), nil
+}
+
+//linesConflict - changes of ours and theirs conflict if they replace overlapping or adjacent lines of base
+func linesConflict(base, ours, theirs string) bool {
+	ourRanges := changedRanges(base, ours)
+	theirRanges := changedRanges(base, theirs)
+
+	for _, o := range ourRanges {
+		for _, t := range theirRanges {
+			if o.from <= t.to && t.from <= o.to {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//changedRanges - returns ranges of base lines replaced in other
+func changedRanges(base, other string) []lineRange {
+	res := []lineRange{}
+
+	line := 0
+	var cur *lineRange
+
+	for _, d := range diff.Do(base, other) {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			if cur != nil {
+				res = append(res, *cur)
+				cur = nil
+			}
+
+			line += countLines(d.Text)
+		case diffmatchpatch.DiffDelete:
+			if cur == nil {
+				cur = &lineRange{from: line, to: line}
+			}
+
+			line += countLines(d.Text)
+			cur.to = line
+		case diffmatchpatch.DiffInsert:
+			if cur == nil {
+				cur = &lineRange{from: line, to: line}
+			}
+		}
+	}
+
+	if cur != nil {
+		res = append(res, *cur)
+	}
+
+	return res
+}
+
+//countLines - the last line of file can be without line break
+func countLines(text string) int {
+	n := strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,159 @@
+package gitsvc
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+)
+
+const branchProtectionsMeta = "protected-branches"
+
+//branchOperation - operation which can be restricted by branch protection
+type branchOperation int
+
+const (
+	branchOperationCommit branchOperation = iota
+	branchOperationMerge
+	branchOperationPush
+	branchOperationForcePush
+	branchOperationRewrite
+	branchOperationRemove
+	branchOperationMergeRequest
+)
+
+//BranchProtections - returns protection rules of repository
+func (svc *service) BranchProtections(ctx context.Context, user, repo string) ([]contract.BranchProtection, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	rules := []contract.BranchProtection{}
+
+	err := svc.readMeta(user, repo, branchProtectionsMeta, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+//SetBranchProtection - creates or replaces protection rule with the same branch pattern
+func (svc *service) SetBranchProtection(ctx context.Context, user, repo string, rule *contract.BranchProtection) error {
+	if rule == nil || rule.Branch == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Branch cannot be empty")
+	}
+
+	_, err := path.Match(rule.Branch, "")
+	if err != nil {
+		return contract.NewError(contract.ErrorCodeValidation, "Wrong branch pattern %s: %v", rule.Branch, err)
+	}
+
+	if rule.RequiredApprovals < 0 {
+		return contract.NewError(contract.ErrorCodeValidation, "Required approvals cannot be negative")
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	rules, err := svc.BranchProtections(ctx, user, repo)
+	if err != nil {
+		return err
+	}
+
+	res := []contract.BranchProtection{*rule}
+
+	for _, r := range rules {
+		if r.Branch != rule.Branch {
+			res = append(res, r)
+		}
+	}
+
+	return svc.writeMeta(user, repo, branchProtectionsMeta, res)
+}
+
+//RemoveBranchProtection - removes protection rule by branch pattern
+func (svc *service) RemoveBranchProtection(ctx context.Context, user, repo, branch string) error {
+	if branch == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Branch cannot be empty")
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	rules, err := svc.BranchProtections(ctx, user, repo)
+	if err != nil {
+		return err
+	}
+
+	res := []contract.BranchProtection{}
+
+	for _, r := range rules {
+		if r.Branch != branch {
+			res = append(res, r)
+		}
+	}
+
+	return svc.writeMeta(user, repo, branchProtectionsMeta, res)
+}
+
+//checkBranchProtection - returns *contract.BranchProtectionError if current user cannot perform operation on branch
+func (svc *service) checkBranchProtection(ctx context.Context, user, repo, branch string, op branchOperation) error {
+	return svc.checkBranchProtectionBy(ctx, user, repo, branch, op, svc.user.Name)
+}
+
+//checkBranchProtectionBy - returns *contract.BranchProtectionError if actor cannot perform operation on branch
+func (svc *service) checkBranchProtectionBy(ctx context.Context, user, repo, branch string, op branchOperation, actor string) error {
+	rules, err := svc.BranchProtections(ctx, user, repo)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		ok, err := path.Match(r.Branch, branch)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		switch {
+		case op == branchOperationRemove && r.NoDeletion:
+			return &contract.BranchProtectionError{Branch: branch, Reason: "deletion is forbidden"}
+		case op == branchOperationForcePush && r.NoForcePush:
+			return &contract.BranchProtectionError{Branch: branch, Reason: "force push is forbidden"}
+		case op == branchOperationRewrite && r.NoForcePush:
+			return &contract.BranchProtectionError{Branch: branch, Reason: "rewriting history is forbidden"}
+		case (op == branchOperationCommit || op == branchOperationMerge) && r.RequireMergeRequest:
+			return &contract.BranchProtectionError{Branch: branch, Reason: "changes must be merged via merge request"}
+		}
+
+		if op != branchOperationRemove && len(r.Committers) > 0 && !contains(r.Committers, actor) {
+			return &contract.BranchProtectionError{Branch: branch, Reason: fmt.Sprintf("user %s isn't allowed to change it", actor)}
+		}
+	}
+
+	return nil
+}
+
+//checkCurrentBranchProtection - checks protection of branch where HEAD points now
+func (svc *service) checkCurrentBranchProtection(ctx context.Context, user, repo string, op branchOperation) error {
+	br, err := svc.CurrentBranch(ctx)
+	if err != nil {
+		return err
+	}
+
+	//nothing to protect in empty repository
+	if br == nil {
+		return nil
+	}
+
+	return svc.checkBranchProtection(ctx, user, repo, br.Name, op)
+}
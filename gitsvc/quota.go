@@ -0,0 +1,386 @@
+package gitsvc
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	git "bitbucket.org/vishjosh/bipp-go-git"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/object"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/storer"
+)
+
+const quotaMeta = "quota"
+
+//userQuota - quota of user as it's kept in metadata, Quota is nil for users with the default one
+type userQuota struct {
+	Quota *contract.Quota
+}
+
+//Quota - returns quota applied to user, it's the one from settings unless it was set for user
+func (svc *service) Quota(ctx context.Context, user string) (*contract.Quota, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	q := &userQuota{}
+
+	err := svc.readUserMeta(user, quotaMeta, q)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Quota == nil {
+		res := svc.settings.Quota
+		return &res, nil
+	}
+
+	return q.Quota, nil
+}
+
+//SetQuota - replaces quota of user, nil returns the one from settings
+func (svc *service) SetQuota(ctx context.Context, user string, quota *contract.Quota) error {
+	if user == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if quota != nil && (quota.UserBytes < 0 || quota.RepoBytes < 0 || quota.Repos < 0) {
+		return contract.NewError(contract.ErrorCodeValidation, "Quota cannot be negative")
+	}
+
+	return svc.writeUserMeta(user, quotaMeta, &userQuota{Quota: quota})
+}
+
+//Usage - returns storage taken by repositories of user, repositories in trash aren't counted.
+//Usage is kept in catalog, only repositories changed since the last call are walked
+func (svc *service) Usage(ctx context.Context, user string) (*contract.Usage, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	quota, err := svc.Quota(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := svc.catalogRepos(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &contract.Usage{User: user, Repos: len(repos), Items: []contract.RepoUsage{}, Quota: *quota}
+
+	for _, r := range repos {
+		res.ObjectBytes += r.ObjectBytes
+		res.WorktreeBytes += r.WorktreeBytes
+		res.Items = append(res.Items, contract.RepoUsage{Repo: r.Name, ObjectBytes: r.ObjectBytes, WorktreeBytes: r.WorktreeBytes})
+	}
+
+	return res, nil
+}
+
+//Usages - returns usage of all users who have metadata, users whose usage can't be calculated are skipped
+func (svc *service) Usages(ctx context.Context) ([]contract.Usage, error) {
+	users, err := svc.metaUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.Usage{}
+
+	for _, user := range users {
+		u, err := svc.Usage(ctx, user)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			log.Printf("Cannot calculate usage of user: %s, error: %v\n", user, err)
+			continue
+		}
+
+		res = append(res, *u)
+	}
+
+	return res, nil
+}
+
+//repoUsage - objects are counted apart from refs, index and metadata, they are the bulk of storage
+func (svc *service) repoUsage(backend contract.FsType, user, repo string) (*contract.RepoUsage, error) {
+	fs, wt, err := svc.backendFs(backend, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := dirSize(fs, "objects")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := worktreeSize(wt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contract.RepoUsage{Repo: repo, ObjectBytes: objects, WorktreeBytes: files}, nil
+}
+
+//worktreeSize - local repository keeps .git inside of worktree, it's counted as objects
+func worktreeSize(fs billy.Filesystem) (int64, error) {
+	infos, err := fs.ReadDir("")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	var size int64
+
+	for _, fi := range infos {
+		if fi.Name() == ".git" {
+			continue
+		}
+
+		if !fi.IsDir() {
+			size += fi.Size()
+			continue
+		}
+
+		s, err := dirSize(fs, fi.Name())
+		if err != nil {
+			return 0, err
+		}
+
+		size += s
+	}
+
+	return size, nil
+}
+
+//checkQuota - fails if quota of user would be exceeded when repository grows by size bytes,
+//newRepo is set when repository is about to be created. Usage is calculated only when quota is set
+func (svc *service) checkQuota(ctx context.Context, user, repo string, size int64, newRepo bool) error {
+	quota, err := svc.Quota(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	if quota.Repos == 0 && quota.UserBytes == 0 && quota.RepoBytes == 0 {
+		return nil
+	}
+
+	if newRepo && quota.Repos > 0 {
+		repos, err := svc.catalogRepos(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		if len(repos) >= quota.Repos {
+			return quotaError(user, "repos", int64(len(repos)+1), int64(quota.Repos), "Quota of user %s allows %d repositories", user, quota.Repos)
+		}
+	}
+
+	if quota.UserBytes == 0 && quota.RepoBytes == 0 {
+		return nil
+	}
+
+	usage, err := svc.Usage(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	var repoBytes int64
+
+	for _, u := range usage.Items {
+		if u.Repo == repo {
+			repoBytes = u.ObjectBytes + u.WorktreeBytes
+		}
+	}
+
+	if quota.RepoBytes > 0 && repoBytes+size > quota.RepoBytes {
+		return quotaError(user, "repoBytes", repoBytes+size, quota.RepoBytes, "Quota of repository %s is exceeded: %d of %d bytes", repo, repoBytes+size, quota.RepoBytes)
+	}
+
+	userBytes := usage.ObjectBytes + usage.WorktreeBytes
+
+	if quota.UserBytes > 0 && userBytes+size > quota.UserBytes {
+		return quotaError(user, "userBytes", userBytes+size, quota.UserBytes, "Quota of user %s is exceeded: %d of %d bytes", user, userBytes+size, quota.UserBytes)
+	}
+
+	return nil
+}
+
+func quotaError(user, limit string, used, max int64, format string, args ...interface{}) error {
+	err := contract.NewError(contract.ErrorCodeQuota, format, args...)
+	err.Details = map[string]string{"user": user, "limit": limit, "used": strconv.FormatInt(used, 10), "max": strconv.FormatInt(max, 10)}
+
+	return err
+}
+
+//fileGrowth - how much worktree grows when file is replaced by content
+func fileGrowth(fs billy.Filesystem, path, content string) int64 {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return int64(len(content))
+	}
+
+	return int64(len(content)) - fi.Size()
+}
+
+//fetchState - refs and packs of repository before fetch, fetch writes its objects to a new pack
+type fetchState struct {
+	refs  map[plumbing.ReferenceName]*plumbing.Reference
+	packs map[plumbing.Hash]bool
+}
+
+func saveFetchState(r *git.Repository) (*fetchState, error) {
+	res := &fetchState{refs: map[plumbing.ReferenceName]*plumbing.Reference{}, packs: map[plumbing.Hash]bool{}}
+
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		res.refs[ref.Name()] = ref
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	packed, ok := r.Storer.(storer.PackedObjectStorer)
+	if !ok {
+		return res, nil
+	}
+
+	packs, err := packed.ObjectPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range packs {
+		res.packs[h] = true
+	}
+
+	return res, nil
+}
+
+//discardFetch - returns refs to state before fetch and removes packs it wrote, so objects which don't fit
+//into quota don't stay in storage
+func discardFetch(r *git.Repository, state *fetchState) error {
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	added := []plumbing.ReferenceName{}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if _, ok := state.refs[ref.Name()]; !ok {
+			added = append(added, ref.Name())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range added {
+		err = r.Storer.RemoveReference(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range state.refs {
+		err = r.Storer.SetReference(ref)
+		if err != nil {
+			return err
+		}
+	}
+
+	packed, ok := r.Storer.(storer.PackedObjectStorer)
+	if !ok {
+		return nil
+	}
+
+	packs, err := packed.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range packs {
+		if state.packs[h] {
+			continue
+		}
+
+		err = packed.DeleteOldObjectPackAndIndex(h, time.Time{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//pullGrowth - pull only fast-forwards branch, so worktree becomes tree of fetched branch
+func pullGrowth(r *git.Repository, remote string) (int64, error) {
+	branch := headBranch(r)
+	if branch == "" {
+		return 0, nil
+	}
+
+	fetched, err := r.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if err != nil {
+		return 0, nil
+	}
+
+	after, err := commitFilesSize(r, fetched.Hash())
+	if err != nil {
+		return 0, err
+	}
+
+	var before int64
+
+	head, err := r.Reference(plumbing.HEAD, true)
+	if err == nil {
+		before, err = commitFilesSize(r, head.Hash())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return after - before, nil
+}
+
+func commitFilesSize(r *git.Repository, h plumbing.Hash) (int64, error) {
+	c, err := r.CommitObject(h)
+	if err != nil {
+		return 0, err
+	}
+
+	files, err := c.Files()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+
+	err = files.ForEach(func(f *object.File) error {
+		size += f.Size
+		return nil
+	})
+
+	return size, err
+}
@@ -1,12 +1,15 @@
 package gitsvc
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,17 +17,25 @@ import (
 	git "bitbucket.org/vishjosh/bipp-go-git"
 	"bitbucket.org/vishjosh/bipp-go-git/config"
 	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/objfs"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/sqlfs"
 	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
 	"bitbucket.org/vishjosh/bipp-go-git/go-billy/osfs"
 	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
 	"bitbucket.org/vishjosh/bipp-go-git/plumbing/cache"
 	"bitbucket.org/vishjosh/bipp-go-git/plumbing/format/index"
 	"bitbucket.org/vishjosh/bipp-go-git/plumbing/object"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/transport"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/transport/client"
 	"bitbucket.org/vishjosh/bipp-go-git/plumbing/transport/http"
+	"bitbucket.org/vishjosh/bipp-go-git/storage"
 	"bitbucket.org/vishjosh/bipp-go-git/storage/filesystem"
 	"bitbucket.org/vishjosh/bipp-go-git/storage/mysqlfs"
+	"bitbucket.org/vishjosh/bipp-go-git/utils/merkletrie"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 const filesPrefix = "files_"
@@ -34,34 +45,34 @@ const gitPrefix = "git_"
 type Service interface {
 
 	//CurrentUser - current login user
-	CurrentUser() *contract.User
+	CurrentUser(ctx context.Context) *contract.User
 
 	//SwitchUser - change user from which we are using app
-	SwitchUser(user *contract.User) error
+	SwitchUser(ctx context.Context, user *contract.User) error
 
 	//Filesystem returns fs of current repository
-	Filesystem(user, repo string) (billy.Filesystem, error)
+	Filesystem(ctx context.Context, user, repo string) (billy.Filesystem, error)
 
 	//FilesList - returns current repository files pathes
-	FilesList(rq *contract.BaseRequest) ([]contract.FileInfo, error)
+	FilesList(ctx context.Context, rq *contract.BaseRequest) ([]contract.FileInfo, error)
 
-	// Repositories - returns all locally existing repositories
-	Repositories(user string) ([]string, error)
+	// Repositories - returns page of repositories from catalog, q can be nil to get all of them ordered by name
+	Repositories(ctx context.Context, user string, q *contract.RepositoryQuery) (*contract.RepositoryPage, error)
 
 	//CreateRepository - creates a new repository
-	CreateRepository(user, repo string) error
+	CreateRepository(ctx context.Context, user, repo string, opts *contract.RepoOptions) error
 
 	//OpenRepository - opens an existing repository
-	OpenRepository(user, repo string) error
+	OpenRepository(ctx context.Context, user, repo string) error
 
-	//RemoveRepository - removes specified repository permanently
-	RemoveRepository(user, repo string) error
+	//RemoveRepository - removes specified repository, it can be restored from trash while retention lasts
+	RemoveRepository(ctx context.Context, user, repo string) error
 
 	//CurrentRepository returns current repository name
-	CurrentRepository() (name string)
+	CurrentRepository(ctx context.Context) (name string)
 
-	// Clone the given repository to the given directory
-	Clone(user, url string, auth *contract.Credentials) (string, error)
+	// Clone the given repository to the given directory, opts can be nil to clone everything
+	Clone(ctx context.Context, user, url string, auth *contract.Credentials, opts *contract.CloneOptions) (string, error)
 
 	// Fetch fetches references along with the objects necessary to complete
 	// their histories, from the remote named as FetchOptions.RemoteName.
@@ -69,98 +80,254 @@ type Service interface {
 	//
 	// Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
 	// no changes to be fetched, or an error.
-	Fetch(user, repo, remote string, auth *contract.Credentials) error
+	Fetch(ctx context.Context, user, repo, remote string, auth *contract.Credentials) error
 
 	// Pull incorporates changes from a remote repository into the current branch.
 	// Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
 	// no changes to be fetched, or an error.
-	Pull(rq *contract.BaseRequest, remote string, auth *contract.Credentials) (string, error)
+	Pull(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials) (string, error)
 
 	// Push performs a push to the remote. Returns NoErrAlreadyUpToDate if
 	// the remote was already up-to-date, from the remote named as
 	// FetchOptions.RemoteName.
 	// If `remote` parameter is empty, use "origin" by default
 	// Use credentials if needed. Remote also can be empty
-	Push(rq *contract.BaseRequest, remote string, auth *contract.Credentials) error
+	// If `force` is set, current branch is pushed with force
+	Push(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials, force bool) error
 
 	//Commit - commits changes and returns commit hash
-	Commit(rq *contract.BaseRequest, msg string) (string, error)
+	Commit(ctx context.Context, rq *contract.BaseRequest, msg string) (string, error)
 
 	//Merge - analog of git merge command
-	Merge(rq *contract.BaseRequest, branch string) (string, error)
+	Merge(ctx context.Context, rq *contract.BaseRequest, branch string) (string, error)
+
+	//Reset - resets current branch to specified commit, if hard is set worktree is reset too
+	Reset(ctx context.Context, rq *contract.BaseRequest, commit string, hard bool) error
 
 	//MergeMsgShort - returns MERGE_MSG file content  with trimming strings which begin from "#"
-	MergeMsgShort(rq *contract.BaseRequest) (string, error)
+	MergeMsgShort(ctx context.Context, rq *contract.BaseRequest) (string, error)
 
 	//MergeMsgFull - returns MERGE_MSG file content  without trimming strings which begin from "#"
-	MergeMsgFull(rq *contract.BaseRequest) (string, error)
+	MergeMsgFull(ctx context.Context, rq *contract.BaseRequest) (string, error)
 
 	//AbortMerge will abort the merge process and try to reconstruct the pre-merge state
-	AbortMerge(rq *contract.BaseRequest) error
+	AbortMerge(ctx context.Context, rq *contract.BaseRequest) error
+
+	//MergePreview - computes merge result in memory without touching the worktree or index
+	MergePreview(ctx context.Context, rq *contract.BaseRequest, theirs string) (*contract.MergePreview, error)
 
 	//ConflictFileList - returns pathes of files with conflicts
-	ConflictFileList(rq *contract.BaseRequest) ([]string, error)
+	ConflictFileList(ctx context.Context, rq *contract.BaseRequest) ([]string, error)
 
 	//FilesList - returns current repository files pathes
-	ConflictFiles(rq *contract.BaseRequest, path string) ([]contract.MergeFile, error)
+	ConflictFiles(ctx context.Context, rq *contract.BaseRequest, path string) ([]contract.MergeFile, error)
 
 	//Checkout - switches branch to specified commit
-	Checkout(user, repo string, commit string) error
+	Checkout(ctx context.Context, user, repo string, commit string) error
 
 	//CheckoutBranch - switch to specified existing branch or creates new branch if it doesn't exist
-	CheckoutBranch(user, repo, branch string) error
+	CheckoutBranch(ctx context.Context, user, repo, branch string) error
 
 	//CreateBranch - creates a new branch from specified commit, if commit is empty new branch will be created from current commit
-	CreateBranch(user, repo, branch, commit string) error
+	CreateBranch(ctx context.Context, user, repo, branch, commit string) error
 
 	//RemoveBranch - removes specified branch
-	RemoveBranch(user, repo, branch string) error
+	RemoveBranch(ctx context.Context, user, repo, branch string) error
 
 	//CurrentBranch - returns information where HEAD points now
-	CurrentBranch() (*contract.Branch, error)
+	CurrentBranch(ctx context.Context) (*contract.Branch, error)
 
 	//Branches - returns a list of local branches names
-	Branches(user, repo string) ([]string, error)
+	Branches(ctx context.Context, user, repo string) ([]string, error)
 
 	//Add - adds the file content to the staging area
-	Add(rq *contract.BaseRequest, path string) error
+	Add(ctx context.Context, rq *contract.BaseRequest, path string) error
 
 	//Log - Gets the HEAD history from HEAD, just like command "git log"
-	Log(rq *contract.BaseRequest) ([]contract.Commit, error)
+	Log(ctx context.Context, rq *contract.BaseRequest) ([]contract.Commit, error)
 
 	//CreateRemote - creates a new remote, if name isn't specified it use "origin" by default
-	CreateRemote(user, repo, url, name string) (*git.Remote, error)
+	CreateRemote(ctx context.Context, user, repo, url, name string) (*git.Remote, error)
 
 	//RemoveRemote - delete the remote and it's config from the repository
-	RemoveRemote(user, repo, name string) error
+	RemoveRemote(ctx context.Context, user, repo, name string) error
 
 	//Remotes - returns a list with all remotes
-	Remotes(user, repo string) ([]*git.Remote, error)
+	Remotes(ctx context.Context, user, repo string) ([]*git.Remote, error)
 
 	//Remote returns a remote if exists or git.ErrRemoteNotFound
-	Remote(user, repo, name string) (*git.Remote, error)
+	Remote(ctx context.Context, user, repo, name string) (*git.Remote, error)
 
 	//File - returns billy file
-	File(rq *contract.BaseRequest, path string) (billy.File, error)
+	File(ctx context.Context, rq *contract.BaseRequest, path string) (billy.File, error)
 
 	//AddFile - add file to Filesystem and stage it
-	AddFile(rq *contract.BaseRequest, path, content string) error
+	AddFile(ctx context.Context, rq *contract.BaseRequest, path, content string) error
 
 	//EditFile - save changed in file and stage changes
-	EditFile(rq *contract.BaseRequest, path, content string) error
+	EditFile(ctx context.Context, rq *contract.BaseRequest, path, content string) error
 
 	//RemoveFile - remove file from Filesystem and stage changes
-	RemoveFile(rq *contract.BaseRequest, path string) error
+	RemoveFile(ctx context.Context, rq *contract.BaseRequest, path string) error
 
 	// Status - returns the working tree status
-	Status(rq *contract.BaseRequest) (git.Status, error)
+	Status(ctx context.Context, rq *contract.BaseRequest) (git.Status, error)
+
+	//CreateMergeRequest - opens a new merge request from source to target branch
+	CreateMergeRequest(ctx context.Context, user, repo string, mr *contract.MergeRequest) (*contract.MergeRequest, error)
+
+	//MergeRequests - returns merge requests of repository, filter can be nil
+	MergeRequests(ctx context.Context, user, repo string, filter *contract.MergeRequestFilter) ([]contract.MergeRequest, error)
+
+	//MergeRequest - returns merge request by id or contract.ErrMergeRequestNotFound
+	MergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error)
+
+	//CommentMergeRequest - adds comment to merge request, comment can be tied to file line
+	CommentMergeRequest(ctx context.Context, user, repo string, id int64, comment *contract.MergeRequestComment) (*contract.MergeRequest, error)
+
+	//ApproveMergeRequest - adds approval to open merge request, author is user by default
+	ApproveMergeRequest(ctx context.Context, user, repo string, id int64, author string) (*contract.MergeRequest, error)
+
+	//AcceptMergeRequest - merges source branch into target one on behalf of by and marks merge request as merged,
+	//approvals required by protection of target are checked
+	AcceptMergeRequest(ctx context.Context, user, repo string, id int64, by *contract.User) (*contract.MergeRequest, error)
+
+	//CloseMergeRequest - closes open merge request without merging
+	CloseMergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error)
+
+	//ReopenMergeRequest - reopens closed merge request
+	ReopenMergeRequest(ctx context.Context, user, repo string, id int64) (*contract.MergeRequest, error)
+
+	//BranchProtections - returns protection rules of repository
+	BranchProtections(ctx context.Context, user, repo string) ([]contract.BranchProtection, error)
+
+	//SetBranchProtection - creates or replaces protection rule with the same branch pattern
+	SetBranchProtection(ctx context.Context, user, repo string, rule *contract.BranchProtection) error
+
+	//RemoveBranchProtection - removes protection rule by branch pattern
+	RemoveBranchProtection(ctx context.Context, user, repo, branch string) error
+
+	//RegisterHook - adds hook which is called by Commit, Merge and AddFile after built-in rules
+	RegisterHook(h Hook)
+
+	//HookRules - returns built-in hook rules of repository
+	HookRules(ctx context.Context, user, repo string) (*contract.HookRules, error)
+
+	//SetHookRules - replaces built-in hook rules of repository
+	SetHookRules(ctx context.Context, user, repo string, rules *contract.HookRules) error
+
+	//Subscribe - registers handler of repository events, call cancel to unsubscribe
+	Subscribe(h EventHandler) (cancel func())
+
+	//CreateWebhook - subscribes URL to repository events
+	CreateWebhook(ctx context.Context, user, repo string, hook *contract.Webhook) (*contract.Webhook, error)
+
+	//Webhooks - returns webhooks of repository
+	Webhooks(ctx context.Context, user, repo string) ([]contract.Webhook, error)
+
+	//RemoveWebhook - removes webhook by id
+	RemoveWebhook(ctx context.Context, user, repo string, id int64) error
+
+	//WebhookDeliveries - returns latest deliveries of webhook, the newest first
+	WebhookDeliveries(ctx context.Context, user, repo string, id int64) ([]contract.WebhookDelivery, error)
+
+	//Export - writes git bundle with all refs of repository, it can be read by Import or `git clone`
+	Export(ctx context.Context, user, repo string, w io.Writer) error
+
+	//Import - creates a new repository from git bundle
+	Import(ctx context.Context, user, repo string, bundle io.Reader) error
+
+	//Archive - writes files of revision (HEAD if empty) as zip or tar.gz archive
+	Archive(ctx context.Context, user, repo, rev string, format contract.ArchiveFormat, w io.Writer) error
+
+	//Migrate - moves repository to another backend, source is removed only when the whole copy is checked
+	Migrate(ctx context.Context, user, repo string, to contract.FsType) error
+
+	//MigrateLayout - moves all repositories kept in MySQL from per-repository tables to shared ones
+	MigrateLayout(ctx context.Context) error
+
+	//GC - prunes unreachable objects older than grace period and repacks repository, reclaimed bytes are reported
+	GC(ctx context.Context, user, repo string) (*contract.GCResult, error)
+
+	//Verify - checks that objects reachable from refs exist and match their hashes and index is valid
+	Verify(ctx context.Context, user, repo string) (*contract.VerifyReport, error)
+
+	//Trash - returns removed repositories of user which can still be restored
+	Trash(ctx context.Context, user string) ([]contract.Repository, error)
+
+	//Backup - writes bundle and metadata of repository to backup directory
+	Backup(ctx context.Context, user, repo string) (*contract.Backup, error)
+
+	//Backups - returns backups of repository, the newest first
+	Backups(ctx context.Context, user, repo string) ([]contract.Backup, error)
+
+	//RestoreRepository - returns repository from trash if backup is empty, otherwise recreates it with metadata from backup
+	RestoreRepository(ctx context.Context, user, repo, backup string) error
+
+	//Fork - clones repository of user to repository of toUser inside of the server, source becomes `upstream` remote
+	Fork(ctx context.Context, user, repo, toUser, toRepo string) (string, error)
+
+	//Forks - returns existing forks of repository
+	Forks(ctx context.Context, user, repo string) ([]contract.Fork, error)
+
+	//Usage - returns storage taken by repositories of user and quota applied to user
+	Usage(ctx context.Context, user string) (*contract.Usage, error)
+
+	//Usages - returns usage of all users
+	Usages(ctx context.Context) ([]contract.Usage, error)
+
+	//Quota - returns quota of user, it's the one from settings unless it was set for user
+	Quota(ctx context.Context, user string) (*contract.Quota, error)
+
+	//SetQuota - replaces quota of user, nil returns the one from settings
+	SetQuota(ctx context.Context, user string, quota *contract.Quota) error
+
+	//SubmitJob - queues clone, fetch, pull, push, gc or backup, jobs run one by one in background
+	SubmitJob(ctx context.Context, job *contract.Job, auth *contract.Credentials) (*contract.Job, error)
+
+	//Job - returns job by id or contract.ErrJobNotFound
+	Job(ctx context.Context, user string, id int64) (*contract.Job, error)
+
+	//Jobs - returns job history of user, the newest first
+	Jobs(ctx context.Context, user string) ([]contract.Job, error)
+
+	//CancelJob - cancels queued or running job
+	CancelJob(ctx context.Context, user string, id int64) error
+
+	//Close - stops jobs and schedulers, queued and running jobs are canceled
+	Close() error
 }
 
 type service struct {
+	//ops - taken by every operation which uses user and repository opened by service, see lockedService
+	ops sync.Mutex
+	//busy - repositories transferred without ops, guarded by ops, idle is signaled when transfer is finished
+	busy     map[string]bool
+	idle     *sync.Cond
 	user     *contract.User
 	settings *contract.ServerSettings
 	git      *repository
 	db       *sqlx.DB
+	pg       *sqlx.DB
+	sqlite   *sqlx.DB
+	memory   *memoryBackend
+	objects  objfs.Store
+	meta     sync.Mutex
+	repos    catalogStore
+	catalog  sync.Mutex
+	//stale - repositories which sizes must be recalculated, guarded by catalog
+	stale    map[string]bool
+	hooksMu  sync.RWMutex
+	hooks    []Hook
+	events   *eventBus
+	webhooks *webhookDispatcher
+	jobs     *jobRunner
+	//scanned - repositories created before catalog appeared are added to it, guarded by catalog
+	scanned bool
+	//done - closed when service is stopped, workers are jobs runner and schedulers
+	done      chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
 }
 
 type repository struct {
@@ -168,25 +335,127 @@ type repository struct {
 	name string
 	repo *git.Repository
 	fs   billy.Filesystem
+	//backend - where repository was opened, repository migrated by another process is reopened
+	backend contract.FsType
 }
 
 //New - create an instance of gitSvc
 func New(s *contract.ServerSettings, db *sqlx.DB) (Service, error) {
 
-	return &service{user: &contract.User{}, settings: s, db: db}, nil
+	svc := &service{user: &contract.User{}, settings: s, db: db, stale: make(map[string]bool), busy: make(map[string]bool), memory: newMemoryBackend(), done: make(chan struct{})}
+	svc.idle = sync.NewCond(&svc.ops)
+
+	//connection is opened lazily, so server starts even if Postgres isn't reachable yet
+	if s.PgConnStr != "" {
+		pg, err := sqlx.Open("postgres", s.PgConnStr)
+		if err != nil {
+			return nil, err
+		}
+
+		svc.pg = pg
+	}
+
+	if s.SQLitePath != "" {
+		sqlite, err := sqlx.Open("sqlite3", s.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+
+		//SQLite allows one writer only, single connection avoids "database is locked" errors
+		sqlite.SetMaxOpenConns(1)
+		svc.sqlite = sqlite
+	}
+
+	repos, err := svc.newCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	svc.repos = repos
+
+	if db != nil && s.MySQLLayout == contract.MySQLLayoutShared {
+		err := createRegistries(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.S3.Endpoint != "" {
+		store, err := newObjectStore(&s.S3)
+		if err != nil {
+			return nil, err
+		}
+
+		svc.objects = objfs.NewCache(store, objectCacheSize)
+	}
+
+	svc.hooks = []Hook{&rulesHook{svc: svc}}
+	svc.events = newEventBus()
+	svc.webhooks = newWebhookDispatcher(svc)
+	svc.jobs = newJobRunner(svc)
+	svc.events.subscribe(svc.webhooks.handle)
+	svc.events.subscribe(svc.handleCatalogEvent)
+
+	services.add(svc)
+
+	internalOnce.Do(func() {
+		client.InstallProtocol(internalScheme, &internalTransport{})
+	})
+
+	svc.startBackfill()
+
+	if s.GCInterval > 0 {
+		svc.schedule("garbage collection", s.GCInterval, svc.maintainAll("garbage collection", svc.gcRepo))
+	}
+
+	if s.TrashRetention > 0 {
+		svc.schedule("trash purge", trashPurgeInterval, svc.purgeTrash)
+	}
+
+	if s.BackupInterval > 0 && s.BackupDir != "" {
+		svc.schedule("backups", s.BackupInterval, svc.maintainAll("backup", svc.backupRepo))
+	}
+
+	return &lockedService{svc: svc}, nil
+}
+
+//Close - stops jobs and schedulers, waits for canceled job and webhook deliveries and closes databases opened by New
+func (svc *service) Close() error {
+	var err error
+
+	svc.closeOnce.Do(func() {
+		close(svc.done)
+		services.remove(svc)
+		svc.jobs.cancelAll()
+		svc.workers.Wait()
+		svc.webhooks.wait()
+
+		for _, db := range []*sqlx.DB{svc.pg, svc.sqlite} {
+			if db == nil {
+				continue
+			}
+
+			cerr := db.Close()
+			if err == nil {
+				err = cerr
+			}
+		}
+	})
+
+	return err
 }
 
-func (svc *service) File(rq *contract.BaseRequest, path string) (billy.File, error) {
+func (svc *service) File(ctx context.Context, rq *contract.BaseRequest, path string) (billy.File, error) {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return nil, err
 	}
 
 	if path == "" {
-		return nil, errors.New("path cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "path cannot be empty")
 	}
 
-	err = svc.setSettings(&contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, &contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
 	if err != nil {
 		return nil, err
 	}
@@ -195,30 +464,40 @@ func (svc *service) File(rq *contract.BaseRequest, path string) (billy.File, err
 	f, err := fs.OpenFile(path, os.O_RDONLY, 0666)
 
 	if err != nil {
-		return nil, err
+		return nil, toError(err)
 	}
 
 	return f, nil
 }
 
-func (svc *service) AddFile(rq *contract.BaseRequest, path, content string) error {
+func (svc *service) AddFile(ctx context.Context, rq *contract.BaseRequest, path, content string) error {
 	if rq == nil {
-		return errors.New("rq cannot be nil")
+		return contract.NewError(contract.ErrorCodeValidation, "rq cannot be nil")
 	}
 
 	if rq.User == nil {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if rq.Repository == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
 	if path == "" {
-		return errors.New("path cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "path cannot be empty")
+	}
+
+	err := svc.setSettings(ctx, &contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
+	if err != nil {
+		return err
+	}
+
+	err = svc.runPreCommitHooks(ctx, rq, []contract.HookFile{{Path: path, Size: int64(len(content)), Content: hookContent(content)}})
+	if err != nil {
+		return err
 	}
 
-	err := svc.setSettings(&contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
+	err = svc.checkQuota(ctx, rq.User.Name, rq.Repository, fileGrowth(svc.git.fs, path, content), false)
 	if err != nil {
 		return err
 	}
@@ -227,31 +506,54 @@ func (svc *service) AddFile(rq *contract.BaseRequest, path, content string) erro
 	defer svc.git.Unlock()
 
 	fs := svc.git.fs
-	_, err = fs.Create(path)
+	f, err := fs.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write([]byte(content))
+	if err != nil {
+		f.Close()
+		return err
+	}
 
+	err = f.Close()
 	if err != nil {
 		return err
 	}
 
 	wt, err := svc.git.repo.Worktree()
 	if err != nil {
-		return nil
+		return err
 	}
 
-	return wt.Add(path)
+	err = wt.Add(path)
+	if err != nil {
+		return err
+	}
+
+	svc.publishStatus(rq, path, contract.ChangeActionAdded)
+
+	return nil
 }
 
-func (svc *service) EditFile(rq *contract.BaseRequest, path, content string) error {
+func (svc *service) EditFile(ctx context.Context, rq *contract.BaseRequest, path, content string) error {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return err
 	}
 
 	if path == "" {
-		return errors.New("path cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "path cannot be empty")
+	}
+
+	err = svc.setSettings(ctx, &contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
+	if err != nil {
+		return err
 	}
 
-	err = svc.setSettings(&contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
+	err = svc.checkQuota(ctx, rq.User.Name, rq.Repository, fileGrowth(svc.git.fs, path, content), false)
 	if err != nil {
 		return err
 	}
@@ -274,23 +576,30 @@ func (svc *service) EditFile(rq *contract.BaseRequest, path, content string) err
 
 	wt, err := svc.git.repo.Worktree()
 	if err != nil {
-		return nil
+		return err
 	}
 
-	return wt.Add(path)
+	err = wt.Add(path)
+	if err != nil {
+		return err
+	}
+
+	svc.publishStatus(rq, path, contract.ChangeActionModified)
+
+	return nil
 }
 
-func (svc *service) RemoveFile(rq *contract.BaseRequest, path string) error {
+func (svc *service) RemoveFile(ctx context.Context, rq *contract.BaseRequest, path string) error {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return err
 	}
 
 	if path == "" {
-		return errors.New("path cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "path cannot be empty")
 	}
 
-	err = svc.setSettings(&contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, &contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
 	if err != nil {
 		return err
 	}
@@ -307,16 +616,23 @@ func (svc *service) RemoveFile(rq *contract.BaseRequest, path string) error {
 		return err
 	}
 
-	return wt.Add(path)
+	err = wt.Add(path)
+	if err != nil {
+		return err
+	}
+
+	svc.publishStatus(rq, path, contract.ChangeActionDeleted)
+
+	return nil
 }
 
-func (svc *service) Status(rq *contract.BaseRequest) (git.Status, error) {
+func (svc *service) Status(ctx context.Context, rq *contract.BaseRequest) (git.Status, error) {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return nil, err
 	}
 
-	err = svc.setSettings(&contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, &contract.User{Name: rq.User.Name}, rq.Repository, rq.Branch)
 	if err != nil {
 		return nil, err
 	}
@@ -329,14 +645,19 @@ func (svc *service) Status(rq *contract.BaseRequest) (git.Status, error) {
 	return wt.Status()
 }
 
-func (svc *service) SwitchUser(user *contract.User) error {
+func (svc *service) SwitchUser(ctx context.Context, user *contract.User) error {
 
 	if user == nil {
-		return errors.New("user cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "user cannot be empty")
 	}
 
 	if user.Name == "" {
-		return errors.New("userName cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "userName cannot be empty")
+	}
+
+	err := validateUserName(user.Name)
+	if err != nil {
+		return err
 	}
 
 	if user.Email == "" {
@@ -355,33 +676,46 @@ func (svc *service) SwitchUser(user *contract.User) error {
 	return nil
 }
 
-func (svc *service) CurrentUser() *contract.User {
+func (svc *service) CurrentUser(ctx context.Context) *contract.User {
 	return svc.user
 }
 
-func (svc *service) setSettings(user *contract.User, repo, branch string) error {
+func (svc *service) setSettings(ctx context.Context, user *contract.User, repo, branch string) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	//operation waits for transfer of the same repository, other operations could run meanwhile
+	if user != nil {
+		svc.waitIdle(user.Name, repo)
+	}
 
-	err := svc.SwitchUser(user)
+	err = svc.SwitchUser(ctx, user)
 	if err != nil {
 		return err
 	}
 
-	if svc.git == nil || (svc.git != nil && svc.git.name != repo) {
+	opened, err := svc.repoOpened(user.Name, repo)
+	if err != nil {
+		return err
+	}
 
-		err = svc.OpenRepository(user.Name, repo)
+	if !opened {
+		err = svc.OpenRepository(ctx, user.Name, repo)
 		if err != nil {
 			return err
 		}
 	}
 
 	if branch != "" {
-		currBr, err := svc.CurrentBranch()
+		currBr, err := svc.CurrentBranch(ctx)
 		if err != nil {
 			return err
 		}
 
 		if currBr.Name != branch {
-			err = svc.CheckoutBranch(user.Name, repo, branch)
+			err = svc.CheckoutBranch(ctx, user.Name, repo, branch)
 			if err != nil {
 				return err
 			}
@@ -391,21 +725,40 @@ func (svc *service) setSettings(user *contract.User, repo, branch string) error
 	return nil
 }
 
+//restoreState - switches back to user and repository which were opened before background operation,
+//repository is reopened, because the operation could change its storage
+func (svc *service) restoreState(user *contract.User, git *repository) {
+	svc.user = user
+	svc.git = nil
+
+	if git == nil {
+		return
+	}
+
+	r, err := svc.openRepo(user.Name, git.name)
+	if err != nil {
+		log.Printf("Cannot reopen repo: %s, user: %s, error: %v\n", git.name, user.Name, err)
+		return
+	}
+
+	svc.git = r
+}
+
 func (svc *service) validateBaseRQ(rq *contract.BaseRequest) error {
 	if rq == nil {
-		return errors.New("rq cannot be nil")
+		return contract.NewError(contract.ErrorCodeValidation, "rq cannot be nil")
 	}
 
 	if rq.User == nil {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if rq.Repository == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
 	if rq.Branch == "" {
-		return errors.New("Branch cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Branch cannot be empty")
 	}
 
 	return nil
@@ -413,31 +766,31 @@ func (svc *service) validateBaseRQ(rq *contract.BaseRequest) error {
 
 func (svc *service) validateBaseRQWithoutBranch(rq *contract.BaseRequest) error {
 	if rq == nil {
-		return errors.New("rq cannot be nil")
+		return contract.NewError(contract.ErrorCodeValidation, "rq cannot be nil")
 	}
 
 	if rq.User == nil {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if rq.Repository == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
 	return nil
 }
 
 //Filesystem returns fs of current repository
-func (svc *service) Filesystem(user, repo string) (billy.Filesystem, error) {
+func (svc *service) Filesystem(ctx context.Context, user, repo string) (billy.Filesystem, error) {
 	if user == "" {
-		return nil, errors.New("User cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return nil, errors.New("Repository cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return nil, err
 	}
@@ -449,14 +802,14 @@ func (svc *service) Filesystem(user, repo string) (billy.Filesystem, error) {
 type empty struct{}
 
 //FilesList - returns current repository files pathes
-func (svc *service) FilesList(rq *contract.BaseRequest) ([]contract.FileInfo, error) {
+func (svc *service) FilesList(ctx context.Context, rq *contract.BaseRequest) ([]contract.FileInfo, error) {
 
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return nil, err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
 		return nil, err
 	}
@@ -492,7 +845,7 @@ func (svc *service) FilesList(rq *contract.BaseRequest) ([]contract.FileInfo, er
 }
 
 //CurrentRepository returns current repository name
-func (svc *service) CurrentRepository() (name string) {
+func (svc *service) CurrentRepository(ctx context.Context) (name string) {
 	if svc.git == nil {
 		return ""
 	}
@@ -500,57 +853,113 @@ func (svc *service) CurrentRepository() (name string) {
 	return svc.git.name
 }
 
-//CreateRepository - creates a new repository
-func (svc *service) CreateRepository(user, repo string) error {
+//CreateRepository - creates a new repository, opts can be nil
+func (svc *service) CreateRepository(ctx context.Context, user, repo string, opts *contract.RepoOptions) error {
 
 	if repo == "" {
-		return errors.New("Repository name cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository name cannot be empty")
+	}
+
+	err := validateRepoName(repo)
+	if err != nil {
+		return err
+	}
+
+	backend := svc.settings.FsType
+	if opts != nil && opts.Backend != contract.FsTypeInvalid {
+		backend = opts.Backend
+	}
+
+	err = svc.SwitchUser(ctx, &contract.User{Name: user})
+	if err != nil {
+		return err
+	}
+
+	//name is taken in any backend, otherwise catalog entry would be replaced and storage of existing repository lost
+	exists, err := svc.repoExists(ctx, user, repo)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return contract.NewError(contract.ErrorCodeConflict, "Repository %s already exists", repo)
+	}
+
+	err = svc.checkTrash(user, repo)
+	if err != nil {
+		return err
 	}
 
-	err := svc.SwitchUser(&contract.User{Name: user})
+	err = svc.checkQuota(ctx, user, repo, 0, true)
 	if err != nil {
 		return err
 	}
 
-	fs, gitFs, err := svc.createFs(user, repo)
+	fs, gitFs, err := svc.newBackendFs(backend, user, repo)
 	if err != nil {
 		return err
 	}
 
 	st := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
 	r, err := git.Init(st, gitFs)
+	if err != nil {
+		return toError(err)
+	}
+
+	svc.git = &repository{name: repo, fs: gitFs, repo: r, backend: backend}
+
+	info := &contract.Repository{Owner: user, Name: repo, Backend: backend, CreatedAt: time.Now(), DefaultBranch: "master"}
+
+	if opts != nil {
+		info.Description = opts.Description
 
-	svc.git = &repository{name: repo, fs: gitFs, repo: r}
+		if opts.DefaultBranch != "" {
+			info.DefaultBranch = opts.DefaultBranch
 
+			err = st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(opts.DefaultBranch)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = svc.addRepo(info)
 	if err != nil {
 		return err
 	}
 
+	svc.publish(contract.EventRepoCreated, user, repo, "", "", nil)
+
 	return nil
 }
 
 func (svc *service) tablesNames(user, repoName string) (filesTableName, gitTableName string, err error) {
 	if svc.user == nil {
-		return "", "", errors.New("user cannot be empty")
+		return "", "", contract.NewError(contract.ErrorCodeValidation, "user cannot be empty")
 	}
 
 	if user == "" {
-		return "", "", errors.New("userName cannot be empty")
+		return "", "", contract.NewError(contract.ErrorCodeValidation, "userName cannot be empty")
 	}
 
 	filesTableName = filesPrefix + user + "_" + repoName
 	gitTableName = gitPrefix + user + "_" + repoName
 
+	if !tableIdentifier.MatchString(gitTableName) {
+		return "", "", contract.NewError(contract.ErrorCodeValidation, "Names of user and repository can contain latin letters, digits and underscores only, use shared MySQL layout for other names")
+	}
+
 	return filesTableName, gitTableName, nil
 }
 
 func (svc *service) gitPath(user, repoName string) (gitPath, wtPath string, err error) {
 	if svc.user == nil {
-		return "", "", errors.New("user cannot be empty")
+		return "", "", contract.NewError(contract.ErrorCodeValidation, "user cannot be empty")
 	}
 
 	if user == "" {
-		return "", "", errors.New("userName cannot be empty")
+		return "", "", contract.NewError(contract.ErrorCodeValidation, "userName cannot be empty")
 	}
 
 	wtPath = filepath.Join(svc.settings.GitRoot, user, repoName)
@@ -560,42 +969,110 @@ func (svc *service) gitPath(user, repoName string) (gitPath, wtPath string, err
 }
 
 //OpenRepository - opens an existing repository
-func (svc *service) OpenRepository(user, repo string) error {
+func (svc *service) OpenRepository(ctx context.Context, user, repo string) error {
 
 	if repo == "" {
-		return errors.New("Repository name cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository name cannot be empty")
 	}
 
-	err := svc.SwitchUser(&contract.User{Name: user})
+	err := svc.SwitchUser(ctx, &contract.User{Name: user})
 	if err != nil {
 		return err
 	}
 
-	if svc.git != nil && svc.git.name == repo {
-		return nil
+	opened, err := svc.repoOpened(user, repo)
+	if err != nil || opened {
+		return err
 	}
 
-	fs, gitFs, err := svc.createFs(user, repo)
+	r, err := svc.openRepo(user, repo)
 	if err != nil {
 		return err
 	}
 
+	svc.git = r
+
+	return nil
+}
+
+func (svc *service) openRepo(user, repo string) (*repository, error) {
+	backend, err := svc.repoBackend(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, gitFs, err := svc.backendFs(backend, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
 	st := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
 
 	r, err := git.Open(st, gitFs)
 	if err != nil {
-		return err
+		return nil, toError(err)
+	}
+
+	err = svc.registerRepo(user, repo, backend, r)
+	if err != nil {
+		return nil, err
 	}
 
-	svc.git = &repository{name: repo, fs: gitFs, repo: r}
+	return &repository{name: repo, fs: gitFs, repo: r, backend: backend}, nil
+}
 
-	return nil
+//repoOpened - checks that repository of current user is opened from backend recorded in catalog,
+//catalog is shared with other processes, e.g. migrate command
+func (svc *service) repoOpened(user, repo string) (bool, error) {
+	if svc.git == nil || svc.git.name != repo {
+		return false, nil
+	}
+
+	backend, err := svc.repoBackend(user, repo)
+	if err != nil {
+		return false, err
+	}
+
+	return backend == svc.git.backend, nil
 }
 
+//createFs - returns filesystems of repository in backend recorded in catalog
 func (svc *service) createFs(user, repo string) (fs billy.Filesystem, gitFs billy.Filesystem, err error) {
-	switch svc.settings.FsType {
+	t, err := svc.repoBackend(user, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return svc.backendFs(t, user, repo)
+}
+
+//backendFs - returns filesystems of repository in specified backend, it doesn't depend on backend chosen in settings
+func (svc *service) backendFs(t contract.FsType, user, repo string) (fs billy.Filesystem, gitFs billy.Filesystem, err error) {
+	return svc.storageFs(t, user, repo, false)
+}
+
+//newBackendFs - returns filesystems for repository which is about to be created, shared MySQL layout registers it
+func (svc *service) newBackendFs(t contract.FsType, user, repo string) (fs billy.Filesystem, gitFs billy.Filesystem, err error) {
+	return svc.storageFs(t, user, repo, true)
+}
+
+func (svc *service) storageFs(t contract.FsType, user, repo string, create bool) (fs billy.Filesystem, gitFs billy.Filesystem, err error) {
+	err = validateStorageNames(user, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch t {
 	case contract.FsTypeMySQL:
 		{
+			if svc.db == nil {
+				return nil, nil, contract.NewError(contract.ErrorCodeUnavailable, "MySQL isn't configured")
+			}
+
+			if svc.sharedLayout() {
+				return svc.sharedFs(user, repo, create)
+			}
+
 			filesTableName, gitTableName, err := svc.tablesNames(user, repo)
 			if err != nil {
 				return nil, nil, err
@@ -611,10 +1088,47 @@ func (svc *service) createFs(user, repo string) (fs billy.Filesystem, gitFs bill
 				return nil, nil, err
 			}
 
+			return fs, gitFs, nil
+		}
+	case contract.FsTypePostgres:
+		{
+			if svc.pg == nil {
+				return nil, nil, contract.NewError(contract.ErrorCodeUnavailable, "Postgres isn't configured")
+			}
+
+			return svc.tableFs(svc.pg, user, repo)
+		}
+	case contract.FsTypeSQLite:
+		{
+			if svc.sqlite == nil {
+				return nil, nil, contract.NewError(contract.ErrorCodeUnavailable, "SQLite isn't configured")
+			}
+
+			return svc.tableFs(svc.sqlite, user, repo)
+		}
+	case contract.FsTypeMemory:
+		{
+			fs, gitFs = svc.memory.fs(user, repo)
+
+			return fs, gitFs, nil
+		}
+	case contract.FsTypeS3:
+		{
+			if svc.objects == nil {
+				return nil, nil, contract.NewError(contract.ErrorCodeUnavailable, "S3 isn't configured")
+			}
+
+			fs = objfs.New(svc.objects, path.Join(user, repo, "git"))
+			gitFs = objfs.New(svc.objects, path.Join(user, repo, "files"))
+
 			return fs, gitFs, nil
 		}
 	case contract.FsTypeLocal:
 		{
+			if svc.settings.GitRoot == "" {
+				return nil, nil, contract.NewError(contract.ErrorCodeUnavailable, "Git root isn't configured")
+			}
+
 			filesPath, wtPath, err := svc.gitPath(user, repo)
 			if err != nil {
 				return nil, nil, err
@@ -627,70 +1141,351 @@ func (svc *service) createFs(user, repo string) (fs billy.Filesystem, gitFs bill
 		}
 	default:
 		{
-			return nil, nil, fmt.Errorf("Wrong fsType = %d", svc.settings.FsType)
+			return nil, nil, fmt.Errorf("Wrong fsType = %d", t)
 		}
 	}
 }
 
-// Clone the given repository to the given directory
-func (svc *service) Clone(user, url string, auth *contract.Credentials) (string, error) {
+// Clone the given repository to the given directory, opts can be nil to clone everything
+func (svc *service) Clone(ctx context.Context, user, url string, auth *contract.Credentials, opts *contract.CloneOptions) (string, error) {
+	return svc.clone(ctx, user, url, auth, opts, nil)
+}
+
+//clone - clones repository until ctx is done, report receives progress of remote server and can be nil
+func (svc *service) clone(ctx context.Context, user, url string, auth *contract.Credentials, cloneOpts *contract.CloneOptions, report func(contract.JobProgress)) (string, error) {
 
 	if url == "" {
-		return "", errors.New("URL cannot be empty")
+		return "", contract.NewError(contract.ErrorCodeValidation, "URL cannot be empty")
 	}
 
-	splitted := strings.Split(url, "/")
-	last := splitted[len(splitted)-1]
-	repoName := strings.TrimSuffix(last, ".git")
+	if cloneOpts == nil {
+		cloneOpts = &contract.CloneOptions{}
+	}
+
+	if cloneOpts.Depth < 0 {
+		return "", contract.NewError(contract.ErrorCodeValidation, "Depth cannot be negative")
+	}
 
+	if cloneOpts.Branch != "" && cloneOpts.Tag != "" {
+		return "", contract.NewError(contract.ErrorCodeValidation, "Only one of branch and tag can be checked out")
+	}
+
+	urlName, err := repoNameFromURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	repoName := cloneOpts.Name
 	if repoName == "" {
-		return "", errors.New("wrong URL for clone operation")
+		repoName = urlName
+	}
+
+	err = validateRepoName(repoName)
+	if err != nil {
+		return "", err
+	}
+
+	//clone of the same name which is running already takes it
+	svc.waitIdle(user, repoName)
+
+	err = svc.SwitchUser(ctx, &contract.User{Name: user})
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.checkTrash(user, repoName)
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.checkQuota(ctx, user, repoName, 0, true)
+	if err != nil {
+		return "", err
+	}
+
+	exists, err := svc.repoExists(ctx, user, repoName)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return "", cloneConflict(repoName)
 	}
 
-	err := svc.SwitchUser(&contract.User{Name: user})
+	//cloned repositories live in backend chosen in settings
+	fs, gitFs, err := svc.newBackendFs(svc.settings.FsType, user, repoName)
 	if err != nil {
 		return "", err
 	}
 
-	fs, gitFs, err := svc.createFs(user, repoName)
+	//storage which isn't in catalog can still keep files, clone fails on it and mustn't remove them
+	empty, err := storageEmpty(fs, gitFs)
 	if err != nil {
 		return "", err
 	}
 
+	if !empty {
+		return "", cloneConflict(repoName)
+	}
+
 	st := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
 
 	opts := &git.CloneOptions{
 		URL:               url,
+		RemoteName:        cloneOpts.Remote,
+		Depth:             cloneOpts.Depth,
+		SingleBranch:      cloneOpts.SingleBranch,
 		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Progress:          svc.newProgress(user, repoName, "clone", report),
 	}
 
-	if auth != nil {
-		opts.Auth = &http.BasicAuth{
-			Username: auth.Name,
-			Password: auth.Password,
+	if cloneOpts.NoSubmodules {
+		opts.RecurseSubmodules = git.NoRecurseSubmodules
+	}
+
+	switch {
+	case cloneOpts.Branch != "":
+		opts.ReferenceName = plumbing.NewBranchReferenceName(cloneOpts.Branch)
+	case cloneOpts.Tag != "":
+		opts.ReferenceName = plumbing.NewTagReferenceName(cloneOpts.Tag)
+	}
+
+	opts.Auth = svc.remoteAuth(url, auth)
+
+	var r *git.Repository
+
+	err = svc.transfer(user, repoName, func() error {
+		r, err = git.CloneContext(ctx, st, gitFs, opts)
+		return err
+	})
+
+	if err != nil {
+		//repository isn't in catalog yet
+		delErr := svc.deleteBackendRepo(svc.settings.FsType, user, repoName)
+		if delErr != nil {
+			log.Printf("Cannot remove repo: %s, user: %s, error: %v\n", repoName, user, delErr)
 		}
+
+		return "", toError(err)
 	}
 
-	r, err := git.Clone(st, gitFs, opts)
+	//other operations could switch user while repository was cloned
+	err = svc.SwitchUser(ctx, &contract.User{Name: user})
+	if err != nil {
+		return "", err
+	}
+
+	svc.git = &repository{name: repoName, fs: gitFs, repo: r, backend: svc.settings.FsType}
+
+	err = svc.addRepo(&contract.Repository{Owner: user, Name: repoName, Backend: svc.settings.FsType, CreatedAt: time.Now(), DefaultBranch: headBranch(r)})
+	if err != nil {
+		return "", err
+	}
 
+	//size of repository is known only when it's cloned, repository which doesn't fit is removed
+	err = svc.checkQuota(ctx, user, repoName, 0, false)
 	if err != nil {
+		svc.git = nil
+
 		delErr := svc.deleteRepo(user, repoName)
 		if delErr != nil {
-			log.Printf("Cannot remove repo: %s, user: %s, error: %v\n", repoName, user, err)
+			log.Printf("Cannot remove repo: %s, user: %s, error: %v\n", repoName, user, delErr)
 		}
 
 		return "", err
 	}
 
-	svc.git = &repository{name: repoName, fs: gitFs, repo: r}
+	svc.publishHead(ctx, contract.EventRepoCloned, user, repoName, map[string]string{"url": url})
 
 	return repoName, nil
 }
 
+//cloneProtocols - transports supported by go-git and repositories of the server
+var cloneProtocols = map[string]bool{"http": true, "https": true, "ssh": true, "git": true, "file": true, internalScheme: true}
+
+//repoNameFromURL - returns the last element of path of http(s), ssh, git and file URLs,
+//scp-like git@host:path and local paths without .git suffix
+func repoNameFromURL(url string) (string, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return "", contract.NewError(contract.ErrorCodeValidation, "Wrong URL %s: %v", url, err)
+	}
+
+	if !cloneProtocols[ep.Protocol] {
+		return "", contract.NewError(contract.ErrorCodeValidation, "Protocol %s isn't supported", ep.Protocol)
+	}
+
+	p := ep.Path
+
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+
+	p = strings.TrimRight(strings.Replace(p, "\\", "/", -1), "/")
+	p = strings.TrimSuffix(p, "/.git")
+
+	name := strings.TrimSuffix(path.Base(p), ".git")
+
+	if name == "" || name == "." || name == "/" {
+		return "", contract.NewError(contract.ErrorCodeValidation, "Name of repository cannot be taken from URL %s, set it explicitly", url)
+	}
+
+	return name, nil
+}
+
+func cloneConflict(repo string) error {
+	err := contract.NewError(contract.ErrorCodeConflict, "Repository %s already exists, set another name for clone", repo)
+	err.Details = map[string]string{"repo": repo}
+
+	return err
+}
+
+//storageEmpty - local worktree keeps .git inside, both are empty for a new repository
+func storageEmpty(filesystems ...billy.Filesystem) (bool, error) {
+	for _, fs := range filesystems {
+		infos, err := fs.ReadDir("")
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return false, err
+		}
+
+		if len(infos) > 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+//validateUserName - name of user becomes directory of S3 and local backends, metadata of users is kept next to them
+func validateUserName(name string) error {
+	if name == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if name == metaRoot || validateRepoName(name) != nil {
+		return contract.NewError(contract.ErrorCodeValidation, "Wrong user name %s", name)
+	}
+
+	return nil
+}
+
+//validateStorageNames - every path to storage checks names, so wrong name can't point to storage of others
+func validateStorageNames(user, repo string) error {
+	err := validateUserName(user)
+	if err != nil {
+		return err
+	}
+
+	return validateRepoName(repo)
+}
+
+//validateRepoName - name of repository becomes part of path and table names, so it can't leave them
+func validateRepoName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\:`) {
+		return contract.NewError(contract.ErrorCodeValidation, "Wrong repository name %s", name)
+	}
+
+	return nil
+}
+
+//deleteRepo - removes repository which is in catalog, storage of names which aren't there is never touched
 func (svc *service) deleteRepo(user, repo string) error {
-	switch svc.settings.FsType {
+	r, err := svc.repos.get(context.Background(), user, repo)
+	if err != nil {
+		return err
+	}
+
+	if r == nil {
+		//repositories created before catalog appeared are added to it when they're opened
+		_, err = svc.openRepo(user, repo)
+		if err != nil {
+			return err
+		}
+
+		r, err = svc.repos.get(context.Background(), user, repo)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r == nil || !r.DeletedAt.IsZero() {
+		return contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	err = svc.deleteBackendRepo(r.Backend, user, repo)
+	if err != nil {
+		return err
+	}
+
+	return svc.removeRepo(user, repo)
+}
+
+//tableFs - returns filesystems of repository kept in tables of Postgres or SQLite
+func (svc *service) tableFs(db *sqlx.DB, user, repo string) (fs billy.Filesystem, gitFs billy.Filesystem, err error) {
+	filesTableName, gitTableName, err := svc.tablesNames(user, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fs, err = sqlfs.New(db, gitTableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gitFs, err = sqlfs.New(db, filesTableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fs, gitFs, nil
+}
+
+//objectCacheSize - memory for hot git objects of S3 backend, in bytes
+const objectCacheSize = 64 << 20
+
+//newObjectStore - endpoint file:///path keeps objects in local directory, it's used in tests and on single node
+func newObjectStore(s *contract.S3Settings) (objfs.Store, error) {
+	if strings.HasPrefix(s.Endpoint, "file://") {
+		return objfs.NewDirStore(filepath.Join(strings.TrimPrefix(s.Endpoint, "file://"), s.Bucket))
+	}
+
+	return objfs.NewS3Store(s.Endpoint, s.AccessKey, s.SecretKey, s.Bucket, s.Secure)
+}
+
+//dropTables - removes tables of repository, SQLite can't drop several tables by one statement
+func (svc *service) dropTables(db *sqlx.DB, user, repo string) error {
+	filesTable, gitTable, err := svc.tablesNames(user, repo)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range []string{filesTable, gitTable} {
+		_, err = db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", t))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (svc *service) deleteBackendRepo(t contract.FsType, user, repo string) error {
+	err := validateStorageNames(user, repo)
+	if err != nil {
+		return err
+	}
+
+	switch t {
 	case contract.FsTypeMySQL:
 		{
+			if svc.sharedLayout() {
+				return svc.deleteSharedRepo(user, repo)
+			}
+
 			filesTable, gitTable, err := svc.tablesNames(user, repo)
 			if err != nil {
 				return err
@@ -708,6 +1503,29 @@ func (svc *service) deleteRepo(user, repo string) error {
 				return err
 			}
 		}
+	case contract.FsTypePostgres:
+		{
+			err := svc.dropTables(svc.pg, user, repo)
+			if err != nil {
+				return err
+			}
+		}
+	case contract.FsTypeSQLite:
+		{
+			err := svc.dropTables(svc.sqlite, user, repo)
+			if err != nil {
+				return err
+			}
+		}
+	case contract.FsTypeMemory:
+		svc.memory.delete(user, repo)
+	case contract.FsTypeS3:
+		{
+			err := objfs.RemoveAll(context.Background(), svc.objects, path.Join(user, repo))
+			if err != nil {
+				return err
+			}
+		}
 	case contract.FsTypeLocal:
 		{
 			p := filepath.Join(svc.settings.GitRoot, user, repo)
@@ -719,7 +1537,7 @@ func (svc *service) deleteRepo(user, repo string) error {
 		}
 	default:
 		{
-			return fmt.Errorf("[Delete Repo] Wrong fsType = %d", svc.settings.FsType)
+			return fmt.Errorf("[Delete Repo] Wrong fsType = %d", t)
 		}
 	}
 
@@ -747,73 +1565,30 @@ func removeFiles(dir string) error {
 	return nil
 }
 
-// Repositories - returns all locally existing repositories
-func (svc *service) Repositories(user string) ([]string, error) {
-	if user == "" {
-		return nil, errors.New("user cannot be empty")
+//RemoveRepository - moves repository to trash, it's removed permanently if retention isn't set
+func (svc *service) RemoveRepository(ctx context.Context, user, repo string) error {
+	err := validateRepoName(repo)
+	if err != nil {
+		return err
 	}
 
-	switch svc.settings.FsType {
-	case contract.FsTypeMySQL:
-		{
-
-			tables := []string{}
-			svc.db.Select(&tables, "SELECT table_name FROM information_schema.tables ORDER BY table_name ASC")
-
-			repos := []string{}
-
-			for _, t := range tables {
-				if strings.HasPrefix(t, gitPrefix) {
-					temp := strings.TrimPrefix(t, gitPrefix)
-					userPrefix := user + "_"
-
-					if strings.HasPrefix(temp, userPrefix) {
-						repos = append(repos, strings.TrimPrefix(temp, userPrefix))
-					}
-				}
-			}
-
-			return repos, nil
-		}
-	case contract.FsTypeLocal:
-		{
-			gitPath := filepath.Join(svc.settings.GitRoot, user)
-			files, err := ioutil.ReadDir(gitPath)
-
-			if err != nil {
-				if os.IsNotExist(err) {
-					return []string{}, nil
-				}
-
-				return nil, err
-			}
-
-			res := []string{}
-
-			for _, f := range files {
-				if f.IsDir() {
-					res = append(res, f.Name())
-				}
-			}
-
-			return res, nil
-		}
-	default:
-		{
-			return nil, fmt.Errorf("Wrong fsType = %d", svc.settings.FsType)
-		}
+	err = svc.SwitchUser(ctx, &contract.User{Name: user})
+	if err != nil {
+		return err
 	}
 
-}
-
-//RemoveRepository - removes specified repository permanently
-func (svc *service) RemoveRepository(user, repo string) error {
-	err := svc.SwitchUser(&contract.User{Name: user})
+	//load subscriptions before metadata is gone to deliver repo.removed event
+	_, err = svc.webhooks.subscriptions(user, repo)
 	if err != nil {
-		return err
+		log.Printf("Cannot load webhooks, repo: %s, user: %s, error: %v\n", repo, user, err)
+	}
+
+	if svc.settings.TrashRetention > 0 {
+		err = svc.trashRepo(ctx, user, repo)
+	} else {
+		err = svc.deleteRepo(user, repo)
 	}
 
-	err = svc.deleteRepo(user, repo)
 	if err != nil {
 		return err
 	}
@@ -822,6 +1597,8 @@ func (svc *service) RemoveRepository(user, repo string) error {
 		svc.git = nil
 	}
 
+	svc.publish(contract.EventRepoRemoved, user, repo, "", "", nil)
+
 	return nil
 }
 
@@ -831,9 +1608,13 @@ func (svc *service) RemoveRepository(user, repo string) error {
 //
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
 // no changes to be fetched, or an error.
-func (svc *service) Fetch(user, repo, remote string, auth *contract.Credentials) error {
+func (svc *service) Fetch(ctx context.Context, user, repo, remote string, auth *contract.Credentials) error {
+	return svc.fetch(ctx, user, repo, remote, auth, nil)
+}
+
+func (svc *service) fetch(ctx context.Context, user, repo, remote string, auth *contract.Credentials, report func(contract.JobProgress)) error {
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return err
 	}
@@ -842,26 +1623,107 @@ func (svc *service) Fetch(user, repo, remote string, auth *contract.Credentials)
 		remote = "origin"
 	}
 
-	opts := &git.FetchOptions{RemoteName: remote}
+	opts := &git.FetchOptions{RemoteName: remote, Auth: svc.remoteAuth(remoteURL(svc.git.repo, remote), auth), Progress: svc.newProgress(user, repo, "fetch", report)}
 
-	if auth != nil {
-		opts.Auth = &http.BasicAuth{Username: auth.Name, Password: auth.Password}
+	r, err := svc.openRepo(user, repo)
+	if err != nil {
+		return err
+	}
+
+	err = svc.transfer(user, repo, func() error {
+		return r.repo.FetchContext(ctx, opts)
+	})
+
+	if err == nil {
+		svc.touchRepo(user, repo)
 	}
 
-	return svc.git.repo.Fetch(opts)
+	return toError(err)
 }
 
 // Pull incorporates changes from a remote repository into the current branch.
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
 // no changes to be fetched, or an error.
-func (svc *service) Pull(rq *contract.BaseRequest, remote string, auth *contract.Credentials) (string, error) {
+func (svc *service) Pull(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials) (string, error) {
+	return svc.pull(ctx, rq, remote, auth, nil)
+}
+
+func (svc *service) pull(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials, report func(contract.JobProgress)) (string, error) {
+
+	err := svc.validateBaseRQWithoutBranch(rq)
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.checkQuota(ctx, rq.User.Name, rq.Repository, 0, false)
+	if err != nil {
+		return "", err
+	}
+
+	if remote == "" {
+		remote = "origin"
+	}
+
+	opts := &git.PullOptions{RemoteName: remote, Auth: svc.remoteAuth(remoteURL(svc.git.repo, remote), auth)}
+
+	r, err := svc.openRepo(rq.User.Name, rq.Repository)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := saveFetchState(r.repo)
+	if err != nil {
+		return "", err
+	}
+
+	//objects are fetched in cancelable way first, so pull itself only updates the branch
+	fetchErr := svc.transfer(rq.User.Name, rq.Repository, func() error {
+		return r.repo.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: remote,
+			Auth:       opts.Auth,
+			Progress:   svc.newProgress(rq.User.Name, rq.Repository, "pull", report),
+		})
+	})
+
+	if fetchErr != nil && fetchErr != git.NoErrAlreadyUpToDate {
+		return "", toError(fetchErr)
+	}
+
+	//size of fetched objects is known only when they're stored, they're removed if they don't fit
+	if fetchErr == nil {
+		svc.touchRepo(rq.User.Name, rq.Repository)
+
+		err = svc.checkQuota(ctx, rq.User.Name, rq.Repository, 0, false)
+		if err != nil {
+			derr := discardFetch(r.repo, state)
+			if derr != nil {
+				log.Printf("Cannot remove fetched objects, repo: %s, user: %s, error: %v\n", rq.Repository, rq.User.Name, derr)
+			}
+
+			svc.touchRepo(rq.User.Name, rq.Repository)
+
+			return "", err
+		}
+	}
+
+	//repository is reopened after transfer, branch is checked out again if it was switched meanwhile
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
+	if err != nil {
+		return "", err
+	}
 
-	err := svc.validateBaseRQWithoutBranch(rq)
+	//branch isn't updated if its files don't fit into worktree
+	growth, err := pullGrowth(svc.git.repo, remote)
 	if err != nil {
 		return "", err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.checkQuota(ctx, rq.User.Name, rq.Repository, growth, false)
 	if err != nil {
 		return "", err
 	}
@@ -871,17 +1733,14 @@ func (svc *service) Pull(rq *contract.BaseRequest, remote string, auth *contract
 		return "", err
 	}
 
-	if remote == "" {
-		remote = "origin"
+	msg, err := w.Pull(opts)
+	if err != nil {
+		return msg, toError(err)
 	}
 
-	opts := &git.PullOptions{RemoteName: remote}
-
-	if auth != nil {
-		opts.Auth = &http.BasicAuth{Username: auth.Name, Password: auth.Password}
-	}
+	svc.publishHead(ctx, contract.EventPull, rq.User.Name, rq.Repository, map[string]string{"remote": remote})
 
-	return w.Pull(opts)
+	return msg, nil
 }
 
 // Push performs a push to the remote. Returns NoErrAlreadyUpToDate if
@@ -889,14 +1748,19 @@ func (svc *service) Pull(rq *contract.BaseRequest, remote string, auth *contract
 // FetchOptions.RemoteName.
 // If `remote` parameter is empty, use "origin" by default
 // Use credentials if needed. Remote also can be empty
-func (svc *service) Push(rq *contract.BaseRequest, remote string, auth *contract.Credentials) error {
+// If `force` is set, current branch is pushed with force
+func (svc *service) Push(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials, force bool) error {
+	return svc.push(ctx, rq, remote, auth, force, nil)
+}
+
+func (svc *service) push(ctx context.Context, rq *contract.BaseRequest, remote string, auth *contract.Credentials, force bool, report func(contract.JobProgress)) error {
 
 	err := svc.validateBaseRQWithoutBranch(rq)
 	if err != nil {
 		return err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
 		return err
 	}
@@ -905,28 +1769,109 @@ func (svc *service) Push(rq *contract.BaseRequest, remote string, auth *contract
 		remote = "origin"
 	}
 
-	opts := &git.PushOptions{RemoteName: remote}
+	op := branchOperationPush
+	if force {
+		op = branchOperationForcePush
+	}
+
+	err = svc.checkCurrentBranchProtection(ctx, rq.User.Name, rq.Repository, op)
+	if err != nil {
+		return err
+	}
+
+	opts := &git.PushOptions{RemoteName: remote, Progress: svc.newProgress(rq.User.Name, rq.Repository, "push", report)}
+
+	if force {
+		headRef, err := svc.git.repo.Head()
+		if err != nil {
+			return err
+		}
+
+		ref := headRef.Name().String()
+		opts.RefSpecs = []config.RefSpec{config.RefSpec("+" + ref + ":" + ref)}
+	}
 
 	if auth != nil {
 		opts.Auth = &http.BasicAuth{Username: auth.Name, Password: auth.Password}
 	}
 
-	return svc.git.repo.Push(opts)
+	r, err := svc.openRepo(rq.User.Name, rq.Repository)
+	if err != nil {
+		return err
+	}
+
+	err = svc.transfer(rq.User.Name, rq.Repository, func() error {
+		return r.repo.PushContext(ctx, opts)
+	})
+
+	if err != nil {
+		return toError(err)
+	}
+
+	err = svc.setSettings(ctx, rq.User, rq.Repository, "")
+	if err != nil {
+		return err
+	}
+
+	svc.publishHead(ctx, contract.EventPush, rq.User.Name, rq.Repository, map[string]string{"remote": remote, "force": strconv.FormatBool(force)})
+
+	return nil
 }
 
 //Commit - commits changes and returns commit hash
-func (svc *service) Commit(rq *contract.BaseRequest, msg string) (string, error) {
+func (svc *service) Commit(ctx context.Context, rq *contract.BaseRequest, msg string) (string, error) {
 
 	err := svc.validateBaseRQWithoutBranch(rq)
 	if err != nil {
 		return "", err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.checkCurrentBranchProtection(ctx, rq.User.Name, rq.Repository, branchOperationCommit)
+	if err != nil {
+		return "", err
+	}
+
+	wt, err := svc.git.repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return "", err
+	}
+
+	if !hasStaged(status) {
+		return "", contract.ErrNothingToCommit
+	}
+
+	err = svc.runCommitHooks(ctx, rq, msg)
+	if err != nil {
+		return "", err
+	}
+
+	h, err := svc.commit(msg)
 	if err != nil {
 		return "", err
 	}
 
+	svc.publishHead(ctx, contract.EventCommit, rq.User.Name, rq.Repository, map[string]string{"message": msg})
+
+	return h, nil
+}
+
+//commit - commits changes of current repository without checking branch protection
+func (svc *service) commit(msg string) (string, error) {
+	return svc.commitAs(msg, svc.user)
+}
+
+//commitAs - commits changes of current repository on behalf of author, who can differ from its owner
+func (svc *service) commitAs(msg string, author *contract.User) (string, error) {
 	wt, err := svc.git.repo.Worktree()
 	if err != nil {
 		return "", err
@@ -934,8 +1879,8 @@ func (svc *service) Commit(rq *contract.BaseRequest, msg string) (string, error)
 
 	h, err := wt.Commit(msg, &git.CommitOptions{
 		Author: &object.Signature{
-			Name:  svc.user.Name,
-			Email: svc.user.Email,
+			Name:  author.Name,
+			Email: author.Email,
 			When:  time.Now(),
 		},
 	})
@@ -947,10 +1892,21 @@ func (svc *service) Commit(rq *contract.BaseRequest, msg string) (string, error)
 	return h.String(), nil
 }
 
+//hasStaged - reports whether index differs from HEAD, untracked files don't count
+func hasStaged(status git.Status) bool {
+	for _, st := range status {
+		if st.Staging != git.Unmodified && st.Staging != git.Untracked {
+			return true
+		}
+	}
+
+	return false
+}
+
 //Merge - analog of git merge command
-func (svc *service) Merge(rq *contract.BaseRequest, branch string) (string, error) {
+func (svc *service) Merge(ctx context.Context, rq *contract.BaseRequest, branch string) (string, error) {
 	if branch == "" {
-		return "", errors.New("Branch name cannot be empty")
+		return "", contract.NewError(contract.ErrorCodeValidation, "Branch name cannot be empty")
 	}
 
 	err := svc.validateBaseRQ(rq)
@@ -958,11 +1914,33 @@ func (svc *service) Merge(rq *contract.BaseRequest, branch string) (string, erro
 		return "", err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.checkBranchProtection(ctx, rq.User.Name, rq.Repository, rq.Branch, branchOperationMerge)
+	if err != nil {
+		return "", err
+	}
+
+	err = svc.runPreMergeHooks(ctx, rq, branch)
 	if err != nil {
 		return "", err
 	}
 
+	msg, err := svc.merge(branch)
+	if err != nil {
+		return msg, err
+	}
+
+	svc.publishHead(ctx, contract.EventMerge, rq.User.Name, rq.Repository, map[string]string{"theirs": branch})
+
+	return msg, nil
+}
+
+//merge - merges branch into current one without checking branch protection
+func (svc *service) merge(branch string) (string, error) {
 	w, err := svc.git.repo.Worktree()
 	if err != nil {
 		return "", err
@@ -971,14 +1949,65 @@ func (svc *service) Merge(rq *contract.BaseRequest, branch string) (string, erro
 	return w.Merge(branch)
 }
 
+//Reset - resets current branch to specified commit, if hard is set worktree is reset too
+func (svc *service) Reset(ctx context.Context, rq *contract.BaseRequest, commit string, hard bool) error {
+	if commit == "" {
+		return contract.NewError(contract.ErrorCodeValidation, "Commit cannot be empty")
+	}
+
+	err := svc.validateBaseRQ(rq)
+	if err != nil {
+		return err
+	}
+
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
+	if err != nil {
+		return err
+	}
+
+	headRef, err := svc.git.repo.Head()
+	if err != nil {
+		return err
+	}
+
+	hash := plumbing.NewHash(commit)
+
+	//reset to HEAD only discards changes, any other commit moves the branch
+	if hash != headRef.Hash() {
+		err = svc.checkBranchProtection(ctx, rq.User.Name, rq.Repository, rq.Branch, branchOperationRewrite)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := svc.git.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	mode := git.MixedReset
+	if hard {
+		mode = git.HardReset
+	}
+
+	err = w.Reset(&git.ResetOptions{Commit: hash, Mode: mode})
+	if err != nil {
+		return toError(err)
+	}
+
+	svc.publishHead(ctx, contract.EventReset, rq.User.Name, rq.Repository, map[string]string{"hard": strconv.FormatBool(hard)})
+
+	return nil
+}
+
 //AbortMerge will abort the merge process and try to reconstruct the pre-merge state
-func (svc *service) AbortMerge(rq *contract.BaseRequest) error {
+func (svc *service) AbortMerge(ctx context.Context, rq *contract.BaseRequest) error {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
 		return err
 	}
@@ -991,14 +2020,160 @@ func (svc *service) AbortMerge(rq *contract.BaseRequest) error {
 	return w.AbortMerge()
 }
 
+//MergePreview - computes merge result in memory without touching the worktree or index
+func (svc *service) MergePreview(ctx context.Context, rq *contract.BaseRequest, theirs string) (*contract.MergePreview, error) {
+	if theirs == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Branch name cannot be empty")
+	}
+
+	err := svc.validateBaseRQ(rq)
+	if err != nil {
+		return nil, err
+	}
+
+	//branch isn't checked out, preview mustn't touch the worktree
+	err = svc.setSettings(ctx, rq.User, rq.Repository, "")
+	if err != nil {
+		return nil, err
+	}
+
+	headRef, err := svc.git.repo.Reference(plumbing.NewBranchReferenceName(rq.Branch), true)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	theirsRef, err := svc.git.repo.Reference(plumbing.NewBranchReferenceName(theirs), true)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	ours, err := svc.git.repo.CommitObject(headRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	their, err := svc.git.repo.CommitObject(theirsRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	res := &contract.MergePreview{Changes: []contract.FileChange{}, Conflicts: []string{}}
+
+	bases, err := ours.MergeBase(their)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bases) == 0 {
+		return nil, contract.NewError(contract.ErrorCodeConflict, "Refusing to merge unrelated histories")
+	}
+
+	base := bases[0]
+	res.Base = base.Hash.String()
+
+	if base.Hash == their.Hash {
+		res.IsUpToDate = true
+		return res, nil
+	}
+
+	theirChanges, err := diffCommits(base, their)
+	if err != nil {
+		return nil, err
+	}
+
+	for path, ch := range theirChanges {
+		res.Changes = append(res.Changes, contract.FileChange{Path: path, Action: ch.action})
+	}
+
+	sort.Slice(res.Changes, func(i, j int) bool { return res.Changes[i].Path < res.Changes[j].Path })
+
+	if base.Hash == ours.Hash {
+		res.IsFastForward = true
+		return res, nil
+	}
+
+	ourChanges, err := diffCommits(base, ours)
+	if err != nil {
+		return nil, err
+	}
+
+	for path, theirCh := range theirChanges {
+		ourCh, ok := ourChanges[path]
+		if !ok {
+			continue
+		}
+
+		//both sides changed the file in the same way, so it merges cleanly
+		if ourCh.action == theirCh.action && ourCh.hash == theirCh.hash {
+			continue
+		}
+
+		conflict, err := fileConflicts(base, ours, their, path, ourCh.action, theirCh.action)
+		if err != nil {
+			return nil, err
+		}
+
+		if conflict {
+			res.Conflicts = append(res.Conflicts, path)
+		}
+	}
+
+	sort.Strings(res.Conflicts)
+
+	return res, nil
+}
+
+type pathChange struct {
+	action contract.ChangeAction
+	hash   plumbing.Hash
+}
+
+//diffCommits - returns changed files between trees of two commits
+func diffCommits(from, to *object.Commit) (map[string]pathChange, error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]pathChange)
+
+	for _, ch := range changes {
+		action, err := ch.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			res[ch.To.Name] = pathChange{action: contract.ChangeActionAdded, hash: ch.To.TreeEntry.Hash}
+		case merkletrie.Delete:
+			res[ch.From.Name] = pathChange{action: contract.ChangeActionDeleted, hash: plumbing.ZeroHash}
+		case merkletrie.Modify:
+			res[ch.To.Name] = pathChange{action: contract.ChangeActionModified, hash: ch.To.TreeEntry.Hash}
+		}
+	}
+
+	return res, nil
+}
+
 //MergeMsgShort - returns MERGE_MSG file content  with trimming strings which begin from "#"
-func (svc *service) MergeMsgShort(rq *contract.BaseRequest) (string, error) {
+func (svc *service) MergeMsgShort(ctx context.Context, rq *contract.BaseRequest) (string, error) {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return "", err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
 		return "", err
 	}
@@ -1012,13 +2187,13 @@ func (svc *service) MergeMsgShort(rq *contract.BaseRequest) (string, error) {
 }
 
 //MergeMsgFull - returns MERGE_MSG file content  without trimming strings which begin from "#"
-func (svc *service) MergeMsgFull(rq *contract.BaseRequest) (string, error) {
+func (svc *service) MergeMsgFull(ctx context.Context, rq *contract.BaseRequest) (string, error) {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return "", err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
 		return "", err
 	}
@@ -1032,13 +2207,13 @@ func (svc *service) MergeMsgFull(rq *contract.BaseRequest) (string, error) {
 }
 
 //ConflictFileList - returns pathes of files with conflicts
-func (svc *service) ConflictFileList(rq *contract.BaseRequest) ([]string, error) {
+func (svc *service) ConflictFileList(ctx context.Context, rq *contract.BaseRequest) ([]string, error) {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return nil, err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
 		return nil, err
 	}
@@ -1062,13 +2237,13 @@ func (svc *service) ConflictFileList(rq *contract.BaseRequest) ([]string, error)
 }
 
 //ConflictFiles - returns base, ours or theirs files by path of conflict file
-func (svc *service) ConflictFiles(rq *contract.BaseRequest, path string) ([]contract.MergeFile, error) {
+func (svc *service) ConflictFiles(ctx context.Context, rq *contract.BaseRequest, path string) ([]contract.MergeFile, error) {
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return nil, err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
 		return nil, err
 	}
@@ -1121,16 +2296,16 @@ func (svc *service) toFileStage(st index.Stage) contract.FileStage {
 }
 
 //Checkout - switches branch to specified commit
-func (svc *service) Checkout(user, repo string, commit string) error {
+func (svc *service) Checkout(ctx context.Context, user, repo string, commit string) error {
 	if user == "" {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return err
 	}
@@ -1145,27 +2320,29 @@ func (svc *service) Checkout(user, repo string, commit string) error {
 	})
 
 	if err != nil {
-		return err
+		return toError(err)
 	}
 
+	svc.publish(contract.EventCheckout, user, repo, "", commit, nil)
+
 	return nil
 }
 
 //CheckoutBranch - switch to specified existing branch or creates new branch if it doesn't exist
-func (svc *service) CheckoutBranch(user, repo, branch string) error {
+func (svc *service) CheckoutBranch(ctx context.Context, user, repo, branch string) error {
 	if branch == "" {
-		return errors.New("Branch name cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Branch name cannot be empty")
 	}
 
 	if user == "" {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return err
 	}
@@ -1191,7 +2368,7 @@ func (svc *service) CheckoutBranch(user, repo, branch string) error {
 	}
 
 	if !hasBranch {
-		return svc.CreateBranch(user, repo, branch, "")
+		return svc.CreateBranch(ctx, user, repo, branch, "")
 	}
 
 	wt, err := svc.git.repo.Worktree()
@@ -1204,27 +2381,29 @@ func (svc *service) CheckoutBranch(user, repo, branch string) error {
 	})
 
 	if err != nil {
-		return err
+		return toError(err)
 	}
 
+	svc.publishHead(ctx, contract.EventCheckout, user, repo, nil)
+
 	return nil
 }
 
 //CreateBranch - creates a new branch from specified commit, if commit is empty new branch will be created from current commit
-func (svc *service) CreateBranch(user, repo, branch, commit string) error {
+func (svc *service) CreateBranch(ctx context.Context, user, repo, branch, commit string) error {
 	if branch == "" {
-		return errors.New("Branch name cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Branch name cannot be empty")
 	}
 
 	if user == "" {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return err
 	}
@@ -1255,38 +2434,52 @@ func (svc *service) CreateBranch(user, repo, branch, commit string) error {
 	})
 
 	if err != nil {
-		return err
+		return toError(err)
 	}
 
+	svc.publish(contract.EventBranchCreated, user, repo, branch, hash.String(), nil)
+
 	return nil
 }
 
 //RemoveBranch - removes specified branch
-func (svc *service) RemoveBranch(user, repo, branch string) error {
+func (svc *service) RemoveBranch(ctx context.Context, user, repo, branch string) error {
 	if branch == "" {
-		return errors.New("Branch name cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Branch name cannot be empty")
 	}
 
 	if user == "" {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
+	if err != nil {
+		return err
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err = svc.checkBranchProtection(ctx, user, repo, branch, branchOperationRemove)
 	if err != nil {
 		return err
 	}
 
 	ref := plumbing.NewBranchReferenceName(branch)
 
-	return svc.git.repo.Storer.RemoveReference(ref)
+	err = svc.git.repo.Storer.RemoveReference(ref)
+	if err != nil {
+		return toError(err)
+	}
+
+	svc.publish(contract.EventBranchRemoved, user, repo, branch, "", nil)
+
+	return nil
 }
 
 //CurrentBranch - returns information where HEAD points now
-func (svc *service) CurrentBranch() (*contract.Branch, error) {
+func (svc *service) CurrentBranch(ctx context.Context) (*contract.Branch, error) {
 	if svc.git == nil {
 		return nil, contract.ErrGitRepositoryNotSet
 	}
@@ -1304,17 +2497,17 @@ func (svc *service) CurrentBranch() (*contract.Branch, error) {
 }
 
 //Branches - returns a list of local branches names
-func (svc *service) Branches(user, repo string) ([]string, error) {
+func (svc *service) Branches(ctx context.Context, user, repo string) ([]string, error) {
 
 	if user == "" {
-		return nil, errors.New("User cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return nil, errors.New("Repository cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 
 	if err != nil {
 		return nil, err
@@ -1344,37 +2537,37 @@ func (svc *service) Branches(user, repo string) ([]string, error) {
 }
 
 //Add - adds the file content to the staging area
-func (svc *service) Add(rq *contract.BaseRequest, path string) error {
+func (svc *service) Add(ctx context.Context, rq *contract.BaseRequest, path string) error {
 
 	err := svc.validateBaseRQWithoutBranch(rq)
 	if err != nil {
 		return err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	wt, err := svc.git.repo.Worktree()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	return wt.Add(path)
 }
 
 //Log - Gets the HEAD history from HEAD, just like command "git log"
-func (svc *service) Log(rq *contract.BaseRequest) ([]contract.Commit, error) {
+func (svc *service) Log(ctx context.Context, rq *contract.BaseRequest) ([]contract.Commit, error) {
 
 	err := svc.validateBaseRQ(rq)
 	if err != nil {
 		return nil, err
 	}
 
-	err = svc.setSettings(rq.User, rq.Repository, rq.Branch)
+	err = svc.setSettings(ctx, rq.User, rq.Repository, rq.Branch)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
 	ref, err := svc.git.repo.Head()
@@ -1382,10 +2575,24 @@ func (svc *service) Log(rq *contract.BaseRequest) ([]contract.Commit, error) {
 		return nil, err
 	}
 
-	cIter, err := svc.git.repo.Log(&git.LogOptions{From: ref.Hash()})
+	head, err := svc.git.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	missing, err := shallowParents(svc.git.repo.Storer)
+	if err != nil {
+		return nil, err
+	}
+
+	cIter := object.NewCommitPreorderIter(head, nil, missing)
 	res := []contract.Commit{}
 
 	err = cIter.ForEach(func(c *object.Commit) error {
+		//history can be long, so walk stops as soon as request is gone
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		res = append(res, contract.Commit{Author: &contract.User{Name: c.Author.Name, Email: c.Author.Email}, Date: c.Author.When, Message: c.Message, Hash: c.Hash.String()})
 		return nil
@@ -1398,21 +2605,42 @@ func (svc *service) Log(rq *contract.BaseRequest) ([]contract.Commit, error) {
 	return res, nil
 }
 
+//shallowParents - history of shallow clone ends at shallow commits, their parents aren't fetched
+func shallowParents(st storage.Storer) ([]plumbing.Hash, error) {
+	shallows, err := st.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []plumbing.Hash{}
+
+	for _, h := range shallows {
+		c, err := object.GetCommit(st, h)
+		if err != nil {
+			continue
+		}
+
+		res = append(res, c.ParentHashes...)
+	}
+
+	return res, nil
+}
+
 //CreateRemote - creates a new remote, if name isn't specified it use "origin" by default
-func (svc *service) CreateRemote(user, repo, url, name string) (*git.Remote, error) {
+func (svc *service) CreateRemote(ctx context.Context, user, repo, url, name string) (*git.Remote, error) {
 	if url == "" {
-		return nil, errors.New("Remote url cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Remote url cannot be empty")
 	}
 
 	if user == "" {
-		return nil, errors.New("User cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return nil, errors.New("Repository cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return nil, err
 	}
@@ -1427,45 +2655,45 @@ func (svc *service) CreateRemote(user, repo, url, name string) (*git.Remote, err
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, toError(err)
 	}
 
 	return r, nil
 }
 
 //RemoveRemote - delete the remote and it's config from the repository
-func (svc *service) RemoveRemote(user, repo, name string) error {
+func (svc *service) RemoveRemote(ctx context.Context, user, repo, name string) error {
 	if name == "" {
-		return errors.New("Remote name cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Remote name cannot be empty")
 	}
 
 	if user == "" {
-		return errors.New("User cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return errors.New("Repository cannot be empty")
+		return contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return err
 	}
 
-	return svc.git.repo.DeleteRemote(name)
+	return toError(svc.git.repo.DeleteRemote(name))
 }
 
 //Remotes - returns a list with all remotes
-func (svc *service) Remotes(user, repo string) ([]*git.Remote, error) {
+func (svc *service) Remotes(ctx context.Context, user, repo string) ([]*git.Remote, error) {
 	if user == "" {
-		return nil, errors.New("User cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return nil, errors.New("Repository cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return nil, err
 	}
@@ -1479,27 +2707,27 @@ func (svc *service) Remotes(user, repo string) ([]*git.Remote, error) {
 }
 
 //Remote returns a remote if exists or git.ErrRemoteNotFound
-func (svc *service) Remote(user, repo, name string) (*git.Remote, error) {
+func (svc *service) Remote(ctx context.Context, user, repo, name string) (*git.Remote, error) {
 	if name == "" {
-		return nil, errors.New("Remote name cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Remote name cannot be empty")
 	}
 
 	if user == "" {
-		return nil, errors.New("User cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
 	}
 
 	if repo == "" {
-		return nil, errors.New("Repository cannot be empty")
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
 	}
 
-	err := svc.setSettings(&contract.User{Name: user}, repo, "")
+	err := svc.setSettings(ctx, &contract.User{Name: user}, repo, "")
 	if err != nil {
 		return nil, err
 	}
 
 	r, err := svc.git.repo.Remote(name)
 	if err != nil {
-		return nil, err
+		return nil, toError(err)
 	}
 
 	return r, nil
@@ -0,0 +1,167 @@
+package gitsvc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+)
+
+//trashPurgeInterval - how often repositories which are in trash longer than retention are removed
+const trashPurgeInterval = time.Hour
+
+//trashRepo - marks repository in catalog as removed, its storage stays untouched until retention passes
+func (svc *service) trashRepo(ctx context.Context, user, repo string) error {
+	ok, err := svc.repos.set(ctx, user, repo, false, catalogDeletedAt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		//repositories created before catalog appeared are added to it when they're opened
+		_, err = svc.openRepo(user, repo)
+		if err != nil {
+			return err
+		}
+
+		ok, err = svc.repos.set(ctx, user, repo, false, catalogDeletedAt, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if !ok {
+		return contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	svc.catalog.Lock()
+	defer svc.catalog.Unlock()
+
+	delete(svc.stale, user+"/"+repo)
+
+	return nil
+}
+
+//Trash - returns removed repositories of user which can still be restored
+func (svc *service) Trash(ctx context.Context, user string) ([]contract.Repository, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	repos, err := svc.repos.list(ctx, user, true)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.Repository{}
+
+	for _, r := range repos {
+		res = append(res, *r)
+	}
+
+	return res, nil
+}
+
+//restoreTrashed - returns repository from trash to catalog, repository in trash isn't counted by quota,
+//so it has to fit into quota again
+func (svc *service) restoreTrashed(ctx context.Context, user, repo string) error {
+	r, err := svc.repos.get(ctx, user, repo)
+	if err != nil {
+		return err
+	}
+
+	if r == nil || r.DeletedAt.IsZero() {
+		return contract.NewError(contract.ErrorCodeNotFound, "Repository %s isn't in trash", repo)
+	}
+
+	u, err := svc.repoUsage(r.Backend, user, repo)
+	if err != nil {
+		return err
+	}
+
+	err = svc.checkQuota(ctx, user, repo, u.ObjectBytes+u.WorktreeBytes, true)
+	if err != nil {
+		return err
+	}
+
+	ok, err := svc.repos.set(ctx, user, repo, true, catalogDeletedAt, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return contract.NewError(contract.ErrorCodeNotFound, "Repository %s isn't in trash", repo)
+	}
+
+	svc.touchRepo(user, repo)
+
+	return nil
+}
+
+//checkTrash - repository in trash keeps its storage, so a new one with the same name can't be created
+func (svc *service) checkTrash(user, repo string) error {
+	r, err := svc.repos.get(context.Background(), user, repo)
+	if err != nil {
+		return err
+	}
+
+	if r != nil && !r.DeletedAt.IsZero() {
+		return contract.NewError(contract.ErrorCodeConflict, "Repository %s is in trash, restore it or wait until it's purged", repo)
+	}
+
+	return nil
+}
+
+//purgeTrash - removes storage of repositories which are in trash longer than retention
+func (svc *service) purgeTrash(ctx context.Context) error {
+	users, err := svc.metaUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(-svc.settings.TrashRetention)
+
+	for _, user := range users {
+		trash, err := svc.repos.list(ctx, user, true)
+		if err != nil {
+			log.Printf("Cannot read trash of user: %s, error: %v\n", user, err)
+			continue
+		}
+
+		for _, r := range trash {
+			if r.DeletedAt.After(deadline) {
+				continue
+			}
+
+			err = svc.purgeRepo(user, r)
+			if err != nil {
+				log.Printf("Cannot purge repo: %s, user: %s, error: %v\n", r.Name, user, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+//purgeRepo - repository is forgotten only when its storage is removed, so failed removal is retried later.
+//It runs as any other operation of service, so storage isn't removed under running one
+func (svc *service) purgeRepo(user string, r *contract.Repository) error {
+	svc.ops.Lock()
+	defer svc.ops.Unlock()
+
+	//repository could be restored while it waited for the lock
+	e, err := svc.repos.get(context.Background(), user, r.Name)
+	if err != nil || e == nil || !e.DeletedAt.Equal(r.DeletedAt) {
+		return err
+	}
+
+	err = svc.deleteBackendRepo(r.Backend, user, r.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.repos.remove(context.Background(), user, r.Name, true)
+
+	return err
+}
@@ -0,0 +1,220 @@
+package gitsvc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/cache"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/filemode"
+	"bitbucket.org/vishjosh/bipp-go-git/plumbing/object"
+	"bitbucket.org/vishjosh/bipp-go-git/storage/filesystem"
+)
+
+//Verify - fsck: every object reachable from refs must exist and match its hash, every entry of index must point
+//to existing blob. Problems are reported, error is returned only when check can't be done
+func (svc *service) Verify(ctx context.Context, user, repo string) (*contract.VerifyReport, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	exists, err := svc.repoExists(ctx, user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, contract.NewError(contract.ErrorCodeNotFound, "Repository %s not found", repo)
+	}
+
+	fs, _, err := svc.createFs(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	//fresh storage, objects cached by opened repository could hide broken ones
+	st := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	report := &contract.VerifyReport{Missing: []string{}, Corrupted: []string{}, Dangling: []string{}, Index: []string{}}
+
+	refs, err := st.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	queue := []plumbing.Hash{}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			queue = append(queue, ref.Hash())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+
+	//parents of shallow commits aren't fetched, they aren't missing
+	parents, err := shallowParents(st)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range parents {
+		seen[h] = true
+	}
+
+	for len(queue) > 0 {
+		err = ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		h := queue[0]
+		queue = queue[1:]
+
+		if seen[h] {
+			continue
+		}
+
+		seen[h] = true
+		report.Objects++
+
+		o, err := st.EncodedObject(plumbing.AnyObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			report.Missing = append(report.Missing, h.String())
+			continue
+		}
+
+		//object is found, but can't be read
+		if err != nil {
+			report.Corrupted = append(report.Corrupted, h.String())
+			continue
+		}
+
+		if checkObject(o) != nil {
+			report.Corrupted = append(report.Corrupted, h.String())
+			continue
+		}
+
+		queue = append(queue, children(st, o, report)...)
+	}
+
+	verifyIndex(st, report)
+
+	staged := make(map[plumbing.Hash]bool)
+
+	idx, err := st.Index()
+	if err == nil {
+		for _, e := range idx.Entries {
+			staged[e.Hash] = true
+		}
+	}
+
+	objects, err := st.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return nil, err
+	}
+
+	//iteration stops at object which can't be read, such object is already reported if it's reachable
+	err = objects.ForEach(func(o plumbing.EncodedObject) error {
+		if !seen[o.Hash()] && !staged[o.Hash()] {
+			report.Dangling = append(report.Dangling, o.Hash().String())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("Cannot list all objects of repo: %s, user: %s, error: %v\n", repo, user, err)
+	}
+
+	return report, nil
+}
+
+//children - returns objects referenced by object, object which can't be decoded is reported as corrupted
+func children(st *filesystem.Storage, o plumbing.EncodedObject, report *contract.VerifyReport) []plumbing.Hash {
+	decoded, err := object.DecodeObject(st, o)
+	if err != nil {
+		report.Corrupted = append(report.Corrupted, o.Hash().String())
+		return nil
+	}
+
+	res := []plumbing.Hash{}
+
+	switch v := decoded.(type) {
+	case *object.Commit:
+		res = append(res, v.TreeHash)
+		res = append(res, v.ParentHashes...)
+	case *object.Tree:
+		for _, e := range v.Entries {
+			//submodule commit lives in another repository
+			if e.Mode != filemode.Submodule {
+				res = append(res, e.Hash)
+			}
+		}
+	case *object.Tag:
+		res = append(res, v.Target)
+	}
+
+	return res
+}
+
+//verifyIndex - missing index is fine for repository without worktree changes
+func verifyIndex(st *filesystem.Storage, report *contract.VerifyReport) {
+	idx, err := st.Index()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			report.Index = append(report.Index, fmt.Sprintf("Index cannot be read: %v", err))
+		}
+
+		return
+	}
+
+	entries := make(map[string]bool)
+
+	for _, e := range idx.Entries {
+		key := fmt.Sprintf("%s:%d", e.Name, e.Stage)
+
+		switch {
+		case entries[key]:
+			report.Index = append(report.Index, fmt.Sprintf("Entry %s is duplicated", e.Name))
+		case !validEntryName(e.Name):
+			report.Index = append(report.Index, fmt.Sprintf("Entry %s has wrong path", e.Name))
+		case e.Mode == filemode.Submodule || e.IntentToAdd:
+		default:
+			_, err = st.EncodedObject(plumbing.BlobObject, e.Hash)
+			if err != nil {
+				report.Index = append(report.Index, fmt.Sprintf("Blob %s of entry %s is missing", e.Hash, e.Name))
+			}
+		}
+
+		entries[key] = true
+	}
+}
+
+func validEntryName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return false
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." || part == ".git" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,420 @@
+package gitsvc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+)
+
+const webhooksMeta = "webhooks"
+
+const webhookDeliveriesMeta = "webhook-deliveries"
+
+//maxWebhookDeliveries - how many deliveries are kept in log per repository
+const maxWebhookDeliveries = 100
+
+//Headers of webhook request
+const (
+	WebhookEventHeader     = "X-Git-App-Event"
+	WebhookDeliveryHeader  = "X-Git-App-Delivery"
+	WebhookSignatureHeader = "X-Git-App-Signature-256"
+)
+
+//webhooks - webhooks of repository as they are kept in metadata
+type webhooks struct {
+	LastID int64
+	Items  []contract.Webhook
+}
+
+//webhookDeliveries - latest deliveries of repository webhooks as they are kept in metadata
+type webhookDeliveries struct {
+	Items []contract.WebhookDelivery
+}
+
+//webhookDispatcher - sends events to subscribed webhooks with retries,
+//subscriptions are cached, so repo.removed event is delivered after repository is gone
+type webhookDispatcher struct {
+	sync.Mutex
+	svc      *service
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	wg       sync.WaitGroup
+
+	cache map[string][]contract.Webhook
+	//lastDeliveryID - ids are derived from time, so they keep growing after restart
+	lastDeliveryID int64
+}
+
+func newWebhookDispatcher(svc *service) *webhookDispatcher {
+	return &webhookDispatcher{
+		svc:      svc,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		attempts: 3,
+		backoff:  time.Second,
+		cache:    make(map[string][]contract.Webhook),
+	}
+}
+
+//CreateWebhook - subscribes URL to repository events
+func (svc *service) CreateWebhook(ctx context.Context, user, repo string, hook *contract.Webhook) (*contract.Webhook, error) {
+	if hook == nil {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Webhook cannot be nil")
+	}
+
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Wrong webhook URL %s", hook.URL)
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	hooks, err := svc.loadWebhooks(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks.LastID++
+
+	res := contract.Webhook{ID: hooks.LastID, URL: hook.URL, Secret: hook.Secret, Events: hook.Events, Active: true}
+	hooks.Items = append(hooks.Items, res)
+
+	err = svc.writeMeta(user, repo, webhooksMeta, hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.webhooks.setCache(user, repo, hooks.Items)
+
+	return &res, nil
+}
+
+//Webhooks - returns webhooks of repository
+func (svc *service) Webhooks(ctx context.Context, user, repo string) ([]contract.Webhook, error) {
+	hooks, err := svc.loadWebhooks(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return hooks.Items, nil
+}
+
+//RemoveWebhook - removes webhook by id
+func (svc *service) RemoveWebhook(ctx context.Context, user, repo string, id int64) error {
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	hooks, err := svc.loadWebhooks(user, repo)
+	if err != nil {
+		return err
+	}
+
+	items := []contract.Webhook{}
+
+	for _, h := range hooks.Items {
+		if h.ID != id {
+			items = append(items, h)
+		}
+	}
+
+	if len(items) == len(hooks.Items) {
+		return contract.NewError(contract.ErrorCodeNotFound, "Webhook %d not found", id)
+	}
+
+	hooks.Items = items
+
+	err = svc.writeMeta(user, repo, webhooksMeta, hooks)
+	if err != nil {
+		return err
+	}
+
+	svc.webhooks.setCache(user, repo, hooks.Items)
+
+	return nil
+}
+
+//WebhookDeliveries - returns latest deliveries of webhook, the newest first
+func (svc *service) WebhookDeliveries(ctx context.Context, user, repo string, id int64) ([]contract.WebhookDelivery, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	deliveries := &webhookDeliveries{}
+
+	err := svc.readMeta(user, repo, webhookDeliveriesMeta, deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []contract.WebhookDelivery{}
+
+	for i := len(deliveries.Items) - 1; i >= 0; i-- {
+		if deliveries.Items[i].WebhookID == id {
+			res = append(res, deliveries.Items[i])
+		}
+	}
+
+	return res, nil
+}
+
+//addWebhookDelivery - appends delivery to log of repository, deliveries of removed repositories are dropped
+func (svc *service) addWebhookDelivery(ctx context.Context, user, repo string, delivery contract.WebhookDelivery) error {
+	svc.meta.Lock()
+	defer svc.meta.Unlock()
+
+	exists, err := svc.repoExists(ctx, user, repo)
+	if err != nil || !exists {
+		return err
+	}
+
+	deliveries := &webhookDeliveries{}
+
+	err = svc.readMeta(user, repo, webhookDeliveriesMeta, deliveries)
+	if err != nil {
+		return err
+	}
+
+	deliveries.Items = append(deliveries.Items, delivery)
+
+	if len(deliveries.Items) > maxWebhookDeliveries {
+		deliveries.Items = deliveries.Items[len(deliveries.Items)-maxWebhookDeliveries:]
+	}
+
+	return svc.writeMeta(user, repo, webhookDeliveriesMeta, deliveries)
+}
+
+func (svc *service) loadWebhooks(user, repo string) (*webhooks, error) {
+	if user == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "User cannot be empty")
+	}
+
+	if repo == "" {
+		return nil, contract.NewError(contract.ErrorCodeValidation, "Repository cannot be empty")
+	}
+
+	hooks := &webhooks{Items: []contract.Webhook{}}
+
+	err := svc.readMeta(user, repo, webhooksMeta, hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+func webhookKey(user, repo string) string {
+	return user + "/" + repo
+}
+
+func (d *webhookDispatcher) setCache(user, repo string, hooks []contract.Webhook) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.cache[webhookKey(user, repo)] = hooks
+}
+
+//subscriptions - returns cached webhooks of repository or loads them from metadata
+func (d *webhookDispatcher) subscriptions(user, repo string) ([]contract.Webhook, error) {
+	d.Lock()
+	hooks, ok := d.cache[webhookKey(user, repo)]
+	d.Unlock()
+
+	if ok {
+		return hooks, nil
+	}
+
+	loaded, err := d.svc.loadWebhooks(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	d.setCache(user, repo, loaded.Items)
+
+	return loaded.Items, nil
+}
+
+//handle - starts delivery of event to every subscribed webhook
+func (d *webhookDispatcher) handle(e contract.Event) {
+	hooks, err := d.subscriptions(e.User, e.Repo)
+	if err != nil {
+		log.Printf("Cannot load webhooks, repo: %s, user: %s, error: %v\n", e.Repo, e.User, err)
+		return
+	}
+
+	if e.Type == contract.EventRepoRemoved {
+		d.Lock()
+		delete(d.cache, webhookKey(e.User, e.Repo))
+		d.Unlock()
+	}
+
+	body, err := json.Marshal(ToEventRS(e))
+	if err != nil {
+		log.Printf("Cannot marshal event %s, error: %v\n", e.Type, err)
+		return
+	}
+
+	for _, h := range hooks {
+		if !h.Active || !subscribed(h, e.Type) {
+			continue
+		}
+
+		d.wg.Add(1)
+
+		go func(h contract.Webhook) {
+			defer d.wg.Done()
+
+			d.deliver(e, h, body)
+		}(h)
+	}
+}
+
+//deliver - posts event to webhook, retries network errors and 5xx/429 responses with exponential backoff
+func (d *webhookDispatcher) deliver(e contract.Event, h contract.Webhook, body []byte) {
+	delivery := contract.WebhookDelivery{ID: d.nextDeliveryID(), WebhookID: h.ID, Event: e.Type, URL: h.URL}
+
+	backoff := d.backoff
+
+	for delivery.Attempts < d.attempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		delivery.Attempts++
+
+		code, err := d.post(h, delivery.ID, e.Type, body)
+		delivery.StatusCode = code
+
+		if err != nil {
+			delivery.Error = err.Error()
+			continue
+		}
+
+		if code >= 200 && code < 300 {
+			delivery.Success = true
+			delivery.Error = ""
+			break
+		}
+
+		delivery.Error = http.StatusText(code)
+
+		if code < 500 && code != http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	delivery.DeliveredAt = time.Now()
+
+	if e.Type == contract.EventRepoRemoved {
+		return
+	}
+
+	err := d.svc.addWebhookDelivery(context.Background(), e.User, e.Repo, delivery)
+	if err != nil {
+		log.Printf("Cannot log webhook delivery, repo: %s, user: %s, error: %v\n", e.Repo, e.User, err)
+	}
+}
+
+func (d *webhookDispatcher) nextDeliveryID() int64 {
+	d.Lock()
+	defer d.Unlock()
+
+	id := time.Now().UnixNano()
+	if id <= d.lastDeliveryID {
+		id = d.lastDeliveryID + 1
+	}
+
+	d.lastDeliveryID = id
+
+	return id
+}
+
+func (d *webhookDispatcher) post(h contract.Webhook, deliveryID int64, t contract.EventType, body []byte) (int, error) {
+	rq, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set(WebhookEventHeader, string(t))
+	rq.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(deliveryID, 10))
+
+	if h.Secret != "" {
+		rq.Header.Set(WebhookSignatureHeader, "sha256="+Sign(h.Secret, body))
+	}
+
+	rs, err := d.client.Do(rq)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rs.Body.Close()
+	io.Copy(ioutil.Discard, rs.Body)
+
+	return rs.StatusCode, nil
+}
+
+//wait - waits until all started deliveries are finished
+func (d *webhookDispatcher) wait() {
+	d.wg.Wait()
+}
+
+//Sign - returns hex encoded HMAC-SHA256 of body, receivers compare it with X-Git-App-Signature-256 header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(h contract.Webhook, t contract.EventType) bool {
+	if len(h.Events) == 0 {
+		return t != contract.EventProgress
+	}
+
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+//ToEventRS - converts event to the form it's sent to webhooks and stream subscribers
+func ToEventRS(e contract.Event) *contract.EventRS {
+	return &contract.EventRS{
+		Type:   e.Type,
+		User:   e.User,
+		Repo:   e.Repo,
+		Branch: e.Branch,
+		Commit: e.Commit,
+		Time:   e.Time,
+		Data:   e.Data,
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 
 	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/config"
 	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/contract"
+	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/gitsvc"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -13,6 +17,15 @@ func main() {
 
 	logger := log.New(os.Stdout, "go-git-app:", log.LstdFlags)
 
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	settings, err := config.Parse()
 	if err != nil {
 		log.Fatal(err)
@@ -22,7 +35,8 @@ func main() {
 
 	var db *sqlx.DB
 
-	if settings.FsType == contract.FsTypeMySQL {
+	//repositories can live in MySQL even if it isn't chosen by FS_TYPE
+	if settings.GitConnStr != "" {
 		db, err = sqlx.Connect("mysql", settings.GitConnStr)
 
 		if err != nil {
@@ -42,3 +56,147 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+//runCommand - runs command which doesn't start the server, settings of server port aren't needed for it
+func runCommand(name string, args []string) error {
+	settings, err := config.ParseCLI()
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "migrate":
+		return migrate(settings, args)
+	case "verify":
+		return verify(settings, args)
+	case "migrate-layout":
+		return migrateLayout(settings)
+	default:
+		return fmt.Errorf("Unknown command %s, commands: migrate, verify, migrate-layout", name)
+	}
+}
+
+//migrate - moves repository to backend set by -to flag
+func migrate(settings *contract.ServerSettings, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	user := flags.String("user", "", "owner of repository")
+	repo := flags.String("repo", "", "repository name")
+	to := flags.Int("to", 0, "target backend: 1 - MySQL, 2 - local filesystem, 3 - Postgres, 4 - SQLite, 6 - S3")
+
+	flags.Parse(args)
+
+	target := contract.ToFsType(*to)
+
+	var db *sqlx.DB
+	var err error
+
+	if settings.GitConnStr != "" {
+		db, err = sqlx.Connect("mysql", settings.GitConnStr)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+	}
+
+	svc, err := gitsvc.New(settings, db)
+	if err != nil {
+		return err
+	}
+
+	defer svc.Close()
+
+	err = svc.Migrate(context.Background(), *user, *repo, target)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Repository %s of user %s was migrated\n", *repo, *user)
+
+	return nil
+}
+
+//verify - checks integrity of repository, problems are printed and make exit status non-zero
+func verify(settings *contract.ServerSettings, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	user := flags.String("user", "", "owner of repository")
+	repo := flags.String("repo", "", "repository name")
+
+	flags.Parse(args)
+
+	var db *sqlx.DB
+	var err error
+
+	if settings.GitConnStr != "" {
+		db, err = sqlx.Connect("mysql", settings.GitConnStr)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+	}
+
+	svc, err := gitsvc.New(settings, db)
+	if err != nil {
+		return err
+	}
+
+	defer svc.Close()
+
+	report, err := svc.Verify(context.Background(), *user, *repo)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Objects checked: %d, dangling: %d\n", report.Objects, len(report.Dangling))
+
+	for _, h := range report.Missing {
+		log.Printf("Missing object %s\n", h)
+	}
+
+	for _, h := range report.Corrupted {
+		log.Printf("Corrupted object %s\n", h)
+	}
+
+	for _, p := range report.Index {
+		log.Println(p)
+	}
+
+	if !report.OK() {
+		return fmt.Errorf("Repository %s of user %s is corrupted", *repo, *user)
+	}
+
+	log.Printf("Repository %s of user %s is consistent\n", *repo, *user)
+
+	return nil
+}
+
+//migrateLayout - moves repositories kept in MySQL to shared tables
+func migrateLayout(settings *contract.ServerSettings) error {
+	if settings.GitConnStr == "" {
+		return fmt.Errorf("GIT_DB_CONN_STRING isn't set")
+	}
+
+	db, err := sqlx.Connect("mysql", settings.GitConnStr)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	svc, err := gitsvc.New(settings, db)
+	if err != nil {
+		return err
+	}
+
+	defer svc.Close()
+
+	err = svc.MigrateLayout(context.Background())
+	if err != nil {
+		return err
+	}
+
+	log.Println("Repositories were moved to shared MySQL layout")
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package objfs
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+)
+
+//cachedStore - keeps hot git objects in memory. Objects and packs never change after they are written,
+//so entries are dropped only when they are replaced or removed by this process or pushed out by newer ones
+type cachedStore struct {
+	Store
+	sync.Mutex
+	max   int64
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type cacheItem struct {
+	key  string
+	data []byte
+	info ObjectInfo
+}
+
+//NewCache - wraps store with cache of objects and packs, max is size of cache in bytes
+func NewCache(s Store, max int64) Store {
+	return &cachedStore{Store: s, max: max, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+//cacheable - content-addressed files only, refs and index must be always read from store
+func cacheable(key string) bool {
+	return strings.Contains(key, "/objects/")
+}
+
+func (c *cachedStore) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	if !cacheable(key) {
+		return c.Store.Get(ctx, key)
+	}
+
+	c.Lock()
+	e, ok := c.items[key]
+	if ok {
+		c.lru.MoveToFront(e)
+		item := e.Value.(*cacheItem)
+		info := item.info
+		c.Unlock()
+
+		return append([]byte{}, item.data...), &info, nil
+	}
+	c.Unlock()
+
+	data, info, err := c.Store.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.add(&cacheItem{key: key, data: append([]byte{}, data...), info: *info})
+
+	return data, info, nil
+}
+
+func (c *cachedStore) add(item *cacheItem) {
+	if int64(len(item.data)) > c.max {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(item.key)
+
+	c.items[item.key] = c.lru.PushFront(item)
+	c.size += int64(len(item.data))
+
+	for c.size > c.max {
+		c.remove(c.lru.Back().Value.(*cacheItem).key)
+	}
+}
+
+//remove - must be called under lock
+func (c *cachedStore) remove(key string) {
+	e, ok := c.items[key]
+	if !ok {
+		return
+	}
+
+	c.lru.Remove(e)
+	delete(c.items, key)
+	c.size -= int64(len(e.Value.(*cacheItem).data))
+}
+
+func (c *cachedStore) forget(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(key)
+}
+
+func (c *cachedStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	c.forget(key)
+	return c.Store.Put(ctx, key, data)
+}
+
+func (c *cachedStore) PutIf(ctx context.Context, key string, data []byte, etag string) (string, error) {
+	c.forget(key)
+	return c.Store.PutIf(ctx, key, data, etag)
+}
+
+func (c *cachedStore) Delete(ctx context.Context, key string) error {
+	c.forget(key)
+	return c.Store.Delete(ctx, key)
+}
@@ -0,0 +1,227 @@
+package objfs
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//node - content of opened file, it's shared by all handles of the file, so written data can be read
+//before the file is closed (go-git reads packfile while it's being written)
+type node struct {
+	key       string
+	objectKey string
+	content   []byte
+	refs      int
+	dirty     bool
+	//etag - version of object the content is based on
+	etag string
+	//conditional - object is written only if it's still of the version which was read
+	conditional bool
+}
+
+//nodes - opened files of all filesystems, key is store and object key
+var nodes = struct {
+	sync.Mutex
+	m map[string]*node
+}{m: make(map[string]*node)}
+
+//file - content is kept in memory while file is opened and it's written to store on Close
+type file struct {
+	storage *storage
+	node    *node
+	name    string
+	flag    int
+	pos     int64
+	closed  bool
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+//Read - EOF comes with the next call like from os.File, go-git reads packfile while it's written
+//and takes EOF returned together with data as its end
+func (f *file) Read(b []byte) (int, error) {
+	n, err := f.ReadAt(b, f.pos)
+	f.pos += int64(n)
+
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+	}
+
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	if off >= int64(len(f.node.content)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, f.node.content[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) Write(b []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.node.content))
+	}
+
+	end := f.pos + int64(len(b))
+	if end > int64(len(f.node.content)) {
+		grown := make([]byte, end, end+end/2)
+		copy(grown, f.node.content)
+		f.node.content = grown
+	}
+
+	copy(f.node.content[f.pos:], b)
+	f.pos = end
+	f.node.dirty = true
+
+	return len(b), nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+		f.pos = offset
+	case io.SeekCurrent:
+		f.pos += offset
+	case io.SeekEnd:
+		nodes.Lock()
+		f.pos = int64(len(f.node.content)) + offset
+		nodes.Unlock()
+	}
+
+	return f.pos, nil
+}
+
+func (f *file) Truncate(size int64) error {
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	f.node.truncate(size)
+
+	return nil
+}
+
+func (n *node) truncate(size int64) {
+	if size < int64(len(n.content)) {
+		n.content = n.content[:size]
+	} else {
+		grown := make([]byte, size)
+		copy(grown, n.content)
+		n.content = grown
+	}
+
+	n.dirty = true
+}
+
+//Close - writes content of changed file to store
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+
+	nodes.Lock()
+
+	f.node.refs--
+	if f.node.refs == 0 {
+		delete(nodes.m, f.node.key)
+	}
+
+	dirty := f.node.dirty
+	content := append([]byte{}, f.node.content...)
+	objectKey, conditional, etag := f.node.objectKey, f.node.conditional, f.node.etag
+	f.node.dirty = false
+
+	nodes.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	tag, err := f.storage.write(objectKey, content, conditional, etag)
+	if err != nil {
+		return err
+	}
+
+	nodes.Lock()
+	f.node.etag = tag
+	nodes.Unlock()
+
+	return nil
+}
+
+//Lock - objects are replaced as a whole and conditionally, so there is nothing to lock
+func (f *file) Lock() error {
+	return nil
+}
+
+func (f *file) Unlock() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
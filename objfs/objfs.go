@@ -0,0 +1,413 @@
+package objfs
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy/helper/chroot"
+)
+
+//ErrDirNotEmpty - occurs on removal of directory with files
+var ErrDirNotEmpty = fmt.Errorf("directory not empty")
+
+type storage struct {
+	store  Store
+	prefix string
+}
+
+//New - returns filesystem which keeps files under prefix of store
+func New(store Store, prefix string) billy.Filesystem {
+	return chroot.New(&storage{store: store, prefix: strings.Trim(prefix, "/")}, "/")
+}
+
+//clean - returns path relative to root, root is empty string
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+//objectKey - returns key of object for path
+func (s *storage) objectKey(p string) string {
+	if p == "" {
+		return s.prefix
+	}
+
+	return s.prefix + "/" + p
+}
+
+//key - identifies file in nodes
+func (s *storage) key(p string) string {
+	return fmt.Sprintf("%p/%s", s.store, s.objectKey(p))
+}
+
+//stat - returns info of file or directory, nil if it doesn't exist
+func (s *storage) stat(p string) (*fileInfo, error) {
+	if p == "" {
+		return &fileInfo{name: "/", mode: os.ModeDir | 0755}, nil
+	}
+
+	nodes.Lock()
+	n, ok := nodes.m[s.key(p)]
+	if ok {
+		info := &fileInfo{name: path.Base(p), size: int64(len(n.content)), mode: 0666, modTime: time.Now()}
+		nodes.Unlock()
+
+		return info, nil
+	}
+	nodes.Unlock()
+
+	ctx := context.Background()
+
+	o, err := s.store.Stat(ctx, s.objectKey(p))
+	if err == nil {
+		return &fileInfo{name: path.Base(p), size: o.Size, mode: 0666, modTime: o.Modified}, nil
+	}
+
+	if err != ErrNotFound {
+		return nil, err
+	}
+
+	children, err := s.store.List(ctx, s.objectKey(p)+"/", false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(children) == 0 {
+		return nil, nil
+	}
+
+	return &fileInfo{name: path.Base(p), mode: os.ModeDir | 0755}, nil
+}
+
+func (s *storage) Create(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *storage) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+//OpenFile - file which is read before it's changed (refs, configs) is written on Close only if nobody else
+//changed it, so concurrent updates from different nodes don't overwrite each other
+func (s *storage) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p := clean(filename)
+	if p == "" {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: fmt.Errorf("is a directory")}
+	}
+
+	key := s.key(p)
+
+	nodes.Lock()
+	n, ok := nodes.m[key]
+	if ok {
+		defer nodes.Unlock()
+
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+		}
+
+		return s.handle(n, filename, flag), nil
+	}
+	nodes.Unlock()
+
+	ctx := context.Background()
+	n = &node{key: key, objectKey: s.objectKey(p)}
+
+	data, o, err := s.store.Get(ctx, n.objectKey)
+
+	switch {
+	case err == ErrNotFound:
+		{
+			info, err := s.stat(p)
+			if err != nil {
+				return nil, err
+			}
+
+			if info != nil {
+				return nil, &os.PathError{Op: "open", Path: filename, Err: fmt.Errorf("is a directory")}
+			}
+
+			if flag&os.O_CREATE == 0 {
+				return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+			}
+
+			//object is written on Close only, empty etag makes it fail there if somebody created it meanwhile
+			n.dirty = true
+			n.conditional = true
+		}
+	case err != nil:
+		return nil, err
+	default:
+		{
+			if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+				return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+			}
+
+			n.content = data
+			n.etag = o.ETag
+			n.conditional = flag&os.O_TRUNC == 0
+		}
+	}
+
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	//file was opened by another handle meanwhile, its content is shared
+	if opened, ok := nodes.m[key]; ok {
+		n = opened
+	} else {
+		nodes.m[key] = n
+	}
+
+	return s.handle(n, filename, flag), nil
+}
+
+//handle - must be called under nodes lock
+func (s *storage) handle(n *node, filename string, flag int) *file {
+	n.refs++
+
+	//truncated file is overwritten whatever it contained, exclusively created one is written only if it still doesn't exist
+	if flag&os.O_TRUNC != 0 {
+		n.truncate(0)
+		n.conditional = flag&os.O_EXCL != 0
+	}
+
+	f := &file{storage: s, node: n, name: filename, flag: flag}
+
+	if flag&os.O_APPEND != 0 {
+		f.pos = int64(len(n.content))
+	}
+
+	return f
+}
+
+//write - writes content of file to store
+func (s *storage) write(objectKey string, content []byte, conditional bool, etag string) (string, error) {
+	ctx := context.Background()
+
+	if !conditional {
+		return s.store.Put(ctx, objectKey, content)
+	}
+
+	tag, err := s.store.PutIf(ctx, objectKey, content, etag)
+	if err == ErrPrecondition {
+		return "", &os.PathError{Op: "write", Path: objectKey, Err: err}
+	}
+
+	return tag, err
+}
+
+func (s *storage) Stat(filename string) (os.FileInfo, error) {
+	info, err := s.stat(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: os.ErrNotExist}
+	}
+
+	info.name = path.Base(filename)
+
+	return info, nil
+}
+
+//Lstat - symlinks aren't supported, so it's the same as Stat
+func (s *storage) Lstat(filename string) (os.FileInfo, error) {
+	return s.Stat(filename)
+}
+
+//Rename - object store can't rename, so objects are copied and removed
+func (s *storage) Rename(oldpath, newpath string) error {
+	from, to := clean(oldpath), clean(newpath)
+
+	info, err := s.stat(from)
+	if err != nil {
+		return err
+	}
+
+	if info == nil || from == "" {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrNotExist}
+	}
+
+	ctx := context.Background()
+	moves := map[string]string{}
+
+	if info.IsDir() {
+		objects, err := s.store.List(ctx, s.objectKey(from)+"/", true)
+		if err != nil {
+			return err
+		}
+
+		for _, o := range objects {
+			moves[o.Key] = s.objectKey(to) + strings.TrimPrefix(o.Key, s.objectKey(from))
+		}
+	} else {
+		moves[s.objectKey(from)] = s.objectKey(to)
+	}
+
+	for src, dst := range moves {
+		data, _, err := s.store.Get(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.store.Put(ctx, dst, data)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.Delete(ctx, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	//opened file is written to its new path on close
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	if n, ok := nodes.m[s.key(from)]; ok {
+		delete(nodes.m, n.key)
+		n.key, n.objectKey, n.conditional = s.key(to), s.objectKey(to), false
+		nodes.m[n.key] = n
+	}
+
+	return nil
+}
+
+func (s *storage) Remove(filename string) error {
+	p := clean(filename)
+
+	info, err := s.stat(p)
+	if err != nil {
+		return err
+	}
+
+	if info == nil || p == "" {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrNotExist}
+	}
+
+	//directory exists only while there are files in it
+	if info.IsDir() {
+		return &os.PathError{Op: "remove", Path: filename, Err: ErrDirNotEmpty}
+	}
+
+	return s.store.Delete(context.Background(), s.objectKey(p))
+}
+
+func (s *storage) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (s *storage) TempFile(dir, prefix string) (billy.File, error) {
+	for i := 0; i < 100; i++ {
+		name := s.Join(dir, prefix+strconv.Itoa(rand.Int()))
+
+		f, err := s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+
+		return f, err
+	}
+
+	return nil, fmt.Errorf("Cannot create temporary file in %s", dir)
+}
+
+func (s *storage) ReadDir(dirname string) ([]os.FileInfo, error) {
+	p := clean(dirname)
+
+	prefix := s.prefix + "/"
+	if p != "" {
+		prefix = s.objectKey(p) + "/"
+	}
+
+	objects, err := s.store.List(context.Background(), prefix, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(objects) == 0 && p != "" {
+		info, err := s.stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if info == nil {
+			return nil, &os.PathError{Op: "readdir", Path: dirname, Err: os.ErrNotExist}
+		}
+
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: fmt.Errorf("not a directory")}
+	}
+
+	res := []os.FileInfo{}
+
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	for _, o := range objects {
+		info := &fileInfo{name: path.Base(o.Key), size: o.Size, mode: 0666, modTime: o.Modified}
+
+		if o.Dir {
+			info.mode = os.ModeDir | 0755
+		} else if n, ok := nodes.m[fmt.Sprintf("%p/%s", s.store, o.Key)]; ok {
+			info.size = int64(len(n.content))
+		}
+
+		res = append(res, info)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+
+	return res, nil
+}
+
+//MkdirAll - directories appear with the first file in them, so only conflict with file is checked
+func (s *storage) MkdirAll(filename string, perm os.FileMode) error {
+	p := clean(filename)
+
+	info, err := s.stat(p)
+	if err != nil {
+		return err
+	}
+
+	if info != nil && !info.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: filename, Err: fmt.Errorf("not a directory")}
+	}
+
+	return nil
+}
+
+func (s *storage) Symlink(target, link string) error {
+	return &os.PathError{Op: "symlink", Path: link, Err: billy.ErrNotSupported}
+}
+
+func (s *storage) Readlink(link string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: link, Err: billy.ErrNotSupported}
+}
+
+//RemoveAll - removes all objects under prefix, it's used for removal of repositories
+func RemoveAll(ctx context.Context, store Store, prefix string) error {
+	objects, err := store.List(ctx, strings.Trim(prefix, "/")+"/", true)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objects {
+		err = store.Delete(ctx, o.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
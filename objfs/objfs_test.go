@@ -0,0 +1,300 @@
+package objfs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+)
+
+func newTestStore(t *testing.T) Store {
+	dir, err := ioutil.TempDir("", "objfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func writeFile(t *testing.T, fs billy.Filesystem, name, data string) {
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte(data))
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, fs billy.Filesystem, name string) string {
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestPutIf(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	tag, err := s.PutIf(ctx, "refs/heads/master", []byte("1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.PutIf(ctx, "refs/heads/master", []byte("2"), "")
+	if err != ErrPrecondition {
+		t.Errorf("Existing object cannot be created. Must: %v, has: %v\n", ErrPrecondition, err)
+	}
+
+	_, err = s.PutIf(ctx, "refs/heads/master", []byte("2"), tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.PutIf(ctx, "refs/heads/master", []byte("3"), tag)
+	if err != ErrPrecondition {
+		t.Errorf("Object changed since it was read cannot be replaced. Must: %v, has: %v\n", ErrPrecondition, err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	fs := New(newTestStore(t), "user/repo")
+
+	writeFile(t, fs, "objects/pack/a.pack", "pack")
+	writeFile(t, fs, "HEAD", "ref: refs/heads/master")
+
+	if data := readFile(t, fs, "objects/pack/a.pack"); data != "pack" {
+		t.Errorf("Wrong content. Must: pack, has: %s\n", data)
+	}
+
+	infos, err := fs.ReadDir("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 2 || infos[0].Name() != "HEAD" || infos[1].Name() != "objects" || !infos[1].IsDir() {
+		t.Errorf("Wrong root entries: %v\n", infos)
+	}
+
+	err = fs.Rename("objects", "moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := readFile(t, fs, "moved/pack/a.pack"); data != "pack" {
+		t.Errorf("Wrong content of renamed file. Must: pack, has: %s\n", data)
+	}
+
+	_, err = fs.Stat("objects")
+	if !os.IsNotExist(err) {
+		t.Errorf("Renamed directory must not exist: %v\n", err)
+	}
+
+	err = fs.Remove("moved/pack/a.pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.ReadDir("moved")
+	if !os.IsNotExist(err) {
+		t.Errorf("Directory without files must not exist: %v\n", err)
+	}
+}
+
+//file which is still written returns EOF only when there is nothing to read, like os.File
+func TestReadWhileWritten(t *testing.T) {
+	fs := New(newTestStore(t), "user/repo")
+
+	w, err := fs.Create("pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer w.Close()
+
+	r, err := fs.Open("pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	w.Write([]byte("da"))
+
+	b := make([]byte, 4)
+
+	n, err := r.Read(b)
+	if n != 2 || err != nil {
+		t.Errorf("Written data must be read without error. Must: 2, <nil>, has: %d, %v\n", n, err)
+	}
+
+	n, err = r.Read(b)
+	if n != 0 || err != io.EOF {
+		t.Errorf("Wrong end of file. Must: 0, EOF, has: %d, %v\n", n, err)
+	}
+
+	w.Write([]byte("ta"))
+
+	n, err = r.Read(b)
+	if n != 2 || err != nil || string(b[:n]) != "ta" {
+		t.Errorf("Data written after EOF must be read. Must: ta, has: %s, %v\n", b[:n], err)
+	}
+}
+
+func TestConcurrentRefUpdate(t *testing.T) {
+	s := newTestStore(t)
+
+	writeFile(t, New(s, "user/repo"), "refs/heads/master", "1")
+
+	//two nodes read ref and update it, the second one must fail
+	f1, err := New(s, "user/repo").OpenFile("refs/heads/master", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.ReadAll(f1)
+
+	//another node changes ref meanwhile, the file isn't shared, so it's written to the store directly
+	_, err = s.Put(context.Background(), "user/repo/refs/heads/master", []byte("2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f1.Truncate(0)
+	f1.Seek(0, 0)
+	f1.Write([]byte("3"))
+
+	err = f1.Close()
+	if err == nil {
+		t.Error("Ref changed concurrently cannot be overwritten")
+	}
+
+	if data := readFile(t, New(s, "user/repo"), "refs/heads/master"); data != "2" {
+		t.Errorf("Wrong ref. Must: 2, has: %s\n", data)
+	}
+}
+
+//writesStore - counts writes to store
+type writesStore struct {
+	Store
+	writes int
+}
+
+func (s *writesStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	s.writes++
+	return s.Store.Put(ctx, key, data)
+}
+
+func (s *writesStore) PutIf(ctx context.Context, key string, data []byte, etag string) (string, error) {
+	s.writes++
+	return s.Store.PutIf(ctx, key, data, etag)
+}
+
+func TestCreate(t *testing.T) {
+	s := &writesStore{Store: newTestStore(t)}
+	fs := New(s, "user/repo")
+
+	writeFile(t, fs, "objects/pack/a.pack", "pack")
+
+	if s.writes != 1 {
+		t.Errorf("New file must be written once. Has: %d writes\n", s.writes)
+	}
+
+	f, err := fs.Create("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Stat("empty")
+	if err != nil {
+		t.Errorf("Empty file must be created: %v\n", err)
+	}
+
+	f, err = fs.OpenFile("refs/heads/master", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("1"))
+
+	//another node creates the same file meanwhile
+	_, err = s.Put(context.Background(), "user/repo/refs/heads/master", []byte("2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err == nil {
+		t.Error("File created exclusively cannot overwrite file created concurrently")
+	}
+
+	if data := readFile(t, fs, "refs/heads/master"); data != "2" {
+		t.Errorf("Wrong content. Must: 2, has: %s\n", data)
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := NewCache(s, 10)
+
+	_, err := c.Put(ctx, "repo/objects/ab/cd", []byte("object"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, err := c.Get(ctx, "repo/objects/ab/cd")
+	if err != nil || string(data) != "object" {
+		t.Fatalf("Wrong object: %s, %v\n", data, err)
+	}
+
+	//cached object is read without store
+	err = s.Delete(ctx, "repo/objects/ab/cd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, err = c.Get(ctx, "repo/objects/ab/cd")
+	if err != nil || string(data) != "object" {
+		t.Errorf("Object must be cached: %s, %v\n", data, err)
+	}
+
+	err = c.Delete(ctx, "repo/objects/ab/cd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = c.Get(ctx, "repo/objects/ab/cd")
+	if err != ErrNotFound {
+		t.Errorf("Removed object must be removed from cache. Must: %v, has: %v\n", ErrNotFound, err)
+	}
+}
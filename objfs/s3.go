@@ -0,0 +1,155 @@
+package objfs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type s3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+//NewS3Store - returns store kept in bucket of S3-compatible service, bucket must exist
+func NewS3Store(endpoint, accessKey, secretKey, bucket string, secure bool) (Store, error) {
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:    secure,
+		Transport: &wildcardTransport{transport},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Store{client: client, bucket: bucket}, nil
+}
+
+//wildcardTransport - minio-go always quotes etag of conditional put, but "If-None-Match: *" must not be quoted
+type wildcardTransport struct {
+	http.RoundTripper
+}
+
+func (t *wildcardTransport) RoundTrip(rq *http.Request) (*http.Response, error) {
+	if rq.Header.Get("If-None-Match") == `"*"` {
+		rq = rq.Clone(rq.Context())
+		rq.Header.Set("If-None-Match", "*")
+	}
+
+	return t.RoundTripper.RoundTrip(rq)
+}
+
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey":
+		return ErrNotFound
+	case "PreconditionFailed":
+		return ErrPrecondition
+	}
+
+	return err
+}
+
+func info(o minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{Key: o.Key, Size: o.Size, Modified: o.LastModified, ETag: strings.Trim(o.ETag, `"`)}
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	o, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+
+	defer o.Close()
+
+	st, err := o.Stat()
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+
+	data, err := ioutil.ReadAll(o)
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+
+	return data, info(st), nil
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	st, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return info(st), nil
+}
+
+func (s *s3Store) put(ctx context.Context, key string, data []byte, opts minio.PutObjectOptions) (string, error) {
+	opts.DisableMultipart = true
+
+	res, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		return "", s3Error(err)
+	}
+
+	return strings.Trim(res.ETag, `"`), nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte) (string, error) {
+	return s.put(ctx, key, data, minio.PutObjectOptions{})
+}
+
+func (s *s3Store) PutIf(ctx context.Context, key string, data []byte, etag string) (string, error) {
+	opts := minio.PutObjectOptions{}
+
+	if etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(etag)
+	}
+
+	return s.put(ctx, key, data, opts)
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		err = s3Error(err)
+		if err == ErrNotFound {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string, recursive bool) ([]ObjectInfo, error) {
+	res := []ObjectInfo{}
+
+	for o := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: recursive}) {
+		if o.Err != nil {
+			return nil, s3Error(o.Err)
+		}
+
+		//common prefix
+		if strings.HasSuffix(o.Key, "/") {
+			res = append(res, ObjectInfo{Key: strings.TrimSuffix(o.Key, "/"), Dir: true})
+			continue
+		}
+
+		res = append(res, *info(o))
+	}
+
+	return res, nil
+}
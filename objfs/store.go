@@ -0,0 +1,265 @@
+//Package objfs - billy filesystem which keeps files as objects of S3-compatible store.
+//Object stores have no directories, a directory exists while there are objects under its prefix.
+package objfs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrNotFound - object doesn't exist
+var ErrNotFound = fmt.Errorf("object not found")
+
+//ErrPrecondition - object was changed by somebody else since it was read
+var ErrPrecondition = fmt.Errorf("object was changed concurrently")
+
+//ObjectInfo - object or directory (common prefix) of listing
+type ObjectInfo struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+	ETag     string
+	Dir      bool
+}
+
+//Store - the part of S3 API the filesystem needs
+type Store interface {
+	//Get - returns content of object or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error)
+	//Stat - returns object without content or ErrNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	//Put - replaces object, etag of new content is returned
+	Put(ctx context.Context, key string, data []byte) (string, error)
+	//PutIf - replaces object only if its etag is still the same, empty etag means that object must not exist.
+	//ErrPrecondition is returned otherwise
+	PutIf(ctx context.Context, key string, data []byte, etag string) (string, error)
+	//Delete - removes object, missing object isn't an error
+	Delete(ctx context.Context, key string) error
+	//List - returns objects which keys start with prefix ordered by key. Not recursive listing stops
+	//at the next "/" and returns directories without trailing slash
+	List(ctx context.Context, prefix string, recursive bool) ([]ObjectInfo, error)
+}
+
+//dirStore - store which keeps objects as files of local directory, it's a stand-in of S3 for tests and single node
+type dirStore struct {
+	sync.Mutex
+	root string
+}
+
+//tmpPrefix - files being written, they aren't listed
+const tmpPrefix = ".tmp-"
+
+//NewDirStore - returns store kept in directory, it's created if it doesn't exist
+func NewDirStore(root string) (Store, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirStore{root: root}, nil
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *dirStore) file(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("Wrong object key %s", key)
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+func (s *dirStore) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	p, err := s.file(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+
+		return nil, nil, err
+	}
+
+	//directory is a prefix of other keys, there is no object with its key
+	if fi.IsDir() {
+		return nil, nil, ErrNotFound
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, &ObjectInfo{Key: key, Size: fi.Size(), Modified: fi.ModTime(), ETag: etag(data)}, nil
+}
+
+func (s *dirStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	_, info, err := s.Get(ctx, key)
+	return info, err
+}
+
+func (s *dirStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.write(key, data)
+}
+
+func (s *dirStore) PutIf(ctx context.Context, key string, data []byte, tag string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	_, info, err := s.Get(ctx, key)
+
+	switch {
+	case err == ErrNotFound:
+		if tag != "" {
+			return "", ErrPrecondition
+		}
+	case err != nil:
+		return "", err
+	case info.ETag != tag:
+		return "", ErrPrecondition
+	}
+
+	return s.write(key, data)
+}
+
+//write - object is replaced by rename, so readers never see partial content
+func (s *dirStore) write(key string, data []byte) (string, error) {
+	p, err := s.file(key)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), tmpPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return etag(data), nil
+}
+
+func (s *dirStore) Delete(ctx context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	p, err := s.file(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	//empty directories don't exist in object store
+	for dir := filepath.Dir(p); dir != s.root && strings.HasPrefix(dir, s.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *dirStore) List(ctx context.Context, prefix string, recursive bool) ([]ObjectInfo, error) {
+	//listing starts from the directory prefix belongs to
+	dirKey := prefix[:strings.LastIndex(prefix, "/")+1]
+	dir := filepath.Join(s.root, filepath.FromSlash(dirKey))
+
+	res := []ObjectInfo{}
+
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if p == dir || strings.HasPrefix(fi.Name(), tmpPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+
+		if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key+"/") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if fi.IsDir() {
+			if recursive || !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+
+			res = append(res, ObjectInfo{Key: key, Dir: true})
+
+			return filepath.SkipDir
+		}
+
+		if strings.HasPrefix(key, prefix) {
+			res = append(res, ObjectInfo{Key: key, Size: fi.Size(), Modified: fi.ModTime()})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+
+	return res, nil
+}
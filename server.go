@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -17,6 +24,33 @@ import (
 	"bitbucket.org/vishjosh/bipp-go-git/experimental-app/gitsvc"
 )
 
+const (
+	eventsBufferSize = 64
+	eventsHeartbeat  = 30 * time.Second
+)
+
+//errorStatuses - HTTP statuses of typed errors
+var errorStatuses = map[contract.ErrorCode]int{
+	contract.ErrorCodeInternal:    http.StatusInternalServerError,
+	contract.ErrorCodeValidation:  http.StatusBadRequest,
+	contract.ErrorCodeNotFound:    http.StatusNotFound,
+	contract.ErrorCodeConflict:    http.StatusConflict,
+	contract.ErrorCodeAuth:        http.StatusUnauthorized,
+	contract.ErrorCodeForbidden:   http.StatusForbidden,
+	contract.ErrorCodeUpstream:    http.StatusBadGateway,
+	contract.ErrorCodeUpToDate:    http.StatusConflict,
+	contract.ErrorCodeCanceled:    http.StatusGatewayTimeout,
+	contract.ErrorCodeUnavailable: http.StatusServiceUnavailable,
+	contract.ErrorCodeQuota:       http.StatusForbidden,
+}
+
+//errorCodes - codes of untyped errors by status chosen by handler
+var errorCodes = map[int]contract.ErrorCode{
+	http.StatusBadRequest: contract.ErrorCodeValidation,
+	http.StatusNotFound:   contract.ErrorCodeNotFound,
+	http.StatusConflict:   contract.ErrorCodeConflict,
+}
+
 type server struct {
 	settings *contract.ServerSettings
 	logger   *log.Logger
@@ -36,6 +70,8 @@ func newServer(settings *contract.ServerSettings, logger *log.Logger, db *sqlx.D
 }
 
 func (s *server) Start() error {
+	defer s.gitSvc.Close()
+
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
@@ -61,6 +97,13 @@ func (s *server) Start() error {
 			r.Post("/switch", s.switchUser)
 		})
 
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(s.adminOnly)
+			r.Get("/verify", s.verify)
+			r.Get("/usage", s.usages)
+			r.Put("/quota", s.setQuota)
+		})
+
 		r.Route("/repositories", func(r chi.Router) {
 			r.Get("/{user}", s.repositories)
 			r.Get("/current/{user}", s.currentRepo)
@@ -68,6 +111,15 @@ func (s *server) Start() error {
 			r.Post("/", s.createRepository)
 			r.Post("/clone", s.clone)
 			r.Delete("/", s.deleteRepository)
+			r.Get("/export", s.exportRepository)
+			r.Post("/import", s.importRepository)
+			r.Post("/gc", s.gc)
+			r.Get("/trash", s.trash)
+			r.Post("/restore", s.restoreRepository)
+			r.Get("/backups", s.backups)
+			r.Post("/backups", s.backupRepository)
+			r.Post("/fork", s.forkRepository)
+			r.Get("/forks", s.forks)
 		})
 
 		r.Route("/branches", func(r chi.Router) {
@@ -75,6 +127,9 @@ func (s *server) Start() error {
 			r.Post("/checkout", s.checkoutBranch)
 			r.Post("/", s.createBranch)
 			r.Delete("/", s.deleteBranch)
+			r.Get("/protection", s.branchProtections)
+			r.Post("/protection", s.setBranchProtection)
+			r.Delete("/protection", s.removeBranchProtection)
 		})
 
 		r.Route("/log", func(r chi.Router) {
@@ -104,6 +159,43 @@ func (s *server) Start() error {
 		r.Route("/merge", func(r chi.Router) {
 			r.Post("/", s.merge)
 			r.Post("/abort", s.abortMerge)
+			r.Post("/preview", s.mergePreview)
+		})
+
+		r.Get("/archive", s.archive)
+
+		r.Get("/usage", s.usage)
+
+		r.Get("/events", s.events)
+
+		r.Route("/jobs", func(r chi.Router) {
+			r.Get("/", s.jobs)
+			r.Post("/", s.submitJob)
+			r.Get("/{id}", s.job)
+			r.Post("/{id}/cancel", s.cancelJob)
+		})
+
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Get("/", s.webhooks)
+			r.Post("/", s.createWebhook)
+			r.Delete("/", s.removeWebhook)
+			r.Get("/{id}/deliveries", s.webhookDeliveries)
+		})
+
+		r.Route("/hooks", func(r chi.Router) {
+			r.Get("/", s.hookRules)
+			r.Post("/", s.setHookRules)
+		})
+
+		r.Route("/merge-requests", func(r chi.Router) {
+			r.Get("/", s.mergeRequests)
+			r.Post("/", s.createMergeRequest)
+			r.Get("/{id}", s.mergeRequest)
+			r.Post("/{id}/comments", s.commentMergeRequest)
+			r.Post("/{id}/approve", s.approveMergeRequest)
+			r.Post("/{id}/merge", s.acceptMergeRequest)
+			r.Post("/{id}/close", s.closeMergeRequest)
+			r.Post("/{id}/reopen", s.reopenMergeRequest)
 		})
 	})
 
@@ -126,16 +218,15 @@ func (s *server) switchUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if rq.Name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("name cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "name cannot be empty"))
 
 		return
 	}
 
-	err = s.gitSvc.SwitchUser(&contract.User{Name: rq.Name})
+	err = s.gitSvc.SwitchUser(r.Context(), &contract.User{Name: rq.Name})
 
 	if err != nil {
-		s.writeError(w, http.StatusBadRequest, err)
+		s.writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -155,19 +246,20 @@ func (s *server) pull(w http.ResponseWriter, r *http.Request) {
 
 	var msg string
 	if rq.Auth == nil {
-		msg, err = s.gitSvc.Pull(s.toBaseRequest(rq.Base), rq.Remote, nil)
+		msg, err = s.gitSvc.Pull(r.Context(), s.toBaseRequest(rq.Base), rq.Remote, nil)
 
 	} else {
-		msg, err = s.gitSvc.Pull(s.toBaseRequest(rq.Base), rq.Remote, &contract.Credentials{Name: rq.Auth.Name, Password: rq.Auth.Psw})
+		msg, err = s.gitSvc.Pull(r.Context(), s.toBaseRequest(rq.Base), rq.Remote, &contract.Credentials{Name: rq.Auth.Name, Password: rq.Auth.Psw})
 	}
 
 	if err != nil {
+		//message of failed merge is kept in details
 		if msg != "" {
-			s.writeJSON(w, http.StatusOK, &contract.MsgResult{Msg: msg})
-		} else {
-			s.writeError(w, http.StatusInternalServerError, err)
+			err = withDetail(err, "message", msg)
 		}
 
+		s.writeError(w, http.StatusInternalServerError, err)
+
 		return
 	}
 
@@ -185,10 +277,10 @@ func (s *server) push(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if rq.Auth == nil {
-		err = s.gitSvc.Push(s.toBaseRequest(rq.Base), rq.Remote, nil)
+		err = s.gitSvc.Push(r.Context(), s.toBaseRequest(rq.Base), rq.Remote, nil, rq.Force)
 
 	} else {
-		err = s.gitSvc.Push(s.toBaseRequest(rq.Base), rq.Remote, &contract.Credentials{Name: rq.Auth.Name, Password: rq.Auth.Psw})
+		err = s.gitSvc.Push(r.Context(), s.toBaseRequest(rq.Base), rq.Remote, &contract.Credentials{Name: rq.Auth.Name, Password: rq.Auth.Psw}, rq.Force)
 	}
 
 	if err != nil {
@@ -210,7 +302,7 @@ func (s *server) commit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = s.gitSvc.Commit(s.toBaseRequest(rq.Base), rq.Message)
+	_, err = s.gitSvc.Commit(r.Context(), s.toBaseRequest(rq.Base), rq.Message)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
@@ -240,11 +332,13 @@ func (s *server) clone(w http.ResponseWriter, r *http.Request) {
 
 	var repo string
 
+	opts := toCloneOptions(&rq.CloneOptionsRQ)
+
 	if rq.Auth == nil {
-		repo, err = s.gitSvc.Clone(rq.User, rq.URL, nil)
+		repo, err = s.gitSvc.Clone(r.Context(), rq.User, rq.URL, nil, opts)
 
 	} else {
-		repo, err = s.gitSvc.Clone(rq.User, rq.URL, &contract.Credentials{Name: rq.Auth.Name, Password: rq.Auth.Psw})
+		repo, err = s.gitSvc.Clone(r.Context(), rq.User, rq.URL, &contract.Credentials{Name: rq.Auth.Name, Password: rq.Auth.Psw}, opts)
 	}
 
 	if err != nil {
@@ -255,13 +349,24 @@ func (s *server) clone(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, http.StatusOK, &contract.RepoRS{Name: repo})
 }
 
+func toCloneOptions(rq *contract.CloneOptionsRQ) *contract.CloneOptions {
+	return &contract.CloneOptions{
+		Name:         rq.Name,
+		Remote:       rq.Remote,
+		Depth:        rq.Depth,
+		SingleBranch: rq.SingleBranch,
+		Branch:       rq.Branch,
+		Tag:          rq.Tag,
+		NoSubmodules: rq.NoSubmodules,
+	}
+}
+
 func (s *server) files(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	branch := q.Get("branch")
 
 	if branch == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("branch cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "branch cannot be empty"))
 
 		return
 	}
@@ -269,8 +374,7 @@ func (s *server) files(w http.ResponseWriter, r *http.Request) {
 	repo := q.Get("repo")
 
 	if repo == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("repo cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
@@ -278,19 +382,18 @@ func (s *server) files(w http.ResponseWriter, r *http.Request) {
 	user := q.Get("user")
 
 	if user == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	files, err := s.gitSvc.FilesList(&contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch})
+	files, err := s.gitSvc.FilesList(r.Context(), &contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch})
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	statuses, err := s.gitSvc.Status(&contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch})
+	statuses, err := s.gitSvc.Status(r.Context(), &contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch})
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
@@ -344,8 +447,7 @@ func (s *server) file(w http.ResponseWriter, r *http.Request) {
 	branch := q.Get("branch")
 
 	if branch == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("branch cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "branch cannot be empty"))
 
 		return
 	}
@@ -353,8 +455,7 @@ func (s *server) file(w http.ResponseWriter, r *http.Request) {
 	repo := q.Get("repo")
 
 	if repo == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("repo cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
@@ -362,8 +463,7 @@ func (s *server) file(w http.ResponseWriter, r *http.Request) {
 	user := q.Get("user")
 
 	if user == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
@@ -371,21 +471,23 @@ func (s *server) file(w http.ResponseWriter, r *http.Request) {
 	path := q.Get("path")
 
 	if path == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("path cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "path cannot be empty"))
 
 		return
 	}
 
-	f, err := s.gitSvc.File(&contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch}, path)
+	f, err := s.gitSvc.File(r.Context(), &contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch}, path)
 	if err != nil {
-		s.writeError(w, http.StatusBadRequest, err)
+		s.writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	defer f.Close()
+
 	bytes, err := ioutil.ReadAll(f)
-	if err != nil && err != io.EOF {
+	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
+		return
 	}
 
 	s.writeJSON(w, http.StatusOK, &contract.FileRS{Path: path, Content: string(bytes)})
@@ -401,7 +503,7 @@ func (s *server) addFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.gitSvc.AddFile(s.toBaseRequest(rq.Base), rq.Path, rq.Content)
+	err = s.gitSvc.AddFile(r.Context(), s.toBaseRequest(rq.Base), rq.Path, rq.Content)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
@@ -421,7 +523,7 @@ func (s *server) editFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.gitSvc.EditFile(s.toBaseRequest(rq.Base), rq.Path, rq.Content)
+	err = s.gitSvc.EditFile(r.Context(), s.toBaseRequest(rq.Base), rq.Path, rq.Content)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
@@ -441,7 +543,7 @@ func (s *server) removeFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.gitSvc.RemoveFile(s.toBaseRequest(rq.Base), rq.Path)
+	err = s.gitSvc.RemoveFile(r.Context(), s.toBaseRequest(rq.Base), rq.Path)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
@@ -456,8 +558,7 @@ func (s *server) logs(w http.ResponseWriter, r *http.Request) {
 	branch := q.Get("branch")
 
 	if branch == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("branch cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "branch cannot be empty"))
 
 		return
 	}
@@ -465,8 +566,7 @@ func (s *server) logs(w http.ResponseWriter, r *http.Request) {
 	repo := q.Get("repo")
 
 	if repo == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("repo cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
@@ -474,15 +574,14 @@ func (s *server) logs(w http.ResponseWriter, r *http.Request) {
 	user := q.Get("user")
 
 	if user == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	commits, err := s.gitSvc.Log(&contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch})
+	commits, err := s.gitSvc.Log(r.Context(), &contract.BaseRequest{User: &contract.User{Name: user}, Repository: repo, Branch: branch})
 	if err != nil {
-		s.writeError(w, http.StatusBadRequest, err)
+		s.writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -520,7 +619,7 @@ func (s *server) createBranch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.gitSvc.CreateBranch(rq.User, rq.Repo, rq.Branch, "")
+	err = s.gitSvc.CreateBranch(r.Context(), rq.User, rq.Repo, rq.Branch, "")
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 
@@ -541,7 +640,7 @@ func (s *server) deleteBranch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.gitSvc.RemoveBranch(rq.User, rq.Repo, rq.Branch)
+	err = s.gitSvc.RemoveBranch(r.Context(), rq.User, rq.Repo, rq.Branch)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 
@@ -562,10 +661,98 @@ func (s *server) checkoutBranch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.gitSvc.CheckoutBranch(rq.User, rq.Repo, rq.Branch)
+	err = s.gitSvc.CheckoutBranch(r.Context(), rq.User, rq.Repo, rq.Branch)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (s *server) branchProtections(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	rules, err := s.gitSvc.BranchProtections(r.Context(), user, repo)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := []contract.BranchProtectionRS{}
+
+	for _, p := range rules {
+		res = append(res, contract.BranchProtectionRS{
+			Branch:              p.Branch,
+			NoDeletion:          p.NoDeletion,
+			NoForcePush:         p.NoForcePush,
+			RequireMergeRequest: p.RequireMergeRequest,
+			Committers:          p.Committers,
+			RequiredApprovals:   p.RequiredApprovals,
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.BranchProtectionsRS{Protections: res})
+}
+
+func (s *server) setBranchProtection(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.BranchProtectionRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.gitSvc.SetBranchProtection(r.Context(), rq.User, rq.Repo, &contract.BranchProtection{
+		Branch:              rq.Branch,
+		NoDeletion:          rq.NoDeletion,
+		NoForcePush:         rq.NoForcePush,
+		RequireMergeRequest: rq.RequireMergeRequest,
+		Committers:          rq.Committers,
+		RequiredApprovals:   rq.RequiredApprovals,
+	})
+
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (s *server) removeBranchProtection(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.BranchRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
 
+	err = s.gitSvc.RemoveBranchProtection(r.Context(), rq.User, rq.Repo, rq.Branch)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -578,8 +765,7 @@ func (s *server) branches(w http.ResponseWriter, r *http.Request) {
 	repo := q.Get("repo")
 
 	if repo == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("repo cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
@@ -587,13 +773,12 @@ func (s *server) branches(w http.ResponseWriter, r *http.Request) {
 	user := q.Get("user")
 
 	if user == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	branches, err := s.gitSvc.Branches(user, repo)
+	branches, err := s.gitSvc.Branches(r.Context(), user, repo)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 
@@ -602,7 +787,7 @@ func (s *server) branches(w http.ResponseWriter, r *http.Request) {
 
 	cur := ""
 	if len(branches) > 0 {
-		cBr, err := s.gitSvc.CurrentBranch()
+		cBr, err := s.gitSvc.CurrentBranch(r.Context())
 		if err != nil {
 			s.writeError(w, http.StatusInternalServerError, err)
 
@@ -629,20 +814,20 @@ func (s *server) createRepository(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if rq.Repo == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("repo cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
 
 	if rq.User == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	err = s.gitSvc.CreateRepository(rq.User, rq.Repo)
+	opts := &contract.RepoOptions{Backend: contract.ToFsType(rq.Backend), Description: rq.Description, DefaultBranch: rq.DefaultBranch}
+
+	err = s.gitSvc.CreateRepository(r.Context(), rq.User, rq.Repo, opts)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 
@@ -664,20 +849,18 @@ func (s *server) deleteRepository(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if rq.Repo == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("repo cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
 
 	if rq.User == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	err = s.gitSvc.RemoveRepository(rq.User, rq.Repo)
+	err = s.gitSvc.RemoveRepository(r.Context(), rq.User, rq.Repo)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 
@@ -694,8 +877,7 @@ func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
 	repo := q.Get("repo")
 
 	if repo == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("repo cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
@@ -703,13 +885,12 @@ func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
 	user := q.Get("user")
 
 	if user == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	err := s.gitSvc.OpenRepository(user, repo)
+	err := s.gitSvc.OpenRepository(r.Context(), user, repo)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 
@@ -720,50 +901,143 @@ func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("{}"))
 }
 
-func (s *server) currentRepo(w http.ResponseWriter, r *http.Request) {
-	user := chi.URLParam(r, "user")
+//exportRepository - downloads repository as git bundle
+func (s *server) exportRepository(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
 
 	if user == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	repo := s.gitSvc.CurrentRepository()
+	//bundle is buffered, so error can still be reported with proper status
+	buf := &bytes.Buffer{}
 
-	s.writeJSON(w, http.StatusOK, &contract.RepoRS{Name: repo})
+	err := s.gitSvc.Export(r.Context(), user, repo, buf)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-bundle")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", repo+".bundle"))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
 }
 
-func (s *server) repositories(w http.ResponseWriter, r *http.Request) {
-	user := chi.URLParam(r, "user")
+//importRepository - creates repository from git bundle sent as request body
+func (s *server) importRepository(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
 
 	if user == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user cannot be empty"))
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
 		return
 	}
 
-	repos, err := s.gitSvc.Repositories(user)
+	err := s.gitSvc.Import(r.Context(), user, repo, r.Body)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 
 		return
 	}
 
-	res := []string{}
+	s.writeJSON(w, http.StatusOK, &contract.RepoRS{Name: repo})
+}
+
+//gc - prunes and repacks objects of repository
+func (s *server) gc(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.RepoRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rq.Repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	if rq.User == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
 
-	for _, r := range repos {
+	res, err := s.gitSvc.GC(r.Context(), rq.User, rq.Repo)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
 
-		res = append(res, r)
+		return
 	}
 
-	s.writeJSON(w, http.StatusOK, &contract.RepositoriesRS{Repos: res})
+	s.writeJSON(w, http.StatusOK, &contract.GCRS{
+		SizeBefore: res.SizeBefore,
+		SizeAfter:  res.SizeAfter,
+		Reclaimed:  res.Reclaimed,
+		Pruned:     res.Pruned,
+		Repacked:   res.Repacked,
+	})
 }
 
-func (s *server) merge(w http.ResponseWriter, r *http.Request) {
-	rq := &contract.MergeRQ{}
+//trash - removed repositories of user which can be restored
+func (s *server) trash(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	repos, err := s.gitSvc.Trash(r.Context(), user)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	res := &contract.TrashRS{Items: []contract.TrashItemRS{}}
+
+	for _, repo := range repos {
+		res.Items = append(res.Items, contract.TrashItemRS{
+			Name:      repo.Name,
+			Backend:   int(repo.Backend),
+			DeletedAt: repo.DeletedAt,
+			PurgeAt:   repo.DeletedAt.Add(s.settings.TrashRetention),
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+//restoreRepository - takes repository from trash or recreates it from backup
+func (s *server) restoreRepository(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.RestoreRQ{}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(rq)
 
@@ -772,48 +1046,31 @@ func (s *server) merge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if rq.Theirs == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Theirs branch cannot be empty"))
+	if rq.Repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
 
 		return
 	}
 
-	mergeMsg, err := s.gitSvc.Merge(s.toBaseRequest(rq.Base), rq.Theirs)
+	if rq.User == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
 
-	if err != nil {
+		return
+	}
 
-		switch err {
-		case git.ErrHasUncommittedFiles:
-			{
-				//ErrHasUncommittedFiles occurs when there are any unstaged or staged files before merge
-				s.writeJSON(w, http.StatusOK, &contract.MergeRS{Message: err.Error()})
-				return
-			}
-		case git.ErrMergeCommitNeeded:
-			{
-				s.writeJSON(w, http.StatusOK, &contract.MergeRS{Message: err.Error()})
-				return
-			}
-		case git.ErrMergeWithConflicts:
-			{
-				s.writeJSON(w, http.StatusOK, &contract.MergeRS{Message: mergeMsg})
-				return
+	err = s.gitSvc.RestoreRepository(r.Context(), rq.User, rq.Repo, rq.Backup)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
 
-			}
-		default:
-			{
-				s.writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-		}
+		return
 	}
 
-	s.writeJSON(w, http.StatusOK, &contract.MergeRS{IsFastforward: true})
+	s.writeJSON(w, http.StatusOK, &contract.RepoRS{Name: rq.Repo})
 }
 
-func (s *server) abortMerge(w http.ResponseWriter, r *http.Request) {
-	rq := &contract.AbortMergeRQ{}
+//forkRepository - clones repository of user to repository of another user inside of the server
+func (s *server) forkRepository(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.ForkRQ{}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(rq)
 
@@ -822,32 +1079,1224 @@ func (s *server) abortMerge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.gitSvc.AbortMerge(s.toBaseRequest(rq.Base))
+	if rq.Repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	if rq.User == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	if rq.ToUser == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "toUser cannot be empty"))
+
+		return
+	}
 
+	repo, err := s.gitSvc.Fork(r.Context(), rq.User, rq.Repo, rq.ToUser, rq.Name)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
+
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("{}"))
+	s.writeJSON(w, http.StatusOK, &contract.RepoRS{Name: repo})
 }
 
-func (s *server) writeJSON(w http.ResponseWriter, statusCode int, payload interface{}) {
+func (s *server) forks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
 
-	json, err := json.Marshal(payload)
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	forks, err := s.gitSvc.Forks(r.Context(), user, repo)
 	if err != nil {
-		s.logger.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.writeError(w, http.StatusInternalServerError, err)
+
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	w.Write(json)
+	res := &contract.ForksRS{Forks: []contract.ForkRS{}}
+
+	for _, f := range forks {
+		res.Forks = append(res.Forks, contract.ForkRS{Owner: f.Owner, Name: f.Name, CreatedAt: f.CreatedAt})
+	}
+
+	s.writeJSON(w, http.StatusOK, res)
 }
 
-func (s *server) writeError(w http.ResponseWriter, statusCode int, err error) {
-	w.WriteHeader(statusCode)
-	w.Write([]byte(err.Error()))
+func (s *server) backups(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	backups, err := s.gitSvc.Backups(r.Context(), user, repo)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	res := &contract.BackupsRS{Backups: []contract.BackupRS{}}
+
+	for _, b := range backups {
+		res.Backups = append(res.Backups, toBackupRS(&b))
+	}
+
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+//backupRepository - backs up repository right now
+func (s *server) backupRepository(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.RepoRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rq.Repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	if rq.User == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	b, err := s.gitSvc.Backup(r.Context(), rq.User, rq.Repo)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, toBackupRS(b))
+}
+
+func toBackupRS(b *contract.Backup) contract.BackupRS {
+	return contract.BackupRS{ID: b.ID, Repo: b.Repo, CreatedAt: b.CreatedAt, Size: b.Size}
+}
+
+//verify - checks integrity of repository, problems are reported with 200 status
+func (s *server) verify(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	report, err := s.gitSvc.Verify(r.Context(), user, repo)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.VerifyRS{
+		OK:        report.OK(),
+		Objects:   report.Objects,
+		Missing:   report.Missing,
+		Corrupted: report.Corrupted,
+		Dangling:  report.Dangling,
+		Index:     report.Index,
+	})
+}
+
+//usage - storage taken by repositories of user and its quota
+func (s *server) usage(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	u, err := s.gitSvc.Usage(r.Context(), user)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, toUsageRS(u))
+}
+
+//usages - storage taken by every user
+func (s *server) usages(w http.ResponseWriter, r *http.Request) {
+	usages, err := s.gitSvc.Usages(r.Context())
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	res := &contract.UsagesRS{Users: []contract.UsageRS{}}
+
+	for _, u := range usages {
+		res.Users = append(res.Users, toUsageRS(&u))
+	}
+
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+//adminOnly - admin routes need ADMIN_TOKEN in Authorization header, they're disabled if it isn't set
+func (s *server) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.settings.AdminToken == "" {
+			s.writeError(w, http.StatusForbidden, contract.NewError(contract.ErrorCodeForbidden, "Admin API is disabled, set ADMIN_TOKEN to enable it"))
+
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.settings.AdminToken)) != 1 {
+			s.writeError(w, http.StatusUnauthorized, contract.NewError(contract.ErrorCodeAuth, "Wrong admin token"))
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+//setQuota - changes quota of user, reset returns the default one
+func (s *server) setQuota(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.QuotaRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rq.User == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	var quota *contract.Quota
+	if !rq.Reset {
+		quota = &contract.Quota{UserBytes: rq.UserBytes, RepoBytes: rq.RepoBytes, Repos: rq.Repos}
+	}
+
+	err = s.gitSvc.SetQuota(r.Context(), rq.User, quota)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	quota, err = s.gitSvc.Quota(r.Context(), rq.User)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.QuotaRS{UserBytes: quota.UserBytes, RepoBytes: quota.RepoBytes, Repos: quota.Repos})
+}
+
+func toUsageRS(u *contract.Usage) contract.UsageRS {
+	res := contract.UsageRS{
+		User:          u.User,
+		Repos:         u.Repos,
+		ObjectBytes:   u.ObjectBytes,
+		WorktreeBytes: u.WorktreeBytes,
+		Items:         []contract.RepoUsageRS{},
+		Quota:         contract.QuotaRS{UserBytes: u.Quota.UserBytes, RepoBytes: u.Quota.RepoBytes, Repos: u.Quota.Repos},
+	}
+
+	for _, i := range u.Items {
+		res.Items = append(res.Items, contract.RepoUsageRS{Repo: i.Repo, ObjectBytes: i.ObjectBytes, WorktreeBytes: i.WorktreeBytes})
+	}
+
+	return res
+}
+
+//archive - downloads files of revision as zip or tar.gz
+func (s *server) archive(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	format := contract.ArchiveFormat(q.Get("format"))
+	if format == "" {
+		format = contract.ArchiveFormatZip
+	}
+
+	contentType := "application/zip"
+	if format == contract.ArchiveFormatTarGz {
+		contentType = "application/gzip"
+	}
+
+	buf := &bytes.Buffer{}
+
+	err := s.gitSvc.Archive(r.Context(), user, repo, q.Get("rev"), format, buf)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", repo+"."+string(format)))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+func (s *server) currentRepo(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	repo := s.gitSvc.CurrentRepository(r.Context())
+
+	s.writeJSON(w, http.StatusOK, &contract.RepoRS{Name: repo})
+}
+
+func (s *server) repositories(w http.ResponseWriter, r *http.Request) {
+	user := chi.URLParam(r, "user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	q := r.URL.Query()
+	query := &contract.RepositoryQuery{Sort: contract.RepositorySort(q.Get("sort")), Desc: q.Get("desc") == "true"}
+
+	var err error
+
+	if offset := q.Get("offset"); offset != "" {
+		query.Offset, err = strconv.Atoi(offset)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	page, err := s.gitSvc.Repositories(r.Context(), user, query)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	res := &contract.RepositoriesRS{Repos: []string{}, Items: []contract.RepositoryRS{}, Total: page.Total}
+
+	for _, repo := range page.Repos {
+		res.Repos = append(res.Repos, repo.Name)
+		res.Items = append(res.Items, contract.RepositoryRS{
+			Owner:         repo.Owner,
+			Name:          repo.Name,
+			Backend:       int(repo.Backend),
+			CreatedAt:     repo.CreatedAt,
+			Description:   repo.Description,
+			DefaultBranch: repo.DefaultBranch,
+			Size:          repo.Size,
+			ForkOf:        repo.ForkOf,
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+func (s *server) merge(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.MergeRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rq.Theirs == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "Theirs branch cannot be empty"))
+
+		return
+	}
+
+	mergeMsg, err := s.gitSvc.Merge(r.Context(), s.toBaseRequest(rq.Base), rq.Theirs)
+
+	if err != nil {
+
+		switch err {
+		case git.ErrHasUncommittedFiles:
+			{
+				//ErrHasUncommittedFiles occurs when there are any unstaged or staged files before merge
+				s.writeJSON(w, http.StatusOK, &contract.MergeRS{Message: err.Error()})
+				return
+			}
+		case git.ErrMergeCommitNeeded:
+			{
+				s.writeJSON(w, http.StatusOK, &contract.MergeRS{Message: err.Error()})
+				return
+			}
+		case git.ErrMergeWithConflicts:
+			{
+				s.writeJSON(w, http.StatusOK, &contract.MergeRS{Message: mergeMsg})
+				return
+
+			}
+		default:
+			{
+				s.writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.MergeRS{IsFastforward: true})
+}
+
+func (s *server) mergePreview(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.MergeRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rq.Theirs == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "Theirs branch cannot be empty"))
+
+		return
+	}
+
+	preview, err := s.gitSvc.MergePreview(r.Context(), s.toBaseRequest(rq.Base), rq.Theirs)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := &contract.MergePreviewRS{
+		Base:          preview.Base,
+		IsFastforward: preview.IsFastForward,
+		IsUpToDate:    preview.IsUpToDate,
+		Changes:       []contract.FileChangeRS{},
+		Conflicts:     preview.Conflicts,
+	}
+
+	for _, ch := range preview.Changes {
+		res.Changes = append(res.Changes, contract.FileChangeRS{Path: ch.Path, Action: ch.Action})
+	}
+
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+func (s *server) abortMerge(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.AbortMergeRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.gitSvc.AbortMerge(r.Context(), s.toBaseRequest(rq.Base))
+
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (s *server) mergeRequests(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	filter := &contract.MergeRequestFilter{Source: q.Get("source"), Target: q.Get("target"), Author: q.Get("author")}
+
+	if st := q.Get("status"); st != "" {
+		status, err := strconv.Atoi(st)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		filter.Status = contract.MergeRequestStatus(status)
+	}
+
+	mrs, err := s.gitSvc.MergeRequests(r.Context(), user, repo, filter)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := []contract.MergeRequestRS{}
+
+	for _, mr := range mrs {
+		res = append(res, s.toMergeRequestRS(&mr))
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.MergeRequestsRS{MergeRequests: res})
+}
+
+func (s *server) mergeRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	mr, err := s.gitSvc.MergeRequest(r.Context(), user, repo, id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toMergeRequestRS(mr))
+}
+
+func (s *server) createMergeRequest(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.MergeRequestRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rq.Repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	if rq.User == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	mr, err := s.gitSvc.CreateMergeRequest(r.Context(), rq.User, rq.Repo, &contract.MergeRequest{
+		Title:       rq.Title,
+		Description: rq.Description,
+		Source:      rq.Source,
+		Target:      rq.Target,
+		Author:      rq.User,
+	})
+
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toMergeRequestRS(mr))
+}
+
+func (s *server) commentMergeRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rq := &contract.MergeRequestCommentRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	mr, err := s.gitSvc.CommentMergeRequest(r.Context(), rq.User, rq.Repo, id, &contract.MergeRequestComment{
+		Author: rq.Author,
+		Text:   rq.Text,
+		Path:   rq.Path,
+		Line:   rq.Line,
+	})
+
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toMergeRequestRS(mr))
+}
+
+func (s *server) approveMergeRequest(w http.ResponseWriter, r *http.Request) {
+	rq, id, ok := s.decodeMergeRequestAction(w, r)
+	if !ok {
+		return
+	}
+
+	mr, err := s.gitSvc.ApproveMergeRequest(r.Context(), rq.User, rq.Repo, id, rq.Author)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toMergeRequestRS(mr))
+}
+
+func (s *server) acceptMergeRequest(w http.ResponseWriter, r *http.Request) {
+	rq, id, ok := s.decodeMergeRequestAction(w, r)
+	if !ok {
+		return
+	}
+
+	mr, err := s.gitSvc.AcceptMergeRequest(r.Context(), rq.User, rq.Repo, id, &contract.User{Name: rq.Author, Email: rq.Email})
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toMergeRequestRS(mr))
+}
+
+func (s *server) closeMergeRequest(w http.ResponseWriter, r *http.Request) {
+	rq, id, ok := s.decodeMergeRequestAction(w, r)
+	if !ok {
+		return
+	}
+
+	mr, err := s.gitSvc.CloseMergeRequest(r.Context(), rq.User, rq.Repo, id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toMergeRequestRS(mr))
+}
+
+func (s *server) reopenMergeRequest(w http.ResponseWriter, r *http.Request) {
+	rq, id, ok := s.decodeMergeRequestAction(w, r)
+	if !ok {
+		return
+	}
+
+	mr, err := s.gitSvc.ReopenMergeRequest(r.Context(), rq.User, rq.Repo, id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toMergeRequestRS(mr))
+}
+
+func (s *server) decodeMergeRequestAction(w http.ResponseWriter, r *http.Request) (*contract.MergeRequestActionRQ, int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return nil, 0, false
+	}
+
+	rq := &contract.MergeRequestActionRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return nil, 0, false
+	}
+
+	return rq, id, true
+}
+
+func (s *server) toMergeRequestRS(mr *contract.MergeRequest) contract.MergeRequestRS {
+	res := contract.MergeRequestRS{
+		ID:          mr.ID,
+		Title:       mr.Title,
+		Description: mr.Description,
+		Source:      mr.Source,
+		Target:      mr.Target,
+		Author:      mr.Author,
+		Status:      mr.Status,
+		Approvals:   mr.Approvals,
+		Comments:    []contract.MergeRequestCommentRS{},
+		MergeCommit: mr.MergeCommit,
+		MergedBy:    mr.MergedBy,
+		CreatedAt:   mr.CreatedAt,
+		UpdatedAt:   mr.UpdatedAt,
+	}
+
+	for _, c := range mr.Comments {
+		res.Comments = append(res.Comments, contract.MergeRequestCommentRS{
+			ID:        c.ID,
+			Author:    c.Author,
+			Text:      c.Text,
+			Path:      c.Path,
+			Line:      c.Line,
+			CreatedAt: c.CreatedAt,
+		})
+	}
+
+	return res
+}
+
+func (s *server) jobs(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	jobs, err := s.gitSvc.Jobs(r.Context(), user)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := []contract.JobRS{}
+
+	for _, j := range jobs {
+		res = append(res, s.toJobRS(&j))
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.JobsRS{Jobs: res})
+}
+
+func (s *server) submitJob(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.JobRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var auth *contract.Credentials
+
+	if rq.Auth != nil {
+		auth = &contract.Credentials{Name: rq.Auth.Name, Password: rq.Auth.Psw}
+	}
+
+	job := &contract.Job{
+		Type:   rq.Type,
+		User:   rq.User,
+		Repo:   rq.Repo,
+		Branch: rq.Branch,
+		URL:    rq.URL,
+		Remote: rq.Remote,
+		Force:  rq.Force,
+	}
+
+	if rq.Clone != nil {
+		job.Clone = toCloneOptions(rq.Clone)
+	}
+
+	job, err = s.gitSvc.SubmitJob(r.Context(), job, auth)
+
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusAccepted, s.toJobRS(job))
+}
+
+func (s *server) job(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := s.gitSvc.Job(r.Context(), r.URL.Query().Get("user"), id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toJobRS(job))
+}
+
+func (s *server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rq := &contract.CancelJobRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.gitSvc.CancelJob(r.Context(), rq.User, id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (s *server) toJobRS(j *contract.Job) contract.JobRS {
+	return contract.JobRS{
+		ID:     j.ID,
+		Type:   j.Type,
+		User:   j.User,
+		Repo:   j.Repo,
+		Branch: j.Branch,
+		URL:    j.URL,
+		Remote: j.Remote,
+		Force:  j.Force,
+		Status: j.Status,
+		Progress: contract.JobProgressRS{
+			Stage:        j.Progress.Stage,
+			Percent:      j.Progress.Percent,
+			Objects:      j.Progress.Objects,
+			TotalObjects: j.Progress.TotalObjects,
+			Bytes:        j.Progress.Bytes,
+			Message:      j.Progress.Message,
+		},
+		Result:     j.Result,
+		Error:      j.Error,
+		CreatedAt:  j.CreatedAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+	}
+}
+
+//events - streams repository events of user as Server-Sent Events, repo query parameter narrows them to one repository
+func (s *server) events(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	repo := q.Get("repo")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, errors.New("streaming isn't supported"))
+
+		return
+	}
+
+	//handler is called synchronously by service, so slow clients lose events instead of blocking it
+	events := make(chan contract.Event, eventsBufferSize)
+
+	cancel := s.gitSvc.Subscribe(func(e contract.Event) {
+		if e.User != user || (repo != "" && e.Repo != repo) {
+			return
+		}
+
+		select {
+		case events <- e:
+		default:
+			s.logger.Printf("Event %s is dropped for slow client, user: %s\n", e.Type, user)
+		}
+	})
+
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	var id int64
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			//comment line keeps connection open through proxies
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		case e := <-events:
+			data, err := json.Marshal(gitsvc.ToEventRS(e))
+			if err != nil {
+				s.logger.Println(err)
+				continue
+			}
+
+			id++
+
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, e.Type, data)
+			if err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func (s *server) webhooks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	hooks, err := s.gitSvc.Webhooks(r.Context(), user, repo)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := []contract.WebhookRS{}
+
+	for _, h := range hooks {
+		res = append(res, s.toWebhookRS(&h))
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.WebhooksRS{Webhooks: res})
+}
+
+func (s *server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.WebhookRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rq.URL == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "url cannot be empty"))
+
+		return
+	}
+
+	hook, err := s.gitSvc.CreateWebhook(r.Context(), rq.User, rq.Repo, &contract.Webhook{URL: rq.URL, Secret: rq.Secret, Events: rq.Events})
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.toWebhookRS(hook))
+}
+
+func (s *server) removeWebhook(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.RemoveWebhookRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.gitSvc.RemoveWebhook(r.Context(), rq.User, rq.Repo, rq.ID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+func (s *server) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	q := r.URL.Query()
+
+	deliveries, err := s.gitSvc.WebhookDeliveries(r.Context(), q.Get("user"), q.Get("repo"), id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := []contract.WebhookDeliveryRS{}
+
+	for _, d := range deliveries {
+		res = append(res, contract.WebhookDeliveryRS{
+			ID:          d.ID,
+			WebhookID:   d.WebhookID,
+			Event:       d.Event,
+			URL:         d.URL,
+			Attempts:    d.Attempts,
+			StatusCode:  d.StatusCode,
+			Error:       d.Error,
+			Success:     d.Success,
+			DeliveredAt: d.DeliveredAt,
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.WebhookDeliveriesRS{Deliveries: res})
+}
+
+func (s *server) toWebhookRS(h *contract.Webhook) contract.WebhookRS {
+	events := h.Events
+	if events == nil {
+		events = []contract.EventType{}
+	}
+
+	return contract.WebhookRS{ID: h.ID, URL: h.URL, HasSecret: h.Secret != "", Events: events, Active: h.Active}
+}
+
+func (s *server) hookRules(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+
+	if repo == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "repo cannot be empty"))
+
+		return
+	}
+
+	user := q.Get("user")
+
+	if user == "" {
+		s.writeError(w, http.StatusBadRequest, contract.NewError(contract.ErrorCodeValidation, "user cannot be empty"))
+
+		return
+	}
+
+	rules, err := s.gitSvc.HookRules(r.Context(), user, repo)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, &contract.HookRulesRS{
+		MessagePattern: rules.MessagePattern,
+		ForbiddenPaths: rules.ForbiddenPaths,
+		MaxFileSize:    rules.MaxFileSize,
+		SecretPatterns: rules.SecretPatterns,
+	})
+}
+
+func (s *server) setHookRules(w http.ResponseWriter, r *http.Request) {
+	rq := &contract.HookRulesRQ{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(rq)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.gitSvc.SetHookRules(r.Context(), rq.User, rq.Repo, &contract.HookRules{
+		MessagePattern: rq.MessagePattern,
+		ForbiddenPaths: rq.ForbiddenPaths,
+		MaxFileSize:    rq.MaxFileSize,
+		SecretPatterns: rq.SecretPatterns,
+	})
+
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+//withDetail - returns typed error with the same code and message which has additional detail
+func withDetail(err error, key, value string) error {
+	res := contract.WrapError(contract.ErrorCodeOf(err), err)
+	res.Details = map[string]string{key: value}
+
+	var typed *contract.Error
+	if errors.As(err, &typed) {
+		for k, v := range typed.Details {
+			res.Details[k] = v
+		}
+	}
+
+	return res
+}
+
+func (s *server) writeJSON(w http.ResponseWriter, statusCode int, payload interface{}) {
+
+	json, err := json.Marshal(payload)
+	if err != nil {
+		s.logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(json)
+}
+
+//writeError - writes error envelope, status of typed errors is defined by their code,
+//untyped errors keep status chosen by handler
+func (s *server) writeError(w http.ResponseWriter, statusCode int, err error) {
+	code := contract.ErrorCodeOf(err)
+
+	if code == contract.ErrorCodeInternal {
+		code = errorCodes[statusCode]
+
+		if code == "" {
+			code = contract.ErrorCodeInternal
+		}
+	} else {
+		statusCode = errorStatuses[code]
+	}
+
+	res := &contract.ErrorRS{Code: code, Message: err.Error()}
+
+	var typed *contract.Error
+	var protection *contract.BranchProtectionError
+	var hookErr *contract.HookError
+
+	switch {
+	case errors.As(err, &hookErr):
+		{
+			violations := []contract.ViolationRS{}
+
+			for _, v := range hookErr.Violations {
+				violations = append(violations, contract.ViolationRS{Hook: v.Hook, Rule: v.Rule, Path: v.Path, Message: v.Message})
+			}
+
+			res.Details = violations
+			statusCode = http.StatusUnprocessableEntity
+		}
+	case errors.As(err, &protection):
+		res.Details = map[string]string{"branch": protection.Branch, "reason": protection.Reason}
+	case errors.As(err, &typed) && len(typed.Details) > 0:
+		res.Details = typed.Details
+	}
+
+	s.writeJSON(w, statusCode, res)
 }
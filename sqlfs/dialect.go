@@ -0,0 +1,67 @@
+package sqlfs
+
+import "fmt"
+
+//dialect - parts of queries which differ between databases, it's name of sqlx driver
+type dialect string
+
+const mysql dialect = "mysql"
+const postgres dialect = "postgres"
+
+//pathType - MySQL can't have primary key longer than 3072 bytes
+func (d dialect) pathType() string {
+	if d == mysql {
+		return "VARBINARY(2048)"
+	}
+
+	return "VARCHAR(3072)"
+}
+
+func (d dialect) blobType() string {
+	switch d {
+	case mysql:
+		return "LONGBLOB"
+	case postgres:
+		return "BYTEA"
+	default:
+		return "BLOB"
+	}
+}
+
+//escape - clause of LIKE which makes backslash escape character, it's the default one in MySQL
+func (d dialect) escape() string {
+	if d == mysql {
+		return ""
+	}
+
+	return ` ESCAPE '\'`
+}
+
+//upsert - returns insert which replaces row with the same key
+func (d dialect) upsert(table, cols, values, key string) string {
+	if d == mysql {
+		return fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)
+			ON DUPLICATE KEY UPDATE mode = VALUES(mode), content = VALUES(content), modified = VALUES(modified)`, table, cols, values)
+	}
+
+	return fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)
+		ON CONFLICT (%s) DO UPDATE SET mode = excluded.mode, content = excluded.content, modified = excluded.modified`, table, cols, values, key)
+}
+
+//insertIgnore - returns insert which does nothing if row with the same key exists
+func (d dialect) insertIgnore(table, cols, values, key string) string {
+	if d == mysql {
+		return fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES (%s)", table, cols, values)
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO NOTHING", table, cols, values, key)
+}
+
+//concat - || is logical OR in MySQL
+func (d dialect) concat(a, b string) string {
+	if d == mysql {
+		return fmt.Sprintf("CONCAT(%s, %s)", a, b)
+	}
+
+	return fmt.Sprintf("CAST(%s AS TEXT) || %s", a, b)
+}
@@ -0,0 +1,609 @@
+//Package sqlfs - billy filesystem which keeps files in a table of SQL database.
+//Every file, directory and symlink is a row, content of file is read and written as a whole.
+//Table can keep one filesystem or be shared by many of them, Postgres, SQLite and MySQL are supported.
+package sqlfs
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy/helper/chroot"
+	"github.com/jmoiron/sqlx"
+)
+
+//maxLinks - how many symlinks are followed before giving up
+const maxLinks = 8
+
+//tableName - table names can't be passed as query parameters, so they are checked
+var tableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+//ErrDirNotEmpty - occurs on removal of directory with files
+var ErrDirNotEmpty = fmt.Errorf("directory not empty")
+
+type storage struct {
+	db      *sqlx.DB
+	table   string
+	dialect dialect
+	//shared - table keeps many filesystems, rows of this one have fs_id = id
+	shared bool
+	id     int64
+}
+
+//New - returns filesystem kept in table, the table is created if it doesn't exist
+func New(db *sqlx.DB, table string) (billy.Filesystem, error) {
+	s := &storage{db: db, table: table, dialect: dialect(db.DriverName())}
+
+	err := s.createTable()
+	if err != nil {
+		return nil, err
+	}
+
+	return chroot.New(s, "/"), nil
+}
+
+//NewShared - returns filesystem with specified id kept in table shared by many filesystems,
+//the table is created if it doesn't exist
+func NewShared(db *sqlx.DB, table string, id int64) (billy.Filesystem, error) {
+	s := &storage{db: db, table: table, dialect: dialect(db.DriverName()), shared: true, id: id}
+
+	err := s.createTable()
+	if err != nil {
+		return nil, err
+	}
+
+	return chroot.New(s, "/"), nil
+}
+
+//DeleteShared - removes all files of filesystem with specified id from shared table
+func DeleteShared(tx sqlx.Ext, table string, id int64) error {
+	if !tableName.MatchString(table) {
+		return fmt.Errorf("Wrong table name %s", table)
+	}
+
+	_, err := tx.Exec(tx.Rebind(fmt.Sprintf("DELETE FROM %s WHERE fs_id = ?", table)), id)
+
+	return err
+}
+
+func (s *storage) createTable() error {
+	if !tableName.MatchString(s.table) {
+		return fmt.Errorf("Wrong table name %s", s.table)
+	}
+
+	id, key := "", "PRIMARY KEY (path)"
+	if s.shared {
+		id, key = "fs_id BIGINT NOT NULL,", "PRIMARY KEY (fs_id, path)"
+	}
+
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		%s
+		path %s NOT NULL,
+		mode BIGINT NOT NULL,
+		content %s,
+		modified BIGINT NOT NULL,
+		%s
+	)`, s.table, id, s.dialect.pathType(), s.dialect.blobType(), key))
+
+	return err
+}
+
+//where - condition of query which is limited by rows of this filesystem
+func (s *storage) where(cond string) string {
+	if s.shared {
+		return "fs_id = ? AND " + cond
+	}
+
+	return cond
+}
+
+//args - arguments of condition made by where
+func (s *storage) args(args ...interface{}) []interface{} {
+	if s.shared {
+		return append([]interface{}{s.id}, args...)
+	}
+
+	return args
+}
+
+//insert - columns, placeholders and key of inserted row
+func (s *storage) insert(cols ...string) (string, string, string) {
+	key := "path"
+
+	if s.shared {
+		cols = append([]string{"fs_id"}, cols...)
+		key = "fs_id, path"
+	}
+
+	values := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+
+	return strings.Join(cols, ", "), values, key
+}
+
+//clean - returns path relative to root, root is empty string
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+//row - file without content
+type row struct {
+	Path     string `db:"path"`
+	Mode     int64  `db:"mode"`
+	Size     int64  `db:"size"`
+	Modified int64  `db:"modified"`
+}
+
+//key - identifies file in nodes
+func (s *storage) key(p string) string {
+	return fmt.Sprintf("%p/%s/%d/%s", s.db, s.table, s.id, p)
+}
+
+//opened - replaces size of rows by size of opened files which can be changed but not written yet
+func (s *storage) opened(rows ...*row) {
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	for _, r := range rows {
+		if n, ok := nodes.m[s.key(r.Path)]; ok {
+			r.Size = int64(len(n.content))
+		}
+	}
+}
+
+func (r *row) info() *fileInfo {
+	return &fileInfo{name: path.Base(r.Path), size: r.Size, mode: os.FileMode(r.Mode), modTime: time.Unix(0, r.Modified)}
+}
+
+//lstat - returns row of path or nil if it doesn't exist
+func (s *storage) lstat(p string) (*row, error) {
+	if p == "" {
+		return &row{Path: "", Mode: int64(os.ModeDir | 0755)}, nil
+	}
+
+	r := &row{}
+
+	err := s.db.Get(r, s.db.Rebind(fmt.Sprintf("SELECT path, mode, COALESCE(LENGTH(content), 0) AS size, modified FROM %s WHERE %s", s.table, s.where("path = ?"))), s.args(p)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.opened(r)
+
+	return r, nil
+}
+
+//resolve - follows symlinks, returns row of the final file or nil if it doesn't exist
+func (s *storage) resolve(p string) (*row, error) {
+	for i := 0; i < maxLinks; i++ {
+		r, err := s.lstat(p)
+		if err != nil || r == nil {
+			return r, err
+		}
+
+		if os.FileMode(r.Mode)&os.ModeSymlink == 0 {
+			return r, nil
+		}
+
+		target, err := s.content(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if path.IsAbs(string(target)) {
+			p = clean(string(target))
+		} else {
+			p = clean(path.Join(path.Dir(p), string(target)))
+		}
+	}
+
+	return nil, fmt.Errorf("Too many links: %s", p)
+}
+
+func (s *storage) content(p string) ([]byte, error) {
+	nodes.Lock()
+	n, ok := nodes.m[s.key(p)]
+	if ok {
+		content := append([]byte{}, n.content...)
+		nodes.Unlock()
+
+		return content, nil
+	}
+	nodes.Unlock()
+
+	var content []byte
+
+	err := s.db.Get(&content, s.db.Rebind(fmt.Sprintf("SELECT content FROM %s WHERE %s", s.table, s.where("path = ?"))), s.args(p)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+//put - writes row and creates its parent directories
+func (s *storage) put(p string, mode os.FileMode, content []byte) error {
+	err := s.mkdirAll(path.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	cols, values, key := s.insert("path", "mode", "content", "modified")
+
+	_, err = s.db.Exec(s.db.Rebind(s.dialect.upsert(s.table, cols, values, key)), s.args(p, int64(mode), content, time.Now().UnixNano())...)
+
+	return err
+}
+
+func (s *storage) mkdirAll(p string) error {
+	p = clean(p)
+	dirs := []string{}
+
+	for p != "" && p != "." {
+		dirs = append(dirs, p)
+		p = path.Dir(p)
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		r, err := s.lstat(dirs[i])
+		if err != nil {
+			return err
+		}
+
+		if r != nil {
+			if !r.info().IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dirs[i], Err: fmt.Errorf("not a directory")}
+			}
+
+			continue
+		}
+
+		cols, values, key := s.insert("path", "mode", "modified")
+
+		_, err = s.db.Exec(s.db.Rebind(s.dialect.insertIgnore(s.table, cols, values, key)), s.args(dirs[i], int64(os.ModeDir|0755), time.Now().UnixNano())...)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//escape - escapes LIKE wildcards
+func escape(p string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(p)
+}
+
+//children - returns rows of directory, directories which exist implicitly in paths of files are included
+func (s *storage) children(dir string) ([]*row, error) {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	rows := []*row{}
+
+	err := s.db.Select(&rows, s.db.Rebind(fmt.Sprintf("SELECT path, mode, COALESCE(LENGTH(content), 0) AS size, modified FROM %s WHERE %s", s.table, s.where("path LIKE ?"+s.dialect.escape()))), s.args(escape(prefix)+"%")...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*row{}
+	seen := map[string]bool{}
+
+	for _, r := range rows {
+		rest := strings.TrimPrefix(r.Path, prefix)
+
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name := prefix + rest[:i]
+			if !seen[name] {
+				seen[name] = true
+				res = append(res, &row{Path: name, Mode: int64(os.ModeDir | 0755), Modified: r.Modified})
+			}
+
+			continue
+		}
+
+		if !seen[r.Path] {
+			seen[r.Path] = true
+			res = append(res, r)
+		}
+	}
+
+	s.opened(res...)
+
+	return res, nil
+}
+
+func (s *storage) Create(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *storage) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s *storage) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	p := clean(filename)
+
+	r, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{path: p, mode: perm}
+
+	if r == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+		}
+
+		//row is created right away, so the file is seen by other handles before it's closed
+		err = s.put(p, perm, []byte{})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+		}
+
+		if r.info().IsDir() {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: fmt.Errorf("is a directory")}
+		}
+
+		n.path = r.Path
+		n.mode = os.FileMode(r.Mode)
+
+		if flag&os.O_TRUNC == 0 {
+			n.content, err = s.content(r.Path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	n.key = s.key(n.path)
+
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	//file is already opened by another handle, its content is shared
+	if opened, ok := nodes.m[n.key]; ok {
+		n = opened
+	} else {
+		nodes.m[n.key] = n
+	}
+
+	n.refs++
+
+	if flag&os.O_TRUNC != 0 {
+		n.truncate(0)
+	}
+
+	f := &file{storage: s, node: n, name: filename, flag: flag}
+
+	if flag&os.O_APPEND != 0 {
+		f.pos = int64(len(n.content))
+	}
+
+	return f, nil
+}
+
+func (s *storage) Stat(filename string) (os.FileInfo, error) {
+	r, err := s.resolve(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: os.ErrNotExist}
+	}
+
+	info := r.info()
+	info.name = path.Base(filename)
+
+	return info, nil
+}
+
+func (s *storage) Lstat(filename string) (os.FileInfo, error) {
+	r, err := s.lstat(clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return nil, &os.PathError{Op: "lstat", Path: filename, Err: os.ErrNotExist}
+	}
+
+	return r.info(), nil
+}
+
+//Rename - renames file or directory with its content, newpath is replaced if it exists
+func (s *storage) Rename(oldpath, newpath string) error {
+	from, to := clean(oldpath), clean(newpath)
+
+	r, err := s.lstat(from)
+	if err != nil {
+		return err
+	}
+
+	if r == nil || from == "" {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrNotExist}
+	}
+
+	err = s.mkdirAll(path.Dir(to))
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(tx.Rebind(fmt.Sprintf("DELETE FROM %s WHERE %s", s.table, s.where("path = ?"))), s.args(to)...)
+	if err == nil {
+		_, err = tx.Exec(tx.Rebind(fmt.Sprintf("UPDATE %s SET path = ? WHERE %s", s.table, s.where("path = ?"))), append([]interface{}{to}, s.args(from)...)...)
+	}
+
+	if err == nil && r.info().IsDir() {
+		_, err = tx.Exec(tx.Rebind(fmt.Sprintf("UPDATE %s SET path = %s WHERE %s", s.table, s.dialect.concat("?", "SUBSTR(path, ?)"), s.where("path LIKE ?"+s.dialect.escape()))),
+			append([]interface{}{to + "/", len(from) + 2}, s.args(escape(from+"/")+"%")...)...)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	//opened file is written to its new path on close
+	nodes.Lock()
+	defer nodes.Unlock()
+
+	if n, ok := nodes.m[s.key(from)]; ok {
+		delete(nodes.m, n.key)
+		n.path, n.key = to, s.key(to)
+		nodes.m[n.key] = n
+	}
+
+	return nil
+}
+
+func (s *storage) Remove(filename string) error {
+	p := clean(filename)
+
+	r, err := s.lstat(p)
+	if err != nil {
+		return err
+	}
+
+	if r == nil || p == "" {
+		return &os.PathError{Op: "remove", Path: filename, Err: os.ErrNotExist}
+	}
+
+	if r.info().IsDir() {
+		children, err := s.children(p)
+		if err != nil {
+			return err
+		}
+
+		if len(children) > 0 {
+			return &os.PathError{Op: "remove", Path: filename, Err: ErrDirNotEmpty}
+		}
+	}
+
+	_, err = s.db.Exec(s.db.Rebind(fmt.Sprintf("DELETE FROM %s WHERE %s", s.table, s.where("path = ?"))), s.args(p)...)
+
+	return err
+}
+
+func (s *storage) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (s *storage) TempFile(dir, prefix string) (billy.File, error) {
+	for i := 0; i < 100; i++ {
+		name := s.Join(dir, prefix+strconv.Itoa(rand.Int()))
+
+		f, err := s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+
+		return f, err
+	}
+
+	return nil, fmt.Errorf("Cannot create temporary file in %s", dir)
+}
+
+func (s *storage) ReadDir(dirname string) ([]os.FileInfo, error) {
+	p := clean(dirname)
+
+	r, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := s.children(p)
+	if err != nil {
+		return nil, err
+	}
+
+	//directory exists implicitly if there are files in it
+	if r == nil && len(children) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: os.ErrNotExist}
+	}
+
+	if r != nil && !r.info().IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: fmt.Errorf("not a directory")}
+	}
+
+	res := []os.FileInfo{}
+
+	for _, c := range children {
+		res = append(res, c.info())
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+
+	return res, nil
+}
+
+func (s *storage) MkdirAll(filename string, perm os.FileMode) error {
+	return s.mkdirAll(filename)
+}
+
+func (s *storage) Symlink(target, link string) error {
+	p := clean(link)
+
+	r, err := s.lstat(p)
+	if err != nil {
+		return err
+	}
+
+	if r != nil {
+		return &os.PathError{Op: "symlink", Path: link, Err: os.ErrExist}
+	}
+
+	return s.put(p, os.ModeSymlink|0777, []byte(target))
+}
+
+func (s *storage) Readlink(link string) (string, error) {
+	p := clean(link)
+
+	r, err := s.lstat(p)
+	if err != nil {
+		return "", err
+	}
+
+	if r == nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: os.ErrNotExist}
+	}
+
+	if os.FileMode(r.Mode)&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: fmt.Errorf("not a symlink")}
+	}
+
+	target, err := s.content(p)
+
+	return string(target), err
+}
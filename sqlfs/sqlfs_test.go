@@ -0,0 +1,364 @@
+package sqlfs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitbucket.org/vishjosh/bipp-go-git/go-billy"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//newTestDB - tests run against SQLite file in temp directory, PG_CONN_STRING runs them against Postgres,
+//every test has its own schema there
+func newTestDB(t *testing.T) *sqlx.DB {
+	if connStr := os.Getenv("PG_CONN_STRING"); connStr != "" {
+		return newPostgresDB(t, connStr)
+	}
+
+	dir, err := ioutil.TempDir("", "sqlfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sqlx.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.SetMaxOpenConns(1)
+
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	return db
+}
+
+//newPostgresDB - search_path is setting of connection, so the only one is kept open
+func newPostgresDB(t *testing.T, connStr string) *sqlx.DB {
+	db, err := sqlx.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	schema := fmt.Sprintf("sqlfs_test_%d", time.Now().UnixNano())
+
+	_, err = db.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_, err := db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Error(err)
+		}
+
+		db.Close()
+	})
+
+	_, err = db.Exec("SET search_path TO " + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func newTestFs(t *testing.T) billy.Filesystem {
+	fs, err := New(newTestDB(t), "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fs
+}
+
+func writeFile(t *testing.T, fs billy.Filesystem, name, data string) {
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Write([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, fs billy.Filesystem, name string) string {
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestFiles(t *testing.T) {
+	fs := newTestFs(t)
+
+	writeFile(t, fs, "dir/sub/a.txt", "hello")
+
+	if data := readFile(t, fs, "dir/sub/a.txt"); data != "hello" {
+		t.Errorf("Wrong content. Must: hello, has: %s\n", data)
+	}
+
+	fi, err := fs.Stat("dir/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi.IsDir() {
+		t.Error("Parent directories must be created")
+	}
+
+	f, err := fs.OpenFile("dir/sub/a.txt", os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte(", world"))
+	f.Close()
+
+	if data := readFile(t, fs, "dir/sub/a.txt"); data != "hello, world" {
+		t.Errorf("Wrong content. Must: hello, world, has: %s\n", data)
+	}
+
+	_, err = fs.OpenFile("dir/sub/a.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if !os.IsExist(err) {
+		t.Errorf("Existing file cannot be created exclusively: %v\n", err)
+	}
+
+	err = fs.Remove("dir/sub")
+	if err == nil {
+		t.Error("Directory with files cannot be removed")
+	}
+
+	err = fs.Remove("dir/sub/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Stat("dir/sub/a.txt")
+	if !os.IsNotExist(err) {
+		t.Errorf("Removed file must not exist: %v\n", err)
+	}
+}
+
+func TestOpenedFile(t *testing.T) {
+	fs := newTestFs(t)
+
+	w, err := fs.Create("pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write([]byte("data"))
+
+	//file which isn't closed yet is seen by other handles
+	if data := readFile(t, fs, "pack"); data != "data" {
+		t.Errorf("Wrong content of opened file. Must: data, has: %s\n", data)
+	}
+
+	fi, err := fs.Stat("pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Size() != 4 {
+		t.Errorf("Wrong size of opened file. Must: 4, has: %d\n", fi.Size())
+	}
+
+	err = fs.Rename("pack", "objects/pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write([]byte("!"))
+	w.Close()
+
+	if data := readFile(t, fs, "objects/pack"); data != "data!" {
+		t.Errorf("Renamed file must be written to new path. Must: data!, has: %s\n", data)
+	}
+}
+
+//file which is still written returns EOF only when there is nothing to read, like os.File
+func TestReadWhileWritten(t *testing.T) {
+	fs := newTestFs(t)
+
+	w, err := fs.Create("pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer w.Close()
+
+	r, err := fs.Open("pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	w.Write([]byte("da"))
+
+	b := make([]byte, 4)
+
+	n, err := r.Read(b)
+	if n != 2 || err != nil {
+		t.Errorf("Written data must be read without error. Must: 2, <nil>, has: %d, %v\n", n, err)
+	}
+
+	n, err = r.Read(b)
+	if n != 0 || err != io.EOF {
+		t.Errorf("Wrong end of file. Must: 0, EOF, has: %d, %v\n", n, err)
+	}
+
+	w.Write([]byte("ta"))
+
+	n, err = r.Read(b)
+	if n != 2 || err != nil || string(b[:n]) != "ta" {
+		t.Errorf("Data written after EOF must be read. Must: ta, has: %s, %v\n", b[:n], err)
+	}
+}
+
+func TestRenameDir(t *testing.T) {
+	fs := newTestFs(t)
+
+	writeFile(t, fs, "a/1.txt", "1")
+	writeFile(t, fs, "a/b/2.txt", "2")
+	writeFile(t, fs, "a_b/3.txt", "3")
+
+	err := fs.Rename("a", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := readFile(t, fs, "c/b/2.txt"); data != "2" {
+		t.Errorf("Wrong content. Must: 2, has: %s\n", data)
+	}
+
+	infos, err := fs.ReadDir("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+
+	//underscore is a LIKE wildcard, a_b must not be moved with a
+	if len(names) != 2 || names[0] != "a_b" || names[1] != "c" {
+		t.Errorf("Wrong root entries: %v\n", names)
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	fs := newTestFs(t)
+
+	writeFile(t, fs, "target.txt", "content")
+
+	err := fs.Symlink("target.txt", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := fs.Readlink("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target != "target.txt" {
+		t.Errorf("Wrong link target. Must: target.txt, has: %s\n", target)
+	}
+
+	if data := readFile(t, fs, "link"); data != "content" {
+		t.Errorf("Link must be followed. Must: content, has: %s\n", data)
+	}
+
+	fi, err := fs.Lstat("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("Lstat must not follow link")
+	}
+}
+
+func TestShared(t *testing.T) {
+	db := newTestDB(t)
+
+	fs1, err := NewShared(db, "files", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs2, err := NewShared(db, "files", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, fs1, "dir/a.txt", "1")
+	writeFile(t, fs2, "dir/a.txt", "2")
+
+	err = fs1.Rename("dir", "moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := readFile(t, fs1, "moved/a.txt"); data != "1" {
+		t.Errorf("Wrong content. Must: 1, has: %s\n", data)
+	}
+
+	//files of another filesystem in the same table aren't touched
+	if data := readFile(t, fs2, "dir/a.txt"); data != "2" {
+		t.Errorf("Wrong content. Must: 2, has: %s\n", data)
+	}
+
+	err = DeleteShared(db, "files", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := fs1.ReadDir("")
+	if err != nil || len(infos) != 0 {
+		t.Errorf("Removed filesystem must be empty: %v, %v\n", infos, err)
+	}
+
+	if data := readFile(t, fs2, "dir/a.txt"); data != "2" {
+		t.Errorf("Wrong content. Must: 2, has: %s\n", data)
+	}
+
+	_, err = NewShared(db, "files; DROP TABLE files", 3)
+	if err == nil {
+		t.Error("Wrong table name must be rejected")
+	}
+}